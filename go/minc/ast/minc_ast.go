@@ -0,0 +1,335 @@
+/* package ast: the abstract syntax tree of minC programs */
+package ast
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+/* Abstract Syntax Tree */
+
+/* type expression:
+
+   for now, we only have a primitive type (long),
+   which is TypePrimitive{"long"} */
+
+type TypeExpr interface {
+	TypeString() string
+}
+
+type TypePrimitive struct {
+	Name string // type name (always "long", for now)
+}
+
+/*
+variable declaration:
+
+	long x; -> Decl{TypePrimitive("long"), "x"}
+	also (ab)used to represent a function parameter
+*/
+type Decl struct {
+	VarType TypeExpr // variable type
+	Name    string   // variable name
+}
+
+/* expression */
+type Expr interface {
+	ExprString() string
+}
+
+/* 1, 2, 3, ... */
+type ExprIntLiteral struct{ Val int64 }
+
+/* x, y, z, ... */
+type ExprId struct{ Name string }
+
+/* -x, x - y, ... */
+type ExprOp struct {
+	Op   string
+	Args []Expr
+}
+
+/* f(1, 2, 3) */
+type ExprCall struct {
+	Fun  Expr
+	Args []Expr
+}
+
+/* (x + y) */
+type ExprParen struct{ SubExpr Expr }
+
+/* statement */
+type Stmt interface {
+	StmtString() string
+}
+
+/* ; */
+type StmtEmpty struct{}
+
+/* continue; */
+type StmtContinue struct{}
+
+/* break; */
+type StmtBreak struct{}
+
+/* return e; */
+type StmtReturn struct{ Expr Expr }
+
+/* f(x); */
+type StmtExpr struct{ Expr Expr }
+
+/* { int x; return x + 1; } */
+type StmtCompound struct {
+	Decls []*Decl
+	Stmts []Stmt
+}
+
+/* if (expr) stmt [else stmt] */
+type StmtIf struct {
+	Cond     Expr
+	ThenStmt Stmt
+	ElseStmt Stmt
+}
+
+/* while (expr) stmt */
+type StmtWhile struct {
+	Cond Expr
+	Body Stmt
+}
+
+/* toplevel definition */
+type Def interface {
+	DefString() string
+}
+
+/*
+function definition
+
+	e.g., long f(long x, long y) { return x; }
+*/
+type DefFun struct {
+	Name       string
+	Params     []*Decl
+	ReturnType TypeExpr
+	Body       Stmt
+}
+
+/* program is just a list of definitions */
+type Program struct {
+	Defs []Def
+}
+
+type StmtFor struct {
+	Init Stmt // 初期化式 (ExprStmt か Empty)
+	Cond Expr // 継続条件
+	Post Stmt // 後置処理  (ExprStmt か Empty)
+	Body Stmt // ループ本体
+}
+
+type StmtDeclInit struct {
+	Decl *Decl // 変数名など
+	Init Expr  // 初期値
+}
+
+/* convert ast back to C string
+
+   ExprString, StmtString, TypeString, DefString (and
+   String, of a program) convert an AST to a string
+   valid as a C program.
+
+   it will not be used anywhere in your compiler,
+   but is given for illustrating how you walk
+   the AST and what kind of C program each AST
+   is actually meant to represent.
+*/
+
+// [f(a[0]), f(a[1]), ... ]
+func MapArray[S any, T any](f func(S) T, a []S) []T {
+	b := make([]T, len(a))
+	for i, v := range a {
+		b[i] = f(v)
+	}
+	return b
+}
+
+// a[0] sep a[1] sep ... sep a[n-1]
+func concat(sep string, a []string) string {
+	n := len(a)
+	if n == 0 {
+		return ""
+	}
+	if n == 1 {
+		return a[0]
+	}
+	l := concat(sep, a[:n/2])
+	r := concat(sep, a[n/2:])
+	return fmt.Sprintf("%s%s%s", l, sep, r)
+}
+
+/*
+AST for type expression -> C string
+
+	TypeExpr::Primitive{"long"} -> "long"
+*/
+func (type_expr *TypePrimitive) TypeString() string {
+	return type_expr.Name
+}
+
+/*
+AST for function parameter -> C string
+
+	Decl{TypePrimitive("long"), "x"} -> "long x"
+*/
+func (param *Decl) ast_to_str_param() string {
+	return fmt.Sprintf("%s %s", param.VarType.TypeString(), param.Name)
+}
+
+/*
+AST for a variable declaration -> C string
+
+	Decl{TypePrimitive("long"), "x"} -> "long x;"
+*/
+func (decl *Decl) String() string {
+	return fmt.Sprintf("%s %s;", decl.VarType.TypeString(), decl.Name)
+}
+
+/* AST for an expression -> C string */
+
+/* ExprIntLiteral{123} -> "123" (LONG_MIN has no literal of its own) */
+func (expr *ExprIntLiteral) ExprString() string {
+	if expr.Val == math.MinInt64 {
+		return "(-9223372036854775807 - 1)"
+	}
+	return fmt.Sprintf("%d", expr.Val)
+}
+
+/* ExprId("x") -> "x" */
+func (expr *ExprId) ExprString() string {
+	return expr.Name
+}
+
+/* ExprOp("+" [ExprId("x"); ExprIntLiteral("123")]) -> "x + 123"
+   ExprOp("-" [ExprId("x")]) -> "-x" */
+func (expr *ExprOp) ExprString() string {
+	if len(expr.Args) == 1 {
+		arg := expr.Args[0].ExprString()
+		op, binary := expr.Args[0].(*ExprOp)
+		if (binary && len(op.Args) == 2) || strings.HasPrefix(arg, "-") || strings.HasPrefix(arg, "+") {
+			arg = "(" + arg + ")" // -(x + y), and - -x rather than --x
+		}
+		return expr.Op + arg
+	}
+	return concat(fmt.Sprintf(" %s ", expr.Op),
+		MapArray(func(e Expr) string { return e.ExprString() }, expr.Args))
+}
+
+/* ExprCall(ExprId("f"), [ExprId("x"); ExprIntLiteral("123")]) -> "f(x, 123)" */
+func (expr *ExprCall) ExprString() string {
+	fun := expr.Fun.ExprString()
+	args := concat(", ", MapArray(func(e Expr) string { return e.ExprString() }, expr.Args))
+	return fmt.Sprintf("%s(%s)", fun, args)
+}
+
+/* ExprParen(ExprOp("+", [ExprId("x"); ExprIntLiteral("123")])) -> "(x + 123)" */
+func (expr *ExprParen) ExprString() string {
+	sub_expr := expr.SubExpr.ExprString()
+	return fmt.Sprintf("(%s)", sub_expr)
+}
+
+/* AST for a statement -> C string */
+
+func (s *StmtFor) StmtString() string {
+	init := s.Init.StmtString()
+	cond := ""
+	if s.Cond != nil {
+		cond = s.Cond.ExprString()
+	}
+	post := s.Post.StmtString()
+	body := s.Body.StmtString()
+	return fmt.Sprintf("for (%s; %s; %s) %s", // “init ”末尾の ; を除外
+		strings.TrimRight(init, ";"), cond,
+		strings.TrimRight(post, ";"), body)
+}
+func (stmt *StmtContinue) StmtString() string {
+	return "continue;"
+}
+
+func (stmt *StmtBreak) StmtString() string {
+	return "break;"
+}
+
+func (stmt *StmtReturn) StmtString() string {
+	return fmt.Sprintf("return %s;", stmt.Expr.ExprString())
+}
+
+func (stmt *StmtExpr) StmtString() string {
+	return fmt.Sprintf("%s;", stmt.Expr.ExprString())
+}
+
+func (stmt *StmtCompound) StmtString() string {
+	decls := concat("\n", MapArray(func(d *Decl) string { return d.String() }, stmt.Decls))
+	stmts := concat("\n", MapArray(func(s Stmt) string { return s.StmtString() }, stmt.Stmts))
+	return fmt.Sprintf("{\n%s\n%s\n}", decls, stmts)
+}
+
+func (stmt *StmtIf) StmtString() string {
+	cond := stmt.Cond.ExprString()
+	then_stmt := stmt.ThenStmt.StmtString()
+	if stmt.ElseStmt == nil {
+		return fmt.Sprintf("if (%s) %s", cond, then_stmt)
+	} else {
+		else_stmt := stmt.ElseStmt.StmtString()
+		return fmt.Sprintf("if (%s) %s else %s", cond, then_stmt, else_stmt)
+	}
+}
+
+func (stmt *StmtWhile) StmtString() string {
+	cond := stmt.Cond.ExprString()
+	body := stmt.Body.StmtString()
+	return fmt.Sprintf("while (%s) %s", cond, body)
+}
+
+/* AST for a definition -> C string
+
+
+   DefFun{"f", [Decl(TypePrimitive("long"), "x")], TypePrimitive("long"),
+                    StmtCompound([], [])} ->
+       "long f(long x) {}"
+*/
+
+func (def *DefFun) DefString() string {
+	name := def.Name
+	params := concat(", ", MapArray(func(p *Decl) string { return p.ast_to_str_param() }, def.Params))
+	return_type := def.ReturnType.TypeString()
+	body := def.Body.StmtString()
+	return fmt.Sprintf("%s %s(%s) %s", return_type, name, params, body)
+}
+
+func (prog *Program) String() string {
+	return concat("\n", MapArray(func(def Def) string { return def.DefString() }, prog.Defs))
+}
+func (s *StmtEmpty) StmtString() string { return ";" }
+
+func (s *StmtDeclInit) StmtString() string {
+	return fmt.Sprintf("%s %s = %s;", s.Decl.VarType.TypeString(), s.Decl.Name, s.Init.ExprString())
+}
+
+/* the operators of minC (those the code generators know) */
+var UnaryOps = map[string]bool{"-": true, "+": true, "!": true, "~": true}
+var BinaryOps = map[string]bool{
+	"=": true, "||": true, "&&": true, "|": true, "^": true, "&": true,
+	"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true,
+	"<<": true, ">>": true, "+": true, "-": true, "*": true, "/": true, "%": true,
+}
+
+/* strip redundant parentheses around an expression */
+func StripParen(expr Expr) Expr {
+	for {
+		p, ok := expr.(*ExprParen)
+		if !ok {
+			return expr
+		}
+		expr = p.SubExpr
+	}
+}
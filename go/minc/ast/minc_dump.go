@@ -0,0 +1,137 @@
+package ast
+
+/* minc_dump
+
+   the AST as a tree, a node per line and its children indented
+   under it (minc --emit=ast):
+
+     DefFun f(long x) long
+       StmtCompound
+         Decl long y
+         StmtExpr
+           ExprOp =
+             ExprId y
+             ExprIntLiteral 1
+         StmtReturn
+           ExprId y
+
+   each line is the type of the node and what it holds other than
+   nodes; a for without a condition has (none) for it, an if
+   without an else has two children.
+*/
+
+import (
+	"fmt"
+	"strings"
+)
+
+type dumper struct {
+	sb    strings.Builder
+	depth int
+}
+
+func (d *dumper) line(format string, args ...interface{}) {
+	d.sb.WriteString(strings.Repeat("  ", d.depth))
+	fmt.Fprintf(&d.sb, format, args...)
+	d.sb.WriteString("\n")
+}
+
+/* line, with children (the calls of f) indented under it */
+func (d *dumper) node(f func(), format string, args ...interface{}) {
+	d.line(format, args...)
+	d.depth++
+	f()
+	d.depth--
+}
+
+func (d *dumper) expr(expr Expr) {
+	switch e := expr.(type) {
+	case nil:
+		d.line("(none)")
+	case *ExprIntLiteral:
+		d.line("ExprIntLiteral %d", e.Val)
+	case *ExprId:
+		d.line("ExprId %s", e.Name)
+	case *ExprOp:
+		d.node(func() {
+			for _, a := range e.Args {
+				d.expr(a)
+			}
+		}, "ExprOp %s", e.Op)
+	case *ExprCall:
+		d.node(func() {
+			d.expr(e.Fun)
+			for _, a := range e.Args {
+				d.expr(a)
+			}
+		}, "ExprCall")
+	case *ExprParen:
+		d.node(func() { d.expr(e.SubExpr) }, "ExprParen")
+	default:
+		d.line("%T", expr)
+	}
+}
+
+func (d *dumper) stmt(stmt Stmt) {
+	switch s := stmt.(type) {
+	case *StmtEmpty:
+		d.line("StmtEmpty")
+	case *StmtContinue:
+		d.line("StmtContinue")
+	case *StmtBreak:
+		d.line("StmtBreak")
+	case *StmtReturn:
+		d.node(func() { d.expr(s.Expr) }, "StmtReturn")
+	case *StmtExpr:
+		d.node(func() { d.expr(s.Expr) }, "StmtExpr")
+	case *StmtCompound:
+		d.node(func() {
+			for _, decl := range s.Decls {
+				d.line("Decl %s %s", decl.VarType.TypeString(), decl.Name)
+			}
+			for _, sub := range s.Stmts {
+				d.stmt(sub)
+			}
+		}, "StmtCompound")
+	case *StmtIf:
+		d.node(func() {
+			d.expr(s.Cond)
+			d.stmt(s.ThenStmt)
+			if s.ElseStmt != nil {
+				d.stmt(s.ElseStmt)
+			}
+		}, "StmtIf")
+	case *StmtWhile:
+		d.node(func() {
+			d.expr(s.Cond)
+			d.stmt(s.Body)
+		}, "StmtWhile")
+	case *StmtFor:
+		d.node(func() {
+			d.stmt(s.Init)
+			d.expr(s.Cond)
+			d.stmt(s.Post)
+			d.stmt(s.Body)
+		}, "StmtFor")
+	case *StmtDeclInit:
+		d.node(func() { d.expr(s.Init) }, "StmtDeclInit %s %s", s.Decl.VarType.TypeString(), s.Decl.Name)
+	default:
+		d.line("%T", stmt)
+	}
+}
+
+/* the tree of a program, as above */
+func Dump(program *Program) string {
+	d := &dumper{}
+	for _, def := range program.Defs {
+		switch f := def.(type) {
+		case *DefFun:
+			params := MapArray(func(p *Decl) string { return p.ast_to_str_param() }, f.Params)
+			d.node(func() { d.stmt(f.Body) }, "DefFun %s(%s) %s",
+				f.Name, strings.Join(params, ", "), f.ReturnType.TypeString())
+		default:
+			d.line("%T", def)
+		}
+	}
+	return d.sb.String()
+}
@@ -0,0 +1,220 @@
+/* package check: what is wrong with a program that parses */
+package check
+
+/* minc_check
+
+   what is checked of a program before it is compiled (Program, in minc_compile):
+   what C rejects, and the code generator would otherwise compile to
+   something all the same (a variable never declared, a break out of
+   no loop, ...):
+
+     resolve     a variable is declared before it is used, in a block
+                 enclosing the use (or as a parameter), and once in
+                 its block; a block does not declare again a variable
+                 of a block enclosing it, as C would allow (the code
+                 generator gives a function one variable of a name);
+                 a name used as a value, not called, is a variable or
+                 a function of the program; a function is defined once
+     typecheck   every type is long; = assigns to a variable; an
+                 operator is one of minC with as many operands as it
+                 takes; break and continue are in a loop
+
+   Program reports all it finds (a diagnostic each), not only
+   the first.
+*/
+
+import (
+	"fmt"
+
+	"minc/ast"
+	"minc/diag"
+)
+
+type checker struct {
+	file   string
+	funs   map[string]bool   // the functions of the program
+	fun    string            // the function being checked
+	scopes []map[string]bool // the variables of the blocks, innermost last
+	loops  int               // the loops enclosing what is being checked
+	diags  []diag.Diagnostic
+}
+
+func (c *checker) errorf(format string, args ...interface{}) {
+	c.diags = append(c.diags, diag.Diagnostic{File: c.file, Fun: c.fun, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) checkType(t ast.TypeExpr, what string) {
+	if p, ok := t.(*ast.TypePrimitive); !ok || p.Name != "long" {
+		c.errorf("%s has type %s, not long", what, t.TypeString())
+	}
+}
+
+/* declare a variable in the innermost block */
+func (c *checker) declare(d *ast.Decl) {
+	c.checkType(d.VarType, d.Name)
+	scope := c.scopes[len(c.scopes)-1]
+	if scope[d.Name] {
+		c.errorf("%s is declared twice", d.Name)
+	} else if c.declared(d.Name) {
+		c.errorf("%s is declared again in an inner block (minC does not shadow variables)", d.Name)
+	}
+	scope[d.Name] = true
+}
+
+func (c *checker) declared(name string) bool {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if c.scopes[i][name] {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *checker) enterScope() {
+	c.scopes = append(c.scopes, make(map[string]bool))
+}
+
+func (c *checker) leaveScope() {
+	c.scopes = c.scopes[:len(c.scopes)-1]
+}
+
+func (c *checker) expr(expr ast.Expr) {
+	switch e := expr.(type) {
+	case *ast.ExprIntLiteral:
+	case *ast.ExprId:
+		if !c.declared(e.Name) && !c.funs[e.Name] {
+			c.errorf("undeclared variable %s", e.Name)
+		}
+	case *ast.ExprParen:
+		c.expr(e.SubExpr)
+	case *ast.ExprCall:
+		/* a function called by name need not be of the program (it is linked) */
+		if _, ok := ast.StripParen(e.Fun).(*ast.ExprId); !ok {
+			c.expr(e.Fun)
+		}
+		for _, arg := range e.Args {
+			c.expr(arg)
+		}
+	case *ast.ExprOp:
+		switch {
+		case len(e.Args) == 1 && ast.UnaryOps[e.Op]: // in minc_ast.go
+		case len(e.Args) == 2 && ast.BinaryOps[e.Op]:
+		default:
+			c.errorf("operator %s with %d operands", e.Op, len(e.Args))
+		}
+		if e.Op == "=" && len(e.Args) == 2 {
+			id, ok := ast.StripParen(e.Args[0]).(*ast.ExprId)
+			if !ok {
+				c.errorf("assignment to %s, not a variable", e.Args[0].ExprString())
+			} else if !c.declared(id.Name) {
+				c.errorf("assignment to undeclared variable %s", id.Name)
+			}
+			c.expr(e.Args[1])
+			return
+		}
+		for _, arg := range e.Args {
+			c.expr(arg)
+		}
+	default:
+		c.errorf("unknown expression %T", expr)
+	}
+}
+
+func (c *checker) stmt(stmt ast.Stmt) {
+	switch s := stmt.(type) {
+	case *ast.StmtEmpty:
+	case *ast.StmtContinue:
+		if c.loops == 0 {
+			c.errorf("continue is not in a loop")
+		}
+	case *ast.StmtBreak:
+		if c.loops == 0 {
+			c.errorf("break is not in a loop")
+		}
+	case *ast.StmtReturn:
+		if s.Expr != nil {
+			c.expr(s.Expr)
+		}
+	case *ast.StmtExpr:
+		c.expr(s.Expr)
+	case *ast.StmtDeclInit:
+		c.expr(s.Init)
+		c.declare(s.Decl)
+	case *ast.StmtCompound:
+		c.enterScope()
+		c.block(s)
+		c.leaveScope()
+	case *ast.StmtIf:
+		c.expr(s.Cond)
+		c.stmt(s.ThenStmt)
+		if s.ElseStmt != nil {
+			c.stmt(s.ElseStmt)
+		}
+	case *ast.StmtWhile:
+		c.expr(s.Cond)
+		c.loops++
+		c.stmt(s.Body)
+		c.loops--
+	case *ast.StmtFor:
+		/* what init declares is the loop's */
+		c.enterScope()
+		c.stmt(s.Init)
+		if s.Cond != nil {
+			c.expr(s.Cond)
+		}
+		c.stmt(s.Post)
+		c.loops++
+		c.stmt(s.Body)
+		c.loops--
+		c.leaveScope()
+	default:
+		c.errorf("unknown statement %T", stmt)
+	}
+}
+
+/* the declarations and statements of a block, in the innermost scope */
+func (c *checker) block(s *ast.StmtCompound) {
+	for _, decl := range s.Decls {
+		c.declare(decl)
+	}
+	for _, sub := range s.Stmts {
+		c.stmt(sub)
+	}
+}
+
+func (c *checker) function(fun *ast.DefFun) {
+	c.fun = fun.Name
+	c.checkType(fun.ReturnType, "the return value")
+	/* the parameters are in the scope of the outermost block, as in C */
+	c.scopes = nil
+	c.enterScope()
+	for _, param := range fun.Params {
+		c.declare(param)
+	}
+	if body, ok := fun.Body.(*ast.StmtCompound); ok {
+		c.block(body)
+	} else {
+		c.stmt(fun.Body)
+	}
+	c.leaveScope()
+	c.fun = ""
+}
+
+/* check a program (read from file, for the diagnostics) */
+func Program(program *ast.Program, file string) error {
+	c := &checker{file: file, funs: make(map[string]bool)}
+	for _, def := range program.Defs {
+		if fun, ok := def.(*ast.DefFun); ok {
+			if c.funs[fun.Name] {
+				c.errorf("function %s is defined twice", fun.Name)
+			}
+			c.funs[fun.Name] = true
+		}
+	}
+	for _, def := range program.Defs {
+		if fun, ok := def.(*ast.DefFun); ok {
+			c.function(fun)
+		}
+	}
+	return diag.CompileError(c.diags)
+}
@@ -0,0 +1,376 @@
+package codegen
+
+/* minc_aarch64
+
+   the AArch64 target: --target=aarch64-linux (the default) for ELF
+   and the GNU assembler, --target=arm64-apple-darwin for Mach-O and
+   the Apple assembler. the instructions are the same for both;
+   symbols, sections and relocations are written differently:
+
+                      aarch64-linux        arm64-apple-darwin
+     function f       f                    _f
+     local labels     .L.end.1             L.end.1
+     address of f     adrp x0, f           adrp x0, _f@PAGE
+                      add x0, x0, :lo12:f  add x0, x0, _f@PAGEOFF
+
+   the accumulator is x0 and the second register x1. the frame is
+   laid out below x29 (the frame pointer) as
+
+     x29 + 16 + 8k    argument 9 + k (passed on the stack)
+     x29              x29 and x30 of the caller
+     below            the scratch area, a 16-byte slot for each
+                      value pushed, as many as the function pushes
+                      at once (CodeGen.maxDepth)
+     sp + 8n + ...    local variables (n parameters)
+     sp + 8i          parameter i < 8 (stored from x0 ... x7)
+
+   arguments are passed in x0 ... x7, the others on the stack.
+   minc_imm.go has the constants and offsets that do not fit in an
+   instruction, minc_peephole.go the peephole optimizer.
+*/
+
+import (
+	"math"
+
+	"minc/ast"
+)
+
+type AArch64 struct {
+	darwin      bool // Mach-O rather than ELF
+	frameSize   int
+	scratchBase int // offset from sp of the area push/pop use
+	spAdjust    int // bytes sp is moved down for outgoing stack arguments
+}
+
+func newAArch64(darwin bool) *AArch64 {
+	return &AArch64{darwin: darwin}
+}
+
+var aarch64ABI = ABI{
+	ArgRegs:    []string{"x0", "x1", "x2", "x3", "x4", "x5", "x6", "x7"},
+	wordSize:   8,
+	stackAlign: 16,
+}
+
+var aarch64Dialect = Dialect{
+	header:      []string{".data", ".text"},
+	localPrefix: ".L",
+	typeDirs:    true,
+}
+
+var aarch64DarwinDialect = Dialect{
+	header:       []string{".section __TEXT,__text,regular,pure_instructions", ".p2align 2"},
+	footer:       []string{".subsections_via_symbols"},
+	localPrefix:  "L",
+	symbolPrefix: "_",
+}
+
+func (t *AArch64) abi() *ABI { return &aarch64ABI }
+
+func (t *AArch64) dialect() *Dialect {
+	if t.darwin {
+		return &aarch64DarwinDialect
+	}
+	return &aarch64Dialect
+}
+
+/* the size of a slot of the scratch area push and pop use */
+const aarch64ScratchSlot = 16
+
+func (t *AArch64) prologue(g *CodeGen) {
+	g.emit("stp", "x29", "x30", "[sp, #-16]!")
+	g.emit("mov", "x29", "sp")
+
+	t.scratchBase = alignTo(len(g.params)*8+g.localVars.stackSize, 16)
+	t.frameSize = t.scratchBase + aarch64ScratchSlot*g.maxDepth
+	t.spAdjust = 0
+	if t.frameSize > 0 {
+		t.addImm(g, "sp", "sp", -int64(t.frameSize))
+	}
+
+	for i := range g.params {
+		if i < len(aarch64ABI.ArgRegs) {
+			t.store(g, aarch64ABI.ArgRegs[i], "sp", i*8)
+		}
+	}
+}
+
+func (t *AArch64) epilogue(g *CodeGen) {
+	if t.frameSize > 0 {
+		t.addImm(g, "sp", "sp", int64(t.frameSize))
+	}
+	g.emit("ldp", "x29", "x30", "[sp]", "#16")
+}
+
+func (t *AArch64) ret(g *CodeGen) {
+	t.epilogue(g)
+	g.emit("ret")
+}
+
+func (t *AArch64) loadImm(g *CodeGen, v int64) {
+	t.movImm(g, "x0", v)
+}
+
+/* x0 = the address of sym: its 4 KiB page (adrp), plus the offset in it */
+func (t *AArch64) loadAddr(g *CodeGen, sym string) {
+	if t.darwin {
+		g.emit("adrp", "x0", sym+"@PAGE")
+		g.emit("add", "x0", "x0", sym+"@PAGEOFF")
+	} else {
+		g.emit("adrp", "x0", sym)
+		g.emit("add", "x0", "x0", ":lo12:"+sym)
+	}
+}
+
+/* base register and offset of a variable */
+func (t *AArch64) varAddr(g *CodeGen, v varRef) (string, int) {
+	switch {
+	case v.param >= 0 && v.param < 8:
+		return "x29", v.param*8 - t.frameSize
+	case v.param >= 8:
+		return "x29", 16 + 8*(v.param-8)
+	}
+	/* offset from sp of a local variable */
+	return "sp", len(g.params)*8 + v.local - 8 + t.spAdjust
+}
+
+func (t *AArch64) loadVar(g *CodeGen, v varRef) {
+	base, offset := t.varAddr(g, v)
+	t.load(g, "x0", base, offset)
+}
+
+func (t *AArch64) storeVar(g *CodeGen, v varRef) {
+	base, offset := t.varAddr(g, v)
+	t.store(g, "x0", base, offset)
+}
+
+func (t *AArch64) push(g *CodeGen) {
+	offset := t.scratchBase + aarch64ScratchSlot*g.depth + t.spAdjust
+	t.store(g, "x0", "sp", offset)
+	g.depth++
+	if g.depth > g.maxDepth {
+		g.maxDepth = g.depth
+	}
+}
+
+func (t *AArch64) pop(g *CodeGen, reg string) {
+	g.depth--
+	offset := t.scratchBase + aarch64ScratchSlot*g.depth + t.spAdjust
+	t.load(g, reg, "sp", offset)
+}
+
+func (t *AArch64) popOperand(g *CodeGen) {
+	g.emit("mov", "x1", "x0")
+	t.pop(g, "x0")
+}
+
+func (t *AArch64) unaryOp(g *CodeGen, op string) {
+	switch op {
+	case "-":
+		g.emit("neg", "x0", "x0")
+	case "!":
+		g.emit("cmp", "x0", "#0")
+		g.emit("cset", "x0", "eq")
+	case "~":
+		g.emit("mvn", "x0", "x0")
+	}
+}
+
+var aarch64CondCodes = map[string]string{
+	"==": "eq", "!=": "ne", "<": "lt", "<=": "le", ">": "gt", ">=": "ge",
+}
+
+func (t *AArch64) binaryOp(g *CodeGen, op string) {
+	if cc, ok := aarch64CondCodes[op]; ok {
+		g.emit("cmp", "x0", "x1")
+		g.emit("cset", "x0", cc)
+		return
+	}
+	switch op {
+	case "+":
+		g.emit("add", "x0", "x0", "x1")
+	case "-":
+		g.emit("sub", "x0", "x0", "x1")
+	case "*":
+		g.emit("mul", "x0", "x0", "x1")
+	case "/":
+		g.emit("sdiv", "x0", "x0", "x1")
+	case "%":
+		g.emit("sdiv", "x2", "x0", "x1")
+		g.emit("msub", "x0", "x2", "x1", "x0")
+	case "<<":
+		g.emit("lsl", "x0", "x0", "x1")
+	case ">>":
+		g.emit("asr", "x0", "x0", "x1")
+	case "&":
+		g.emit("and", "x0", "x0", "x1")
+	case "|":
+		g.emit("orr", "x0", "x0", "x1")
+	case "^":
+		g.emit("eor", "x0", "x0", "x1")
+	}
+}
+
+func (t *AArch64) jump(g *CodeGen, label string) {
+	g.emit("b", label)
+}
+
+func (t *AArch64) branchZero(g *CodeGen, label string, jumpIf bool) {
+	if jumpIf {
+		g.emit("cbnz", "x0", label)
+	} else {
+		g.emit("cbz", "x0", label)
+	}
+}
+
+/*
+a < b        cmp + b.lt (b.ge when jumping if false)
+a & 8        tbnz x0, #3 (tbz)
+*/
+func (t *AArch64) branchOp(g *CodeGen, e *ast.ExprOp, label string, jumpIf bool) bool {
+	if len(e.Args) != 2 {
+		return false
+	}
+	if e.Op == "&" {
+		bit := singleBit(e.Args[1])
+		if bit < 0 {
+			return false
+		}
+		g.genExpr(e.Args[0])
+		if jumpIf {
+			g.emit("tbnz", "x0", imm(int64(bit)), label)
+		} else {
+			g.emit("tbz", "x0", imm(int64(bit)), label)
+		}
+		return true
+	}
+	cc, ok := aarch64CondCodes[e.Op]
+	if !ok {
+		return false
+	}
+	t.compare(g, e.Args[0], e.Args[1])
+	if !jumpIf {
+		cc = invertedCond[cc]
+	}
+	g.emit("b."+cc, label)
+	return true
+}
+
+/* k if e is the literal 2^k, -1 otherwise */
+func singleBit(e ast.Expr) int {
+	v, ok := literalValue(e)
+	if !ok {
+		return -1
+	}
+	if v == 1 {
+		return 0
+	}
+	if v == math.MinInt64 {
+		return 63
+	}
+	return log2Exact(v)
+}
+
+/* set the flags comparing left with right (cmp left, right) */
+func (t *AArch64) compare(g *CodeGen, left, right ast.Expr) {
+	if v, ok := literalValue(right); ok && v >= -4095 && v <= 4095 {
+		g.genExpr(left)
+		if v >= 0 {
+			g.emit("cmp", "x0", imm(v))
+		} else {
+			g.emit("cmn", "x0", imm(-v))
+		}
+		return
+	}
+	g.genOperands(left, right)
+	g.emit("cmp", "x0", "x1")
+}
+
+/*
+evaluate the arguments into x0 ... x7 and, from the ninth on, the
+stack. returns the bytes sp was moved down for them
+*/
+func (t *AArch64) pushArgs(g *CodeGen, args []ast.Expr) int {
+	nregs := len(aarch64ABI.ArgRegs)
+	stackArgSize := 0
+	if len(args) > nregs {
+		stackArgSize = alignTo((len(args)-nregs)*8, aarch64ABI.stackAlign)
+		t.addImm(g, "sp", "sp", -int64(stackArgSize))
+		t.spAdjust += stackArgSize
+		for i := nregs; i < len(args); i++ {
+			g.genExpr(args[i])
+			t.store(g, "x0", "sp", (i-nregs)*8)
+		}
+	}
+
+	for i := 0; i < len(args) && i < nregs; i++ {
+		g.genExpr(args[i])
+		t.push(g)
+	}
+
+	for i := min(len(args), nregs) - 1; i >= 0; i-- {
+		t.pop(g, aarch64ABI.ArgRegs[i])
+	}
+
+	return stackArgSize
+}
+
+func (t *AArch64) call(g *CodeGen, call *ast.ExprCall) {
+	stackArgSize := t.pushArgs(g, call.Args)
+
+	if funId, ok := call.Fun.(*ast.ExprId); ok {
+		g.emit("bl", g.symbol(funId.Name))
+	} else {
+		g.genExpr(call.Fun)
+		g.emit("blr", "x0")
+	}
+
+	if stackArgSize > 0 {
+		t.addImm(g, "sp", "sp", int64(stackArgSize))
+		t.spAdjust -= stackArgSize
+	}
+}
+
+/*
+return f(...); needs nothing of the caller's frame once the arguments
+are evaluated, so the frame is released and f is entered with b: it
+returns directly to our caller, and a chain of such calls uses no
+stack at all. a call to the function itself stores the arguments
+over the parameters and jumps back to the start of the body, making
+the recursion a loop. only calls passing all arguments in registers
+are handled; returns false for the others
+*/
+func (t *AArch64) tailCall(g *CodeGen, call *ast.ExprCall) bool {
+	funId, ok := call.Fun.(*ast.ExprId)
+	nregs := len(aarch64ABI.ArgRegs)
+	if !ok || len(call.Args) > nregs {
+		return false
+	}
+	t.pushArgs(g, call.Args)
+	if funId.Name == g.funName && len(g.params) <= nregs {
+		for i := range call.Args {
+			t.store(g, aarch64ABI.ArgRegs[i], "sp", i*8)
+		}
+		g.emit("b", g.tailLabel)
+		return true
+	}
+	t.epilogue(g)
+	g.emit("b", g.symbol(funId.Name))
+	return true
+}
+
+func (t *AArch64) peephole(code []*Instr) []*Instr {
+	return peephole_optimize(code)
+}
+
+/* load dst from [base + offset] (offsets out of range go through x9) */
+func (t *AArch64) load(g *CodeGen, dst, base string, offset int) {
+	addr := t.memAddr(g, base, offset) // in minc_imm.go
+	g.emit("ldr", dst, addr)
+}
+
+/* store src to [base + offset] (offsets out of range go through x9) */
+func (t *AArch64) store(g *CodeGen, src, base string, offset int) {
+	addr := t.memAddr(g, base, offset)
+	g.emit("str", src, addr)
+}
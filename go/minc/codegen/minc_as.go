@@ -0,0 +1,571 @@
+package codegen
+
+/* minc_as
+
+   the assembler: AArch64 instructions (minc_asm) to machine code,
+   for minc -c without an external assembler. it knows the
+   instructions the AArch64 target emits, not the whole instruction
+   set.
+
+   every instruction is 4 bytes, so the offset of each label is known
+   before anything is encoded:
+
+     pass 1   the offset of every label
+     pass 2   the encoding of every instruction; branches to local
+              labels (.L...) get their distance, references to
+              functions become relocations for the linker:
+
+                bl f                 R_AARCH64_CALL26 f
+                b f (tail call)      R_AARCH64_JUMP26 f
+                adrp x0, f           R_AARCH64_ADR_PREL_PG_HI21 f
+                add x0, x0, :lo12:f  R_AARCH64_ADD_ABS_LO12_NC f
+
+   the result is an Object (the code, its symbols and relocations),
+   which minc_elf writes as an ELF relocatable file.
+*/
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/* machine code with what a linker needs to place it */
+type Object struct {
+	text    []byte       // the .text section
+	symbols []*ObjSymbol // defined in the order they appear, then the undefined ones
+	relocs  []*Reloc     // in .text
+}
+
+type ObjSymbol struct {
+	name    string
+	value   uint64 // offset in .text
+	size    uint64
+	defined bool
+	global  bool
+	isFunc  bool
+}
+
+/* patch the instruction at offset with the address of sym */
+type Reloc struct {
+	offset uint64
+	sym    string
+	typ    elf.R_AARCH64
+	addend int64
+}
+
+/* assembling state: where the labels are and what was produced */
+type assembler struct {
+	obj     *Object
+	labels  map[string]uint64 // the offset of every label
+	symbols map[string]*ObjSymbol
+	pc      uint64 // offset of the instruction being encoded
+}
+
+/* an error in the assembly, with the instruction it is in */
+type asmError struct {
+	in  *Instr
+	msg string
+}
+
+func (e *asmError) Error() string {
+	return fmt.Sprintf("assembler: %s: %s", strings.TrimSpace(e.in.String()), e.msg)
+}
+
+func (a *assembler) errorf(in *Instr, format string, args ...interface{}) {
+	panic(&asmError{in, fmt.Sprintf(format, args...)})
+}
+
+/* is a label local (no symbol in the object)? */
+func isLocalLabel(name string) bool {
+	return strings.HasPrefix(name, ".L")
+}
+
+/* the symbol of a name, created (undefined) on first use */
+func (a *assembler) symbol(name string) *ObjSymbol {
+	sym, ok := a.symbols[name]
+	if !ok {
+		sym = &ObjSymbol{name: name}
+		a.symbols[name] = sym
+		a.obj.symbols = append(a.obj.symbols, sym)
+	}
+	return sym
+}
+
+/* does the assembler assemble the code of target? (not Mach-O, nor other machines) */
+func CanAssemble(t Target) bool {
+	a, ok := t.(*AArch64)
+	return ok && !a.darwin
+}
+
+/* assemble instructions into an object */
+func Assemble(code []*Instr) (obj *Object, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*asmError)
+			if !ok {
+				panic(r)
+			}
+			obj, err = nil, e
+		}
+	}()
+	a := &assembler{
+		obj:     &Object{},
+		labels:  make(map[string]uint64),
+		symbols: make(map[string]*ObjSymbol),
+	}
+	a.layout(code)
+	for _, in := range code {
+		if in.kind == instrOp {
+			word := a.encode(in)
+			var buf [4]byte
+			binary.LittleEndian.PutUint32(buf[:], word)
+			a.obj.text = append(a.obj.text, buf[:]...)
+			a.pc += 4
+		}
+	}
+	a.sizeFunctions()
+	/* defined symbols first */
+	var defined, undefined []*ObjSymbol
+	for _, sym := range a.obj.symbols {
+		if sym.defined {
+			defined = append(defined, sym)
+		} else {
+			undefined = append(undefined, sym)
+		}
+	}
+	a.obj.symbols = append(defined, undefined...)
+	return a.obj, nil
+}
+
+/* pass 1: the offsets of the labels, the symbols and the directives */
+func (a *assembler) layout(code []*Instr) {
+	section := ".text"
+	pc := uint64(0)
+	for _, in := range code {
+		switch in.kind {
+		case instrOp:
+			if section != ".text" {
+				a.errorf(in, "instruction outside .text")
+			}
+			pc += 4
+		case instrLabel:
+			if _, dup := a.labels[in.op]; dup {
+				a.errorf(in, "label %s defined twice", in.op)
+			}
+			a.labels[in.op] = pc
+			if !isLocalLabel(in.op) {
+				sym := a.symbol(in.op)
+				sym.defined = true
+				sym.value = pc
+			}
+		case instrDirective:
+			fields := strings.Fields(strings.ReplaceAll(in.op, ",", " "))
+			switch fields[0] {
+			case ".text", ".data":
+				section = fields[0]
+			case ".globl":
+				a.symbol(fields[1]).global = true
+			case ".type":
+				if len(fields) == 3 && fields[2] == "@function" {
+					a.symbol(fields[1]).isFunc = true
+				}
+			default:
+				a.errorf(in, "unknown directive")
+			}
+		}
+	}
+}
+
+/* a function extends to the next one or the end of the code */
+func (a *assembler) sizeFunctions() {
+	end := uint64(len(a.obj.text))
+	for i := len(a.obj.symbols) - 1; i >= 0; i-- {
+		sym := a.obj.symbols[i]
+		if sym.defined {
+			sym.size = end - sym.value
+			end = sym.value
+		}
+	}
+}
+
+/* --- operands --- */
+
+/* the number of a register: x0 ... x30, and sp or xzr (31) where allowed */
+func (a *assembler) reg(in *Instr, arg string, sp bool) uint32 {
+	r := operandReg(arg) // in minc_asm.go
+	switch {
+	case r == "" || (arg[0] == 'w' && r != "xzr"):
+		a.errorf(in, "expected a 64-bit register, found '%s'", arg)
+	case r == "sp" && !sp:
+		a.errorf(in, "sp is not allowed here")
+	case r == "xzr" && sp:
+		a.errorf(in, "xzr is not allowed here")
+	case r == "sp" || r == "xzr":
+		return 31
+	}
+	n, _ := strconv.Atoi(r[1:])
+	return uint32(n)
+}
+
+/* #123, #-4 or #0x1f */
+func (a *assembler) imm(in *Instr, arg string) int64 {
+	if !strings.HasPrefix(arg, "#") {
+		a.errorf(in, "expected an immediate, found '%s'", arg)
+	}
+	v, err := strconv.ParseInt(arg[1:], 0, 64)
+	if err != nil {
+		/* a 64-bit pattern written unsigned */
+		u, err := strconv.ParseUint(arg[1:], 0, 64)
+		if err != nil {
+			a.errorf(in, "bad immediate '%s'", arg)
+		}
+		v = int64(u)
+	}
+	return v
+}
+
+/* lsl #n, the shift of a movz or an add */
+func (a *assembler) shift(in *Instr, arg string) int64 {
+	if !strings.HasPrefix(arg, "lsl ") {
+		a.errorf(in, "expected lsl #n, found '%s'", arg)
+	}
+	return a.imm(in, strings.TrimSpace(arg[4:]))
+}
+
+func (a *assembler) nargs(in *Instr, n int) {
+	if len(in.args) != n {
+		a.errorf(in, "expected %d operands", n)
+	}
+}
+
+var condCodes = map[string]uint32{
+	"eq": 0, "ne": 1, "hs": 2, "lo": 3, "mi": 4, "pl": 5, "vs": 6, "vc": 7,
+	"hi": 8, "ls": 9, "ge": 10, "lt": 11, "gt": 12, "le": 13, "al": 14,
+}
+
+func (a *assembler) cond(in *Instr, cc string) uint32 {
+	c, ok := condCodes[cc]
+	if !ok {
+		a.errorf(in, "unknown condition '%s'", cc)
+	}
+	return c
+}
+
+/*
+the distance in instructions to a local label, which must fit in
+bits (signed); a function becomes a relocation of type reloc
+*/
+func (a *assembler) branchTarget(in *Instr, label string, bits uint, reloc elf.R_AARCH64) uint32 {
+	if !isLocalLabel(label) {
+		if reloc == elf.R_AARCH64_NONE {
+			a.errorf(in, "cannot branch to symbol %s", label)
+		}
+		a.relocate(label, reloc)
+		return 0
+	}
+	target, ok := a.labels[label]
+	if !ok {
+		a.errorf(in, "undefined label %s", label)
+	}
+	delta := (int64(target) - int64(a.pc)) / 4
+	if delta < -(1<<(bits-1)) || delta >= 1<<(bits-1) {
+		a.errorf(in, "branch to %s out of range", label)
+	}
+	return uint32(delta) & (1<<bits - 1)
+}
+
+func (a *assembler) relocate(sym string, typ elf.R_AARCH64) {
+	a.symbol(sym)
+	a.obj.relocs = append(a.obj.relocs, &Reloc{offset: a.pc, sym: sym, typ: typ})
+}
+
+/* --- encodings --- */
+
+/* add/sub (immediate): op is 0x91000000 (add), 0xd1000000 (sub), with S for adds/subs */
+func (a *assembler) encodeAddSubImm(in *Instr, op uint32, rd, rn uint32, args []string) uint32 {
+	v := a.imm(in, args[0])
+	sh := uint32(0)
+	if len(args) == 2 {
+		switch a.shift(in, args[1]) {
+		case 0:
+		case 12:
+			sh = 1
+		default:
+			a.errorf(in, "the shift must be 0 or 12")
+		}
+	}
+	if v < 0 || v > 4095 {
+		a.errorf(in, "immediate out of range")
+	}
+	return op | sh<<22 | uint32(v)<<10 | rn<<5 | rd
+}
+
+/* the instructions on three registers: op Rd, Rn, Rm */
+var regOps = map[string]uint32{
+	"add":  0x8b000000,
+	"sub":  0xcb000000,
+	"and":  0x8a000000,
+	"orr":  0xaa000000,
+	"eor":  0xca000000,
+	"mul":  0x9b007c00, // madd Rd, Rn, Rm, xzr
+	"sdiv": 0x9ac00c00,
+	"lsl":  0x9ac02000, // lslv
+	"asr":  0x9ac02800, // asrv
+}
+
+/* the encoding of a logical immediate (N:immr:imms), and whether v has one */
+func logicalImmFields(v uint64) (uint32, bool) {
+	if !isLogicalImm(v) { // in minc_imm.go
+		return 0, false
+	}
+	size := uint(64)
+	for size > 2 {
+		half := size / 2
+		mask := uint64(1)<<half - 1
+		if v&mask != (v>>half)&mask {
+			break
+		}
+		size = half
+	}
+	mask := ^uint64(0)
+	if size < 64 {
+		mask = uint64(1)<<size - 1
+	}
+	elem := v & mask
+	ones := 0
+	for e := elem; e != 0; e >>= 1 {
+		ones += int(e & 1)
+	}
+	run := uint64(1)<<uint(ones) - 1
+	/* elem is the run of ones rotated right by immr */
+	for immr := uint(0); immr < size; immr++ {
+		rot := ((run >> immr) | (run << (size - immr))) & mask
+		if rot == elem {
+			n := uint32(0)
+			if size == 64 {
+				n = 1
+			}
+			imms := (^uint32(size-1)<<1 | uint32(ones-1)) & 0x3f
+			return n<<22 | uint32(immr)<<16 | imms<<10, true
+		}
+	}
+	return 0, false
+}
+
+/* mov Rd, #v: movz, movn or orr with a logical immediate, as the assemblers choose */
+func (a *assembler) encodeMovImm(in *Instr, rd uint32, v int64) uint32 {
+	u := uint64(v)
+	for k := 0; k < 4; k++ {
+		if u&^(0xffff<<(16*uint(k))) == 0 {
+			return 0xd2800000 | uint32(k)<<21 | uint32(chunk16(u, k))<<5 | rd
+		}
+	}
+	for k := 0; k < 4; k++ {
+		if ^u&^(0xffff<<(16*uint(k))) == 0 {
+			return 0x92800000 | uint32(k)<<21 | uint32(chunk16(^u, k))<<5 | rd
+		}
+	}
+	if fields, ok := logicalImmFields(u); ok {
+		return 0xb2000000 | fields | 31<<5 | rd // orr Rd, xzr, #v
+	}
+	a.errorf(in, "immediate cannot be moved in one instruction")
+	return 0
+}
+
+/* ldr/str Rt, [Rn, #off]: scaled (ldr/str) or unscaled (ldur/stur) offset */
+func (a *assembler) encodeLoadStore(in *Instr, load bool) uint32 {
+	a.nargs(in, 2)
+	rt := a.reg(in, in.args[0], false)
+	base, off, ok := memOperand(in.args[1]) // in minc_asm.go
+	if !ok {
+		a.errorf(in, "expected [base, #offset]")
+	}
+	rn := a.reg(in, base, true)
+	switch {
+	case off >= 0 && off <= 32760 && off%8 == 0:
+		op := uint32(0xf9000000)
+		if load {
+			op = 0xf9400000
+		}
+		return op | uint32(off/8)<<10 | rn<<5 | rt
+	case off >= -256 && off <= 255:
+		op := uint32(0xf8000000)
+		if load {
+			op = 0xf8400000
+		}
+		return op | (uint32(off)&0x1ff)<<12 | rn<<5 | rt
+	}
+	a.errorf(in, "offset out of range")
+	return 0
+}
+
+/* stp Rt1, Rt2, [sp, #-16]! and ldp Rt1, Rt2, [sp], #16 */
+func (a *assembler) encodePair(in *Instr, load bool) uint32 {
+	var rn uint32
+	var off int64
+	var op uint32
+	switch {
+	case !load && len(in.args) == 3 && strings.HasSuffix(in.args[2], "]!"):
+		base, o, ok := memOperand(strings.TrimSuffix(in.args[2], "!"))
+		if !ok {
+			a.errorf(in, "expected [base, #offset]!")
+		}
+		rn, off, op = a.reg(in, base, true), int64(o), 0xa9800000 // pre-index
+	case load && len(in.args) == 4:
+		base, o, ok := memOperand(in.args[2])
+		if !ok || o != 0 {
+			a.errorf(in, "expected [base], #offset")
+		}
+		rn, off, op = a.reg(in, base, true), a.imm(in, in.args[3]), 0xa8c00000 // post-index
+	default:
+		a.errorf(in, "addressing mode not supported")
+	}
+	if off%8 != 0 || off < -512 || off > 504 {
+		a.errorf(in, "offset out of range")
+	}
+	rt1 := a.reg(in, in.args[0], false)
+	rt2 := a.reg(in, in.args[1], false)
+	return op | (uint32(off/8)&0x7f)<<15 | rt2<<10 | rn<<5 | rt1
+}
+
+/* the machine code of one instruction */
+func (a *assembler) encode(in *Instr) uint32 {
+	args := in.args
+	if cc, ok := branchCond(in.op); ok && strings.HasPrefix(in.op, "b.") {
+		a.nargs(in, 1)
+		return 0x54000000 | a.branchTarget(in, args[0], 19, elf.R_AARCH64_NONE)<<5 | a.cond(in, cc)
+	}
+	switch in.op {
+	case "ret":
+		return 0xd65f03c0
+	case "svc":
+		a.nargs(in, 1)
+		v := a.imm(in, args[0])
+		if v < 0 || v > 0xffff {
+			a.errorf(in, "immediate out of range")
+		}
+		return 0xd4000001 | uint32(v)<<5
+	case "b":
+		a.nargs(in, 1)
+		return 0x14000000 | a.branchTarget(in, args[0], 26, elf.R_AARCH64_JUMP26)
+	case "bl":
+		a.nargs(in, 1)
+		return 0x94000000 | a.branchTarget(in, args[0], 26, elf.R_AARCH64_CALL26)
+	case "blr":
+		a.nargs(in, 1)
+		return 0xd63f0000 | a.reg(in, args[0], false)<<5
+	case "cbz", "cbnz":
+		a.nargs(in, 2)
+		op := uint32(0xb4000000)
+		if in.op == "cbnz" {
+			op = 0xb5000000
+		}
+		return op | a.branchTarget(in, args[1], 19, elf.R_AARCH64_NONE)<<5 | a.reg(in, args[0], false)
+	case "tbz", "tbnz":
+		a.nargs(in, 3)
+		op := uint32(0x36000000)
+		if in.op == "tbnz" {
+			op = 0x37000000
+		}
+		bit := a.imm(in, args[1])
+		if bit < 0 || bit > 63 {
+			a.errorf(in, "bit out of range")
+		}
+		b := uint32(bit)
+		return op | (b>>5)<<31 | (b&31)<<19 | a.branchTarget(in, args[2], 14, elf.R_AARCH64_NONE)<<5 | a.reg(in, args[0], false)
+	case "adrp":
+		a.nargs(in, 2)
+		a.relocate(args[1], elf.R_AARCH64_ADR_PREL_PG_HI21)
+		return 0x90000000 | a.reg(in, args[0], false)
+	case "mov":
+		a.nargs(in, 2)
+		if strings.HasPrefix(args[1], "#") {
+			return a.encodeMovImm(in, a.reg(in, args[0], false), a.imm(in, args[1]))
+		}
+		if args[0] == "sp" || args[1] == "sp" {
+			return 0x91000000 | a.reg(in, args[1], true)<<5 | a.reg(in, args[0], true) // add Rd, Rn, #0
+		}
+		return 0xaa0003e0 | a.reg(in, args[1], false)<<16 | a.reg(in, args[0], false) // orr Rd, xzr, Rm
+	case "movz", "movn", "movk":
+		if len(args) != 3 && len(args) != 2 {
+			a.errorf(in, "expected 2 or 3 operands")
+		}
+		v := a.imm(in, args[1])
+		sh := int64(0)
+		if len(args) == 3 {
+			sh = a.shift(in, args[2])
+		}
+		if v < 0 || v > 0xffff || sh%16 != 0 || sh < 0 || sh > 48 {
+			a.errorf(in, "immediate out of range")
+		}
+		op := map[string]uint32{"movz": 0xd2800000, "movn": 0x92800000, "movk": 0xf2800000}[in.op]
+		return op | uint32(sh/16)<<21 | uint32(v)<<5 | a.reg(in, args[0], false)
+	case "add", "sub":
+		if len(args) < 3 {
+			a.errorf(in, "expected 3 operands")
+		}
+		if strings.HasPrefix(args[2], ":lo12:") {
+			a.nargs(in, 3)
+			a.relocate(strings.TrimPrefix(args[2], ":lo12:"), elf.R_AARCH64_ADD_ABS_LO12_NC)
+			return 0x91000000 | a.reg(in, args[1], true)<<5 | a.reg(in, args[0], true)
+		}
+		if strings.HasPrefix(args[2], "#") {
+			op := uint32(0x91000000)
+			if in.op == "sub" {
+				op = 0xd1000000
+			}
+			return a.encodeAddSubImm(in, op, a.reg(in, args[0], true), a.reg(in, args[1], true), args[2:])
+		}
+		a.nargs(in, 3)
+		if args[0] == "sp" || args[1] == "sp" {
+			/* the extended register form, the one that can name sp (uxtx) */
+			op := uint32(0x8b206000)
+			if in.op == "sub" {
+				op = 0xcb206000
+			}
+			return op | a.reg(in, args[2], false)<<16 | a.reg(in, args[1], true)<<5 | a.reg(in, args[0], true)
+		}
+	case "cmp", "cmn":
+		a.nargs(in, 2)
+		if strings.HasPrefix(args[1], "#") {
+			op := uint32(0xf1000000) // subs
+			if in.op == "cmn" {
+				op = 0xb1000000 // adds
+			}
+			return a.encodeAddSubImm(in, op, 31, a.reg(in, args[0], true), args[1:])
+		}
+		if in.op == "cmp" {
+			return 0xeb00001f | a.reg(in, args[1], false)<<16 | a.reg(in, args[0], false)<<5 // subs xzr, Rn, Rm
+		}
+	case "neg":
+		a.nargs(in, 2)
+		return 0xcb0003e0 | a.reg(in, args[1], false)<<16 | a.reg(in, args[0], false) // sub Rd, xzr, Rm
+	case "mvn":
+		a.nargs(in, 2)
+		return 0xaa2003e0 | a.reg(in, args[1], false)<<16 | a.reg(in, args[0], false) // orn Rd, xzr, Rm
+	case "msub":
+		a.nargs(in, 4)
+		return 0x9b008000 | a.reg(in, args[2], false)<<16 | a.reg(in, args[3], false)<<10 |
+			a.reg(in, args[1], false)<<5 | a.reg(in, args[0], false)
+	case "cset":
+		a.nargs(in, 2)
+		inv, ok := invertedCond[args[1]] // in minc_asm.go
+		if !ok {
+			a.errorf(in, "unknown condition '%s'", args[1])
+		}
+		return 0x9a9f07e0 | a.cond(in, inv)<<12 | a.reg(in, args[0], false) // csinc Rd, xzr, xzr, !cc
+	case "ldr", "str":
+		return a.encodeLoadStore(in, in.op == "ldr")
+	case "stp", "ldp":
+		return a.encodePair(in, in.op == "ldp")
+	}
+	if op, ok := regOps[in.op]; ok {
+		a.nargs(in, 3)
+		return op | a.reg(in, args[2], false)<<16 | a.reg(in, args[1], false)<<5 | a.reg(in, args[0], false)
+	}
+	a.errorf(in, "instruction not supported")
+	return 0
+}
@@ -0,0 +1,226 @@
+package codegen
+
+/* minc_asm
+
+   assembly code as data: the code generator produces a list of
+   instructions, labels and directives rather than text, so that
+   later passes (minc_peephole) can inspect and rewrite it. the
+   targets build them (CodeGen.emit); ParseAsm reads them from
+   assembly text (minc -c file.s).
+
+     ldr x0, [sp, #24]   -> Instr{kind: instrOp, op: "ldr", args: ["x0", "[sp, #24]"]}
+     .L.begin.1:         -> Instr{kind: instrLabel, op: ".L.begin.1"}
+     .globl f            -> Instr{kind: instrDirective, op: ".globl f"}
+*/
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type instrKind int
+
+const (
+	instrOp        instrKind = iota // an instruction
+	instrLabel                      // name:
+	instrDirective                  // .text, .globl f, ...
+)
+
+type Instr struct {
+	kind instrKind
+	op   string   // mnemonic, label name or the whole directive
+	args []string // operands, as written
+}
+
+/* parse one line of assembly */
+func parseInstr(line string) *Instr {
+	line = strings.TrimSpace(line)
+	if strings.HasSuffix(line, ":") {
+		return &Instr{kind: instrLabel, op: strings.TrimSuffix(line, ":")}
+	}
+	if strings.HasPrefix(line, ".") {
+		return &Instr{kind: instrDirective, op: line}
+	}
+	in := &Instr{kind: instrOp, op: line}
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		in.op = line[:i]
+		in.args = splitOperands(line[i+1:])
+	}
+	return in
+}
+
+/* parse assembly text, one instruction, label or directive per line */
+func ParseAsm(text string) []*Instr {
+	var code []*Instr
+	for _, line := range strings.Split(text, "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) != "" {
+			code = append(code, parseInstr(line))
+		}
+	}
+	return code
+}
+
+/* split operands at the commas that are not inside [...] */
+func splitOperands(s string) []string {
+	var args []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(args, strings.TrimSpace(s[start:]))
+}
+
+func (in *Instr) String() string {
+	switch in.kind {
+	case instrLabel:
+		return in.op + ":"
+	case instrDirective:
+		return in.op
+	}
+	if len(in.args) == 0 {
+		return "  " + in.op
+	}
+	return "  " + in.op + " " + strings.Join(in.args, ", ")
+}
+
+/* the text of a list of instructions */
+func InstrsToString(code []*Instr) string {
+	var sb strings.Builder
+	for _, in := range code {
+		sb.WriteString(in.String())
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+/* --- operands --- */
+
+/* the register an operand names, as its 64-bit x name ("" if none) */
+func operandReg(arg string) string {
+	switch arg {
+	case "sp", "xzr":
+		return arg
+	case "wzr":
+		return "xzr"
+	}
+	if len(arg) >= 2 && (arg[0] == 'x' || arg[0] == 'w') {
+		if n, err := strconv.Atoi(arg[1:]); err == nil && n >= 0 && n <= 30 {
+			return fmt.Sprintf("x%d", n)
+		}
+	}
+	return ""
+}
+
+/* base register and offset of a memory operand [base] or [base, #off] */
+func memOperand(arg string) (string, int, bool) {
+	if !strings.HasPrefix(arg, "[") || !strings.HasSuffix(arg, "]") {
+		return "", 0, false
+	}
+	parts := splitOperands(arg[1 : len(arg)-1])
+	base := operandReg(parts[0])
+	if base == "" {
+		return "", 0, false
+	}
+	switch len(parts) {
+	case 1:
+		return base, 0, true
+	case 2:
+		if off, err := strconv.Atoi(strings.TrimPrefix(parts[1], "#")); err == nil {
+			return base, off, true
+		}
+	}
+	return "", 0, false
+}
+
+/* the condition of a conditional branch (beq or b.eq) */
+func branchCond(op string) (string, bool) {
+	if strings.HasPrefix(op, "b.") {
+		return op[2:], true
+	}
+	switch op {
+	case "beq", "bne", "blt", "bge", "bgt", "ble":
+		return op[1:], true
+	}
+	return "", false
+}
+
+var invertedCond = map[string]string{
+	"eq": "ne", "ne": "eq",
+	"lt": "ge", "ge": "lt",
+	"gt": "le", "le": "gt",
+	"hi": "ls", "ls": "hi",
+	"hs": "lo", "lo": "hs",
+	"mi": "pl", "pl": "mi",
+}
+
+/* instructions whose first operand is the only thing they write */
+var defOps = map[string]bool{
+	"mov": true, "mvn": true, "neg": true, "ldr": true,
+	"add": true, "sub": true, "mul": true, "sdiv": true, "msub": true,
+	"lsl": true, "asr": true, "and": true, "orr": true, "eor": true,
+	"cset": true,
+}
+
+/*
+the registers an instruction reads and writes; the condition flags
+are the register "nzcv". ok is false for the instructions these are
+not known for, control flow included
+*/
+func (in *Instr) regEffects() (reads, writes []string, ok bool) {
+	if in.kind != instrOp {
+		return nil, nil, false
+	}
+	regsOf := func(args []string) []string {
+		var regs []string
+		for _, a := range args {
+			if r := operandReg(a); r != "" {
+				regs = append(regs, r)
+			} else if base, _, ok := memOperand(a); ok {
+				regs = append(regs, base)
+			}
+		}
+		return regs
+	}
+	switch in.op {
+	case "str":
+		if len(in.args) != 2 {
+			return nil, nil, false
+		}
+		return regsOf(in.args), nil, true
+	case "cmp":
+		return regsOf(in.args), []string{"nzcv"}, true
+	case "cset":
+		return []string{"nzcv"}, regsOf(in.args[:1]), true
+	case "ldr":
+		if len(in.args) != 2 {
+			return nil, nil, false
+		}
+	}
+	if defOps[in.op] && len(in.args) >= 2 {
+		return regsOf(in.args[1:]), regsOf(in.args[:1]), true
+	}
+	return nil, nil, false
+}
+
+func containsReg(regs []string, reg string) bool {
+	for _, r := range regs {
+		if r == reg {
+			return true
+		}
+	}
+	return false
+}
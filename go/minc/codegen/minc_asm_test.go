@@ -0,0 +1,67 @@
+package codegen
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"minc/parse"
+)
+
+/* compile each minC program of test/src for target at -O0 and -O2 */
+func forTestSrc(t *testing.T, target string, f func(file string, level int, code []*Instr)) {
+	files, err := filepath.Glob("../../../test/src/f*.c")
+	if err != nil || len(files) == 0 {
+		t.Fatal("no tests in test/src")
+	}
+	tg, err := LookupTarget(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, level := range []int{0, 2} {
+			program, err := parse.C(string(src), file)
+			if err != nil {
+				break
+			}
+			opts, err := DefaultOptions(level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			RunPasses(program, opts, &PassTimer{})
+			code, err := Generate(program, tg, true, true)
+			if err != nil {
+				continue
+			}
+			f(file, level, code)
+		}
+	}
+}
+
+/*
+the instructions the targets build are those their text reads back
+as, so that minc -c file.s assembles what minc -c file.c does
+*/
+func TestInstrsParseBack(t *testing.T) {
+	for _, name := range TargetNames() {
+		forTestSrc(t, name, func(file string, level int, code []*Instr) {
+			if back := ParseAsm(InstrsToString(code)); !reflect.DeepEqual(back, code) {
+				for i := range code {
+					if i >= len(back) {
+						t.Errorf("%s, %s, -O%d: %#v is not read back", name, file, level, code[i])
+						break
+					}
+					if !reflect.DeepEqual(back[i], code[i]) {
+						t.Errorf("%s, %s, -O%d: %#v reads back as %#v", name, file, level, code[i], back[i])
+						break
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,613 @@
+package codegen
+
+/* minc_cfg
+
+   control flow graph of a function, built on top of the AST.
+
+   a basic block holds a list of nodes, each of which is either
+   a simple statement (expression statement, declaration with an
+   initializer, return) or the condition of an if/while/for.
+   nodes point back into the AST, so a pass can analyze the graph
+   and then rewrite the expressions it refers to in place.
+
+     long f(long x) {        entry: [x > 0]  -> B1, B2
+       if (x > 0)            B1:    [return 1] -> exit
+         return 1;           B2:    [return 2] -> exit
+       return 2;
+     }
+
+   conditions that are integer literals (after minc_fold) only get
+   the edge that is actually taken, so the branch that is never
+   executed becomes unreachable from the entry block.
+*/
+
+import (
+	"sort"
+
+	"minc/ast"
+)
+
+/* a statement, or the condition of a branching statement */
+type CfgNode struct {
+	stmt ast.Stmt
+}
+
+type BasicBlock struct {
+	id    int
+	nodes []*CfgNode
+	succs []*BasicBlock
+	preds []*BasicBlock
+}
+
+type CFG struct {
+	fun    *ast.DefFun
+	entry  *BasicBlock
+	exit   *BasicBlock
+	blocks []*BasicBlock
+	/* the block control is in when a statement starts executing */
+	stmt_block map[ast.Stmt]*BasicBlock
+}
+
+/* the expression evaluated by a node (nil for an empty statement) */
+func (n *CfgNode) expr() ast.Expr {
+	switch s := n.stmt.(type) {
+	case *ast.StmtExpr:
+		return s.Expr
+	case *ast.StmtDeclInit:
+		return s.Init
+	case *ast.StmtReturn:
+		return s.Expr
+	case *ast.StmtIf:
+		return s.Cond
+	case *ast.StmtWhile:
+		return s.Cond
+	case *ast.StmtFor:
+		return s.Cond
+	}
+	return nil
+}
+
+/* replace the expression evaluated by a node */
+func (n *CfgNode) setExpr(e ast.Expr) {
+	switch s := n.stmt.(type) {
+	case *ast.StmtExpr:
+		s.Expr = e
+	case *ast.StmtDeclInit:
+		s.Init = e
+	case *ast.StmtReturn:
+		s.Expr = e
+	case *ast.StmtIf:
+		s.Cond = e
+	case *ast.StmtWhile:
+		s.Cond = e
+	case *ast.StmtFor:
+		s.Cond = e
+	}
+}
+
+/* is this node the condition of a branch (rather than a statement)? */
+func (n *CfgNode) isBranch() bool {
+	switch n.stmt.(type) {
+	case *ast.StmtIf, *ast.StmtWhile, *ast.StmtFor:
+		return true
+	}
+	return false
+}
+
+type cfgLoop struct {
+	break_target    *BasicBlock
+	continue_target *BasicBlock
+}
+
+type cfgBuilder struct {
+	cfg   *CFG
+	cur   *BasicBlock
+	loops []cfgLoop
+}
+
+func (b *cfgBuilder) newBlock() *BasicBlock {
+	bb := &BasicBlock{id: len(b.cfg.blocks)}
+	b.cfg.blocks = append(b.cfg.blocks, bb)
+	return bb
+}
+
+func addEdge(from, to *BasicBlock) {
+	from.succs = append(from.succs, to)
+	to.preds = append(to.preds, from)
+}
+
+func (b *cfgBuilder) addNode(stmt ast.Stmt) {
+	b.cur.nodes = append(b.cur.nodes, &CfgNode{stmt})
+}
+
+/* control leaves the current block for good (return, break, continue) */
+func (b *cfgBuilder) jump(to *BasicBlock) {
+	addEdge(b.cur, to)
+	b.cur = b.newBlock()
+}
+
+/*
+the edges a condition can take: (may be true, may be false).
+a missing condition (for (;;)) is always true
+*/
+func condEdges(cond ast.Expr) (bool, bool) {
+	if cond == nil {
+		return true, false
+	}
+	if v, ok := literalValue(cond); ok {
+		return v != 0, v == 0
+	}
+	return true, true
+}
+
+func (b *cfgBuilder) buildStmt(stmt ast.Stmt) {
+	b.cfg.stmt_block[stmt] = b.cur
+	switch s := stmt.(type) {
+	case *ast.StmtEmpty:
+	case *ast.StmtExpr, *ast.StmtDeclInit:
+		b.addNode(s)
+	case *ast.StmtReturn:
+		b.addNode(s)
+		b.jump(b.cfg.exit)
+	case *ast.StmtBreak:
+		if n := len(b.loops); n > 0 {
+			b.jump(b.loops[n-1].break_target)
+		}
+	case *ast.StmtContinue:
+		if n := len(b.loops); n > 0 {
+			b.jump(b.loops[n-1].continue_target)
+		}
+	case *ast.StmtCompound:
+		for _, sub := range s.Stmts {
+			b.buildStmt(sub)
+		}
+	case *ast.StmtIf:
+		b.addNode(s)
+		cond_block := b.cur
+		may_true, may_false := condEdges(s.Cond)
+		join := b.newBlock()
+		b.cur = b.newBlock()
+		if may_true {
+			addEdge(cond_block, b.cur)
+		}
+		b.buildStmt(s.ThenStmt)
+		addEdge(b.cur, join)
+		if s.ElseStmt != nil {
+			b.cur = b.newBlock()
+			if may_false {
+				addEdge(cond_block, b.cur)
+			}
+			b.buildStmt(s.ElseStmt)
+			addEdge(b.cur, join)
+		} else if may_false {
+			addEdge(cond_block, join)
+		}
+		b.cur = join
+	case *ast.StmtWhile:
+		header := b.newBlock()
+		addEdge(b.cur, header)
+		b.cur = header
+		b.addNode(s)
+		b.buildLoop(header, s.Cond, s.Body, nil, header)
+	case *ast.StmtFor:
+		b.buildStmt(s.Init)
+		header := b.newBlock()
+		addEdge(b.cur, header)
+		b.cur = header
+		b.addNode(s)
+		post := b.newBlock()
+		b.buildLoop(header, s.Cond, s.Body, s.Post, post)
+	}
+}
+
+/*
+the part shared by while and for: header (holding the condition)
+-> body -> [post] -> header, and header -> exit
+*/
+func (b *cfgBuilder) buildLoop(header *BasicBlock, cond ast.Expr, body ast.Stmt, post ast.Stmt, cont *BasicBlock) {
+	may_true, may_false := condEdges(cond)
+	exit := b.newBlock()
+	if may_false {
+		addEdge(header, exit)
+	}
+	b.cur = b.newBlock()
+	if may_true {
+		addEdge(header, b.cur)
+	}
+	b.loops = append(b.loops, cfgLoop{exit, cont})
+	b.buildStmt(body)
+	b.loops = b.loops[:len(b.loops)-1]
+	if post != nil {
+		addEdge(b.cur, cont)
+		b.cur = cont
+		b.buildStmt(post)
+	}
+	addEdge(b.cur, header)
+	b.cur = exit
+}
+
+/* build the control flow graph of a function */
+func buildCFG(fun *ast.DefFun) *CFG {
+	cfg := &CFG{fun: fun, stmt_block: make(map[ast.Stmt]*BasicBlock)}
+	b := &cfgBuilder{cfg: cfg}
+	cfg.entry = b.newBlock()
+	cfg.exit = b.newBlock()
+	b.cur = cfg.entry
+	b.buildStmt(fun.Body)
+	/* falling off the end of the function */
+	addEdge(b.cur, cfg.exit)
+	return cfg
+}
+
+/* the set of blocks reachable from the entry block */
+func (cfg *CFG) reachable() map[*BasicBlock]bool {
+	seen := make(map[*BasicBlock]bool)
+	work := []*BasicBlock{cfg.entry}
+	seen[cfg.entry] = true
+	for len(work) > 0 {
+		bb := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, s := range bb.succs {
+			if !seen[s] {
+				seen[s] = true
+				work = append(work, s)
+			}
+		}
+	}
+	return seen
+}
+
+/* --- variables read and written by expressions --- */
+
+/* names of the variables an expression reads */
+func exprUses(expr ast.Expr, uses map[string]bool) {
+	switch e := expr.(type) {
+	case *ast.ExprId:
+		uses[e.Name] = true
+	case *ast.ExprParen:
+		exprUses(e.SubExpr, uses)
+	case *ast.ExprCall:
+		if _, ok := e.Fun.(*ast.ExprId); !ok {
+			exprUses(e.Fun, uses)
+		}
+		for _, arg := range e.Args {
+			exprUses(arg, uses)
+		}
+	case *ast.ExprOp:
+		if e.Op == "=" {
+			if _, ok := ast.StripParen(e.Args[0]).(*ast.ExprId); ok {
+				exprUses(e.Args[1], uses)
+				return
+			}
+		}
+		for _, arg := range e.Args {
+			exprUses(arg, uses)
+		}
+	}
+}
+
+/*
+names of the variables an expression always writes.
+an assignment in the right operand of && or || may not be executed,
+so it does not count
+*/
+func exprDefs(expr ast.Expr, defs map[string]bool) {
+	switch e := expr.(type) {
+	case *ast.ExprParen:
+		exprDefs(e.SubExpr, defs)
+	case *ast.ExprCall:
+		for _, arg := range e.Args {
+			exprDefs(arg, defs)
+		}
+	case *ast.ExprOp:
+		if e.Op == "&&" || e.Op == "||" {
+			exprDefs(e.Args[0], defs)
+			return
+		}
+		if e.Op == "=" {
+			if id, ok := ast.StripParen(e.Args[0]).(*ast.ExprId); ok {
+				defs[id.Name] = true
+			}
+		}
+		for _, arg := range e.Args {
+			exprDefs(arg, defs)
+		}
+	}
+}
+
+/* names of all variables an expression may write */
+func exprAssigned(expr ast.Expr, vars map[string]bool) {
+	switch e := expr.(type) {
+	case *ast.ExprParen:
+		exprAssigned(e.SubExpr, vars)
+	case *ast.ExprCall:
+		for _, arg := range e.Args {
+			exprAssigned(arg, vars)
+		}
+	case *ast.ExprOp:
+		if e.Op == "=" {
+			if id, ok := ast.StripParen(e.Args[0]).(*ast.ExprId); ok {
+				vars[id.Name] = true
+			}
+		}
+		for _, arg := range e.Args {
+			exprAssigned(arg, vars)
+		}
+	}
+}
+
+/* variables a node reads */
+func (n *CfgNode) uses() map[string]bool {
+	uses := make(map[string]bool)
+	if e := n.expr(); e != nil {
+		exprUses(e, uses)
+	}
+	return uses
+}
+
+/* variables a node always writes */
+func (n *CfgNode) defs() map[string]bool {
+	defs := make(map[string]bool)
+	if e := n.expr(); e != nil {
+		exprDefs(e, defs)
+	}
+	if s, ok := n.stmt.(*ast.StmtDeclInit); ok {
+		defs[s.Decl.Name] = true
+	}
+	return defs
+}
+
+/* variables a node may write */
+func (n *CfgNode) assigned() map[string]bool {
+	vars := make(map[string]bool)
+	if e := n.expr(); e != nil {
+		exprAssigned(e, vars)
+	}
+	if s, ok := n.stmt.(*ast.StmtDeclInit); ok {
+		vars[s.Decl.Name] = true
+	}
+	return vars
+}
+
+/*
+live variable analysis.
+returns the set of variables live at the end of each block
+*/
+func (cfg *CFG) liveOut() map[*BasicBlock]map[string]bool {
+	live_in := make(map[*BasicBlock]map[string]bool)
+	live_out := make(map[*BasicBlock]map[string]bool)
+	for _, bb := range cfg.blocks {
+		live_in[bb] = make(map[string]bool)
+		live_out[bb] = make(map[string]bool)
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := len(cfg.blocks) - 1; i >= 0; i-- {
+			bb := cfg.blocks[i]
+			out := live_out[bb]
+			for _, s := range bb.succs {
+				for v := range live_in[s] {
+					if !out[v] {
+						out[v] = true
+						changed = true
+					}
+				}
+			}
+			live := copySet(out)
+			for j := len(bb.nodes) - 1; j >= 0; j-- {
+				transferLive(bb.nodes[j], live)
+			}
+			for v := range live {
+				if !live_in[bb][v] {
+					live_in[bb][v] = true
+					changed = true
+				}
+			}
+		}
+	}
+	return live_out
+}
+
+/* live variables before a node, given those after it (updated in place) */
+func transferLive(n *CfgNode, live map[string]bool) {
+	for v := range n.defs() {
+		delete(live, v)
+	}
+	for v := range n.uses() {
+		live[v] = true
+	}
+}
+
+func copySet(s map[string]bool) map[string]bool {
+	c := make(map[string]bool, len(s))
+	for k, v := range s {
+		c[k] = v
+	}
+	return c
+}
+
+/* --- dominators --- */
+
+/* reachable blocks in reverse postorder (entry first) */
+func (cfg *CFG) reversePostorder() []*BasicBlock {
+	seen := make(map[*BasicBlock]bool)
+	var post []*BasicBlock
+	var visit func(bb *BasicBlock)
+	visit = func(bb *BasicBlock) {
+		seen[bb] = true
+		for _, s := range bb.succs {
+			if !seen[s] {
+				visit(s)
+			}
+		}
+		post = append(post, bb)
+	}
+	visit(cfg.entry)
+	rpo := make([]*BasicBlock, len(post))
+	for i, bb := range post {
+		rpo[len(post)-1-i] = bb
+	}
+	return rpo
+}
+
+/*
+immediate dominators of the reachable blocks (the entry block has
+none), by the iterative algorithm of Cooper, Harvey and Kennedy
+*/
+func (cfg *CFG) dominators() map[*BasicBlock]*BasicBlock {
+	rpo := cfg.reversePostorder()
+	order := make(map[*BasicBlock]int)
+	for i, bb := range rpo {
+		order[bb] = i
+	}
+	idom := map[*BasicBlock]*BasicBlock{cfg.entry: cfg.entry}
+	intersect := func(a, b *BasicBlock) *BasicBlock {
+		for a != b {
+			for order[a] > order[b] {
+				a = idom[a]
+			}
+			for order[b] > order[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, bb := range rpo[1:] {
+			var new_idom *BasicBlock
+			for _, p := range bb.preds {
+				if _, ok := idom[p]; !ok {
+					continue
+				}
+				if new_idom == nil {
+					new_idom = p
+				} else {
+					new_idom = intersect(p, new_idom)
+				}
+			}
+			if idom[bb] != new_idom {
+				idom[bb] = new_idom
+				changed = true
+			}
+		}
+	}
+	delete(idom, cfg.entry)
+	return idom
+}
+
+/* does a dominate b? (idom as returned by dominators) */
+func dominates(idom map[*BasicBlock]*BasicBlock, a, b *BasicBlock) bool {
+	for {
+		if a == b {
+			return true
+		}
+		d, ok := idom[b]
+		if !ok {
+			return false
+		}
+		b = d
+	}
+}
+
+/*
+the blocks a path from dom to bb may go through after leaving dom
+and before entering bb for the last time (bb itself included when
+it is on a cycle that avoids dom)
+*/
+func (cfg *CFG) pathBlocks(dom, bb *BasicBlock) map[*BasicBlock]bool {
+	from := make(map[*BasicBlock]bool)
+	work := append([]*BasicBlock{}, dom.succs...)
+	for len(work) > 0 {
+		x := work[len(work)-1]
+		work = work[:len(work)-1]
+		if x == dom || from[x] {
+			continue
+		}
+		from[x] = true
+		work = append(work, x.succs...)
+	}
+	to := make(map[*BasicBlock]bool)
+	work = append([]*BasicBlock{}, bb.preds...)
+	for len(work) > 0 {
+		x := work[len(work)-1]
+		work = work[:len(work)-1]
+		if x == dom || to[x] {
+			continue
+		}
+		to[x] = true
+		work = append(work, x.preds...)
+	}
+	path := make(map[*BasicBlock]bool)
+	for x := range from {
+		if to[x] {
+			path[x] = true
+		}
+	}
+	return path
+}
+
+/* --- natural loops --- */
+
+/*
+a natural loop: the header and the blocks that reach a back edge
+into it without going through the header. stmt is the while or for
+statement whose condition the header evaluates
+*/
+type NaturalLoop struct {
+	header *BasicBlock
+	blocks map[*BasicBlock]bool
+	stmt   ast.Stmt
+}
+
+/* natural loops of a function, outermost (largest) first (idom: its dominators) */
+func (cfg *CFG) naturalLoops(idom map[*BasicBlock]*BasicBlock) []*NaturalLoop {
+	reach := cfg.reachable()
+	loops := make(map[*BasicBlock]*NaturalLoop)
+	var order []*NaturalLoop
+	for _, bb := range cfg.blocks {
+		if !reach[bb] {
+			continue
+		}
+		for _, h := range bb.succs {
+			if !dominates(idom, h, bb) {
+				continue
+			}
+			/* bb -> h is a back edge */
+			loop, ok := loops[h]
+			if !ok {
+				loop = &NaturalLoop{header: h, blocks: map[*BasicBlock]bool{h: true}}
+				if len(h.nodes) > 0 {
+					loop.stmt = h.nodes[0].stmt
+				}
+				loops[h] = loop
+				order = append(order, loop)
+			}
+			work := []*BasicBlock{bb}
+			for len(work) > 0 {
+				x := work[len(work)-1]
+				work = work[:len(work)-1]
+				if loop.blocks[x] {
+					continue
+				}
+				loop.blocks[x] = true
+				work = append(work, x.preds...)
+			}
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return len(order[i].blocks) > len(order[j].blocks)
+	})
+	return order
+}
+
+/* the nodes of a loop, in block order */
+func (loop *NaturalLoop) nodes(cfg *CFG) []*CfgNode {
+	var nodes []*CfgNode
+	for _, bb := range cfg.blocks {
+		if loop.blocks[bb] {
+			nodes = append(nodes, bb.nodes...)
+		}
+	}
+	return nodes
+}
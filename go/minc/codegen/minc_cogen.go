@@ -0,0 +1,460 @@
+/* package codegen: the passes, the code generators, and the AArch64 assembler and linker */
+package codegen
+
+/* minc_cogen
+
+   the code generator: the part of it that is the same for every
+   machine. it walks the functions and decides the order things are
+   computed in and where control goes; the instructions themselves
+   come from a Target (minc_target.go), such as minc_aarch64.go.
+*/
+
+import (
+	"fmt"
+	"strconv"
+
+	"minc/ast"
+	"minc/diag"
+)
+
+type CodeGen struct {
+	target     Target
+	code       []*Instr // in minc_asm.go
+	depth      int      // values pushed (the target decides where)
+	maxDepth   int      // the most values pushed at once in the function (sizeFunction)
+	labelCount int
+	funName    string
+	functions  map[string]bool // the functions of the program
+	params     []string
+	localVars  *LocalVars
+	tailLabel  string // label at the start of the body, for self tail calls
+	tailCalls  bool
+	loops      []loopLabels      // enclosing loops, innermost last
+	diags      []diag.Diagnostic // what it could not compile (in minc_diag.go)
+}
+
+/* where break and continue jump in a loop */
+type loopLabels struct {
+	brk  string
+	cont string
+}
+
+func newCodeGen(target Target) *CodeGen {
+	return &CodeGen{
+		target:     target,
+		code:       nil,
+		depth:      0,
+		labelCount: 0,
+	}
+}
+
+/* append an instruction: emit("add", "x0", "x0", "x1") is add x0, x0, x1 */
+func (cg *CodeGen) emit(op string, args ...string) {
+	cg.code = append(cg.code, &Instr{kind: instrOp, op: op, args: args})
+}
+
+/* append a directive (.globl f, ...) */
+func (cg *CodeGen) directive(format string, args ...interface{}) {
+	cg.code = append(cg.code, &Instr{kind: instrDirective, op: fmt.Sprintf(format, args...)})
+}
+
+func (cg *CodeGen) count() int {
+	cg.labelCount++
+	return cg.labelCount
+}
+
+/* the local label kind.n (.L.kind.n on ELF) */
+func (cg *CodeGen) label(kind string, n int) string {
+	return fmt.Sprintf("%s.%s.%d", cg.target.dialect().localPrefix, kind, n)
+}
+
+func (cg *CodeGen) emitLabel(label string) {
+	cg.code = append(cg.code, &Instr{kind: instrLabel, op: label})
+}
+
+/* what cannot be compiled; minc_check should have found it already */
+func (cg *CodeGen) errorf(format string, args ...interface{}) {
+	cg.diags = append(cg.diags, diag.Diagnostic{Fun: cg.funName, Msg: fmt.Sprintf(format, args...)})
+}
+
+/* the assembler symbol of a function */
+func (cg *CodeGen) symbol(name string) string {
+	return cg.target.dialect().symbolPrefix + name
+}
+
+type LocalVars struct {
+	variables map[string]int
+	stackSize int
+	slotSize  int
+}
+
+func newLocalVars(slotSize int) *LocalVars {
+	return &LocalVars{
+		variables: make(map[string]int),
+		stackSize: 0,
+		slotSize:  slotSize,
+	}
+}
+
+func (lv *LocalVars) addVariable(name string) int {
+	lv.stackSize += lv.slotSize
+	offset := lv.stackSize
+	lv.variables[name] = offset
+	return offset
+}
+
+func (lv *LocalVars) getOffset(name string) (int, bool) {
+	offset, exists := lv.variables[name]
+	return offset, exists
+}
+
+/* the parameter or local variable a name refers to */
+func (cg *CodeGen) lookupVar(name string) (varRef, bool) {
+	if i := getParamIndex(name, cg.params); i >= 0 {
+		return varRef{param: i}, true
+	}
+	if offset, exists := cg.localVars.getOffset(name); exists {
+		return varRef{param: -1, local: offset}, true
+	}
+	return varRef{}, false
+}
+
+func alignTo(n, align int) int {
+	return (n + align - 1) / align * align
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (cg *CodeGen) genExpr(expr ast.Expr) {
+	switch e := expr.(type) {
+	case *ast.ExprIntLiteral:
+		cg.target.loadImm(cg, e.Val)
+
+	case *ast.ExprId:
+		if v, ok := cg.lookupVar(e.Name); ok {
+			cg.target.loadVar(cg, v)
+		} else if cg.functions[e.Name] {
+			cg.target.loadAddr(cg, cg.symbol(e.Name))
+		} else {
+			cg.errorf("undeclared variable %s", e.Name)
+		}
+
+	case *ast.ExprOp:
+		if len(e.Args) == 1 {
+			cg.genExpr(e.Args[0])
+			cg.target.unaryOp(cg, e.Op)
+		} else if len(e.Args) == 2 {
+			cg.genBinaryOp(e.Op, e.Args[0], e.Args[1])
+		} else {
+			cg.errorf("operator %s with %d operands", e.Op, len(e.Args))
+		}
+
+	case *ast.ExprCall:
+		cg.target.call(cg, e)
+
+	case *ast.ExprParen:
+		cg.genExpr(e.SubExpr)
+	}
+}
+
+/* compute left into the accumulator and right into the second register */
+func (cg *CodeGen) genOperands(left, right ast.Expr) {
+	cg.genExpr(left)
+	cg.target.push(cg)
+	cg.genExpr(right)
+	cg.target.popOperand(cg)
+}
+
+func (cg *CodeGen) genBinaryOp(op string, left, right ast.Expr) {
+	switch op {
+	case "&&", "||":
+		/* the right operand is evaluated only if the left one does not decide */
+		c := cg.count()
+		cg.genBranch(&ast.ExprOp{Op: op, Args: []ast.Expr{left, right}}, cg.label("false", c), false)
+		cg.target.loadImm(cg, 1)
+		cg.target.jump(cg, cg.label("end", c))
+		cg.emitLabel(cg.label("false", c))
+		cg.target.loadImm(cg, 0)
+		cg.emitLabel(cg.label("end", c))
+
+	case "=":
+		cg.genExpr(right)
+		leftId, ok := ast.StripParen(left).(*ast.ExprId)
+		if !ok {
+			cg.errorf("assignment to %s, not a variable", left.ExprString())
+		} else if v, ok := cg.lookupVar(leftId.Name); ok {
+			cg.target.storeVar(cg, v)
+		} else {
+			cg.errorf("assignment to undeclared variable %s", leftId.Name)
+		}
+
+	default:
+		cg.genOperands(left, right)
+		cg.target.binaryOp(cg, op)
+	}
+}
+
+/*
+jump to label if cond is true (jumpIf) or false (!jumpIf), fall
+through otherwise, without computing the value of cond:
+
+	a == 0, !a   the branch for a, reversed
+	a && b       a branch for each operand
+	a < b        what the target has (cmp + b.lt on AArch64)
+	a            a branch on the value being 0 or not
+*/
+func (cg *CodeGen) genBranch(cond ast.Expr, label string, jumpIf bool) {
+	cond = ast.StripParen(cond)
+	if v, ok := literalValue(cond); ok {
+		if (v != 0) == jumpIf {
+			cg.target.jump(cg, label)
+		}
+		return
+	}
+	if e, ok := cond.(*ast.ExprOp); ok {
+		switch {
+		case e.Op == "!" && len(e.Args) == 1:
+			cg.genBranch(e.Args[0], label, !jumpIf)
+			return
+		case e.Op == "&&" || e.Op == "||":
+			/* a && b jumps if false when a is false; a || b jumps if true when a is true */
+			decided_by_left := (e.Op == "&&") != jumpIf
+			if decided_by_left {
+				cg.genBranch(e.Args[0], label, jumpIf)
+				cg.genBranch(e.Args[1], label, jumpIf)
+			} else {
+				skip := cg.label("skip", cg.count())
+				cg.genBranch(e.Args[0], skip, !jumpIf)
+				cg.genBranch(e.Args[1], label, jumpIf)
+				cg.emitLabel(skip)
+			}
+			return
+		case (e.Op == "==" || e.Op == "!=") && len(e.Args) == 2 && isZero(e.Args[1]):
+			cg.genBranch(e.Args[0], label, jumpIf == (e.Op == "!="))
+			return
+		}
+		if cg.target.branchOp(cg, e, label, jumpIf) {
+			return
+		}
+	}
+	cg.genExpr(cond)
+	cg.target.branchZero(cg, label, jumpIf)
+}
+
+func isZero(e ast.Expr) bool {
+	v, ok := literalValue(e)
+	return ok && v == 0
+}
+
+func (cg *CodeGen) genStmt(stmt ast.Stmt) {
+	switch s := stmt.(type) {
+	case *ast.StmtReturn:
+		/* return f(...); (see the tailCall of the targets) */
+		if call, ok := ast.StripParen(s.Expr).(*ast.ExprCall); ok && cg.tailCalls && cg.target.tailCall(cg, call) {
+			return
+		}
+		if s.Expr != nil {
+			cg.genExpr(s.Expr)
+		}
+		cg.target.ret(cg)
+
+	case *ast.StmtCompound:
+		for _, stmt := range s.Stmts {
+			cg.genStmt(stmt)
+		}
+
+	case *ast.StmtIf:
+		c := cg.count()
+		cg.genBranch(s.Cond, cg.label("else", c), false)
+		cg.genStmt(s.ThenStmt)
+		cg.target.jump(cg, cg.label("end", c))
+		cg.emitLabel(cg.label("else", c))
+		if s.ElseStmt != nil {
+			cg.genStmt(s.ElseStmt)
+		}
+		cg.emitLabel(cg.label("end", c))
+
+	case *ast.StmtWhile:
+		c := cg.count()
+		begin, end := cg.label("begin", c), cg.label("end", c)
+		cg.emitLabel(begin)
+		cg.genBranch(s.Cond, end, false)
+		cg.loops = append(cg.loops, loopLabels{end, begin})
+		cg.genStmt(s.Body)
+		cg.loops = cg.loops[:len(cg.loops)-1]
+		cg.target.jump(cg, begin)
+		cg.emitLabel(end)
+
+	case *ast.StmtBreak:
+		if len(cg.loops) == 0 {
+			cg.errorf("break is not in a loop")
+		} else {
+			cg.target.jump(cg, cg.loops[len(cg.loops)-1].brk)
+		}
+
+	case *ast.StmtContinue:
+		if len(cg.loops) == 0 {
+			cg.errorf("continue is not in a loop")
+		} else {
+			cg.target.jump(cg, cg.loops[len(cg.loops)-1].cont)
+		}
+
+	case *ast.StmtExpr:
+		cg.genExpr(s.Expr)
+
+	case *ast.StmtFor:
+		c := cg.count()
+		// 初期化
+		cg.genStmt(s.Init)
+
+		begin, next, end := cg.label("begin", c), cg.label("next", c), cg.label("end", c)
+		cg.emitLabel(begin) // ループ条件判定位置
+		if s.Cond != nil {
+			cg.genBranch(s.Cond, end, false) // false で脱出
+		}
+
+		cg.loops = append(cg.loops, loopLabels{end, next})
+		cg.genStmt(s.Body) // body
+		cg.loops = cg.loops[:len(cg.loops)-1]
+		cg.emitLabel(next)        // continue の飛び先
+		cg.genStmt(s.Post)        // post
+		cg.target.jump(cg, begin) // 再判定へ
+		cg.emitLabel(end)
+
+	case *ast.StmtDeclInit:
+		cg.genExpr(s.Init) // 初期値計算
+		if v, ok := cg.lookupVar(s.Decl.Name); ok {
+			cg.target.storeVar(cg, v) // スタックに保存
+		} else {
+			cg.errorf("undeclared variable %s", s.Decl.Name)
+		}
+	}
+}
+
+func (cg *CodeGen) genFunction(fun *ast.DefFun) {
+	cg.params = make([]string, len(fun.Params))
+	for i, decl := range fun.Params {
+		cg.params[i] = decl.Name
+	}
+	cg.localVars = newLocalVars(cg.target.abi().wordSize)
+	collectDecls(fun.Body, cg.localVars)
+	cg.funName = fun.Name
+	cg.sizeFunction(fun.Body)
+
+	sym := cg.symbol(fun.Name)
+	cg.directive(".globl %s", sym)
+	if cg.target.dialect().typeDirs {
+		cg.directive(".type %s, @function", sym)
+	}
+	cg.emitLabel(sym)
+	cg.target.prologue(cg)
+	cg.tailLabel = cg.label("tail", cg.count())
+	cg.emitLabel(cg.tailLabel)
+
+	cg.genStmt(fun.Body)
+}
+
+/*
+find how many values the body pushes at once (cg.maxDepth), for the
+prologue to size the frame by: the body is generated, and then
+thrown away with the labels and diagnostics of it
+*/
+func (cg *CodeGen) sizeFunction(body ast.Stmt) {
+	code, labels, diags := len(cg.code), cg.labelCount, len(cg.diags)
+	cg.depth, cg.maxDepth = 0, 0
+	cg.genStmt(body)
+	cg.code, cg.labelCount, cg.diags = cg.code[:code], labels, cg.diags[:diags]
+	cg.depth = 0
+}
+
+/*
+generate code for a program. tail_calls: compile return f(...); to
+jumps, peephole: run the peephole optimizer of the target. what it
+cannot compile is an error (a diagnostic each)
+*/
+func GenerateAsm(program *ast.Program, target Target, tail_calls bool, peephole bool) (string, error) {
+	code, err := Generate(program, target, tail_calls, peephole)
+	if err != nil {
+		return "", err
+	}
+	return InstrsToString(code), nil
+}
+
+/* GenerateAsm, as instructions (for the assembler, minc_as) */
+func Generate(program *ast.Program, target Target, tail_calls bool, peephole bool) ([]*Instr, error) {
+	if len(program.Defs) == 0 {
+		return nil, nil
+	}
+
+	cg := newCodeGen(target)
+	cg.tailCalls = tail_calls
+	cg.functions = make(map[string]bool)
+	for _, def := range program.Defs {
+		if d, ok := def.(*ast.DefFun); ok {
+			cg.functions[d.Name] = true
+		}
+	}
+	for _, dir := range target.dialect().header {
+		cg.directive("%s", dir)
+	}
+
+	for _, def := range program.Defs {
+		if d, ok := def.(*ast.DefFun); ok {
+			cg.genFunction(d)
+		}
+	}
+
+	for _, dir := range target.dialect().footer {
+		cg.directive("%s", dir)
+	}
+	if len(cg.diags) > 0 {
+		return nil, diag.CompileError(cg.diags)
+	}
+	if peephole {
+		cg.code = target.peephole(cg.code)
+	}
+	return cg.code, nil
+}
+
+func getParamIndex(name string, params []string) int {
+	for i, n := range params {
+		if n == name {
+			return i
+		}
+	}
+	if len(name) >= 2 && name[0] == 'a' {
+		if idx, err := strconv.Atoi(name[1:]); err == nil && idx >= 0 && idx <= 11 {
+			return idx
+		}
+	}
+	return -1
+}
+
+func collectDecls(st ast.Stmt, lv *LocalVars) {
+	switch s := st.(type) {
+	case *ast.StmtCompound:
+		for _, d := range s.Decls {
+			lv.addVariable(d.Name)
+		}
+		for _, sub := range s.Stmts {
+			collectDecls(sub, lv)
+		}
+	case *ast.StmtIf:
+		collectDecls(s.ThenStmt, lv)
+		if s.ElseStmt != nil {
+			collectDecls(s.ElseStmt, lv)
+		}
+	case *ast.StmtWhile:
+		collectDecls(s.Body, lv)
+	case *ast.StmtFor:
+		collectDecls(s.Init, lv)
+		collectDecls(s.Body, lv)
+	case *ast.StmtDeclInit:
+		lv.addVariable(s.Decl.Name)
+	}
+}
@@ -0,0 +1,358 @@
+package codegen
+
+/* minc_cse
+
+   common subexpression elimination by value numbering.
+
+   every value a function computes gets a value number, so that two
+   expressions get the same number exactly when they are known to
+   produce the same value: i + 1 and i + 1 do as long as i is not
+   assigned in between, and so do x + 1 and y + 1 after x = y.
+
+   - local value numbering: the nodes of a basic block are numbered
+     in order, and a pure expression whose number was already
+     computed earlier in the block is redundant.
+   - global value numbering: blocks are visited along the dominator
+     tree and start from the numbers of their immediate dominator,
+     so a computation in a dominating block is reused as well.
+     variables that may be assigned on the way from the dominator
+     to the block get fresh numbers.
+
+   the first computation of a value that is needed again is saved
+   in a new local variable (_cse0, _cse1, ...) and the later ones
+   read that variable:
+
+     if (i + 1 < n) sum = sum + (i + 1);
+       ->
+     if ((_cse0 = i + 1) < n) sum = sum + _cse0;
+
+   comparisons (and !, &&, ||) a condition branches on are left
+   alone: the code generator branches on them with a compare and a
+   conditional branch, which is cheaper than saving their value.
+*/
+
+import (
+	"fmt"
+	"io"
+
+	"minc/ast"
+)
+
+/* a computation of a pure expression that later ones may reuse */
+type cseOccurrence struct {
+	expr  *ast.ExprOp
+	vn    int    // its value number
+	temp  string // variable holding its value, if reused
+	reuse int    // number of computations it replaces
+}
+
+/* value numbers at some point of a function */
+type vnScope struct {
+	vars  map[string]int         // variable -> number of its current value
+	avail map[int]*cseOccurrence // number -> where it was computed
+}
+
+func (sc *vnScope) copy() *vnScope {
+	c := &vnScope{make(map[string]int), make(map[int]*cseOccurrence)}
+	for k, v := range sc.vars {
+		c.vars[k] = v
+	}
+	for k, v := range sc.avail {
+		c.avail[k] = v
+	}
+	return c
+}
+
+type cseState struct {
+	numbers   map[string]int // "op n1 n2" or "lit 5" -> value number
+	next      int
+	redundant map[*ast.ExprOp]*cseOccurrence
+	defining  map[*ast.ExprOp]*cseOccurrence
+	order     []*cseOccurrence     // defining occurrences, as found
+	branches  map[*ast.ExprOp]bool // comparisons a condition branches on
+	/* computations inside call arguments, available after the call */
+	pending *[]*cseOccurrence
+}
+
+func (c *cseState) fresh() int {
+	c.next++
+	return c.next
+}
+
+func (c *cseState) lookup(key string) int {
+	if vn, ok := c.numbers[key]; ok {
+		return vn
+	}
+	vn := c.fresh()
+	c.numbers[key] = vn
+	return vn
+}
+
+func (c *cseState) varNumber(name string, sc *vnScope) int {
+	if vn, ok := sc.vars[name]; ok {
+		return vn
+	}
+	vn := c.fresh()
+	sc.vars[name] = vn
+	return vn
+}
+
+/* value number of a pure expression, without recording anything */
+func (c *cseState) valueOf(expr ast.Expr, sc *vnScope) int {
+	switch e := expr.(type) {
+	case *ast.ExprIntLiteral:
+		return c.lookup(fmt.Sprintf("lit %d", e.Val))
+	case *ast.ExprId:
+		return c.varNumber(e.Name, sc)
+	case *ast.ExprParen:
+		return c.valueOf(e.SubExpr, sc)
+	case *ast.ExprOp:
+		key := e.Op
+		for _, arg := range e.Args {
+			key += fmt.Sprintf(" %d", c.valueOf(arg, sc))
+		}
+		return c.lookup(key)
+	}
+	return c.fresh()
+}
+
+/*
+number an expression in evaluation order and return its value number.
+cond is true when the expression may not be evaluated at all (right
+operand of && and ||); its computations then cannot be reused later
+*/
+func (c *cseState) visit(expr ast.Expr, sc *vnScope, cond bool) int {
+	switch e := expr.(type) {
+	case *ast.ExprParen:
+		return c.visit(e.SubExpr, sc, cond)
+	case *ast.ExprCall:
+		saved := c.pending
+		var pending []*cseOccurrence
+		c.pending = &pending
+		for _, arg := range e.Args {
+			c.visit(arg, sc, cond)
+		}
+		c.pending = saved
+		for _, occ := range pending {
+			c.makeAvailable(occ, sc)
+		}
+		return c.fresh()
+	case *ast.ExprOp:
+		if c.branches[e] {
+			c.visitArgs(e, sc, cond)
+			return c.fresh()
+		}
+		if exprIsPure(e) {
+			vn := c.valueOf(e, sc)
+			if occ, ok := sc.avail[vn]; ok {
+				c.redundant[e] = occ
+				occ.reuse++
+				return vn
+			}
+			c.visitArgs(e, sc, cond)
+			if !cond {
+				occ := &cseOccurrence{expr: e, vn: vn}
+				if c.pending != nil {
+					*c.pending = append(*c.pending, occ)
+				} else {
+					c.makeAvailable(occ, sc)
+				}
+			}
+			return vn
+		}
+		if e.Op == "=" {
+			vn := c.visit(e.Args[1], sc, cond)
+			if id, ok := ast.StripParen(e.Args[0]).(*ast.ExprId); ok {
+				if cond {
+					vn = c.fresh()
+				}
+				sc.vars[id.Name] = vn
+			}
+			return vn
+		}
+		vns := c.visitArgs(e, sc, cond)
+		key := e.Op
+		for _, vn := range vns {
+			key += fmt.Sprintf(" %d", vn)
+		}
+		return c.lookup(key)
+	}
+	return c.valueOf(expr, sc)
+}
+
+func (c *cseState) visitArgs(e *ast.ExprOp, sc *vnScope, cond bool) []int {
+	vns := make([]int, len(e.Args))
+	for i, arg := range e.Args {
+		short_circuit := i > 0 && (e.Op == "&&" || e.Op == "||")
+		vns[i] = c.visit(arg, sc, cond || short_circuit)
+	}
+	return vns
+}
+
+func (c *cseState) makeAvailable(occ *cseOccurrence, sc *vnScope) {
+	if _, ok := sc.avail[occ.vn]; !ok {
+		sc.avail[occ.vn] = occ
+		c.defining[occ.expr] = occ
+		c.order = append(c.order, occ)
+	}
+}
+
+/*
+the operators of a condition that genBranch turns into branches
+rather than values: comparisons, !, && and ||
+*/
+func branchComparisons(cond ast.Expr, found map[*ast.ExprOp]bool) {
+	e, ok := ast.StripParen(cond).(*ast.ExprOp)
+	if !ok {
+		return
+	}
+	switch e.Op {
+	case "!", "&&", "||":
+		found[e] = true
+		for _, arg := range e.Args {
+			branchComparisons(arg, found)
+		}
+	case "==", "!=":
+		found[e] = true
+		/* x == 0 and x != 0 branch on x */
+		if isZero(e.Args[1]) {
+			branchComparisons(e.Args[0], found)
+		}
+	case "<", "<=", ">", ">=":
+		found[e] = true
+	}
+}
+
+/* number the nodes of a block, in order (local value numbering) */
+func (c *cseState) numberBlock(bb *BasicBlock, sc *vnScope) {
+	for _, n := range bb.nodes {
+		e := n.expr()
+		vn := 0
+		if e != nil && n.isBranch() {
+			branchComparisons(e, c.branches)
+		}
+		if e != nil {
+			vn = c.visit(e, sc, false)
+		}
+		if s, ok := n.stmt.(*ast.StmtDeclInit); ok {
+			sc.vars[s.Decl.Name] = vn
+		}
+	}
+}
+
+/*
+number the blocks of a function along its dominator tree (global
+value numbering). a block starts from the numbers at the end of its
+immediate dominator, except for the variables that may be assigned
+on some path from the dominator to the block
+*/
+func (c *cseState) numberFunction(cfg *CFG, idom map[*BasicBlock]*BasicBlock) {
+	children := make(map[*BasicBlock][]*BasicBlock)
+	for _, bb := range cfg.blocks {
+		if d, ok := idom[bb]; ok {
+			children[d] = append(children[d], bb)
+		}
+	}
+	var walk func(bb *BasicBlock, sc *vnScope)
+	walk = func(bb *BasicBlock, sc *vnScope) {
+		c.numberBlock(bb, sc)
+		for _, child := range children[bb] {
+			child_sc := sc.copy()
+			for x := range cfg.pathBlocks(bb, child) {
+				for _, n := range x.nodes {
+					for v := range n.assigned() {
+						child_sc.vars[v] = c.fresh()
+					}
+				}
+			}
+			walk(child, child_sc)
+		}
+	}
+	walk(cfg.entry, &vnScope{make(map[string]int), make(map[int]*cseOccurrence)})
+}
+
+/* replace redundant computations with the variables holding their values */
+func (c *cseState) rewrite(expr ast.Expr) ast.Expr {
+	switch e := expr.(type) {
+	case *ast.ExprParen:
+		e.SubExpr = c.rewrite(e.SubExpr)
+	case *ast.ExprCall:
+		for i, arg := range e.Args {
+			e.Args[i] = c.rewrite(arg)
+		}
+	case *ast.ExprOp:
+		if occ, ok := c.redundant[e]; ok {
+			return &ast.ExprId{Name: occ.temp}
+		}
+		for i, arg := range e.Args {
+			e.Args[i] = c.rewrite(arg)
+		}
+		if occ, ok := c.defining[e]; ok && occ.reuse > 0 {
+			return &ast.ExprParen{SubExpr: &ast.ExprOp{Op: "=", Args: []ast.Expr{&ast.ExprId{Name: occ.temp}, e}}}
+		}
+	}
+	return expr
+}
+
+func cseFunction(fun *ast.DefFun, am *Analyses, dump io.Writer) {
+	cfg := am.CFG(fun)
+	c := &cseState{
+		numbers:   make(map[string]int),
+		redundant: make(map[*ast.ExprOp]*cseOccurrence),
+		defining:  make(map[*ast.ExprOp]*cseOccurrence),
+		branches:  make(map[*ast.ExprOp]bool),
+	}
+	c.numberFunction(cfg, am.Dominators(fun))
+
+	var reused []*cseOccurrence
+	for _, occ := range c.order {
+		if occ.reuse > 0 {
+			reused = append(reused, occ)
+		}
+	}
+	if len(reused) == 0 {
+		return
+	}
+
+	/* pick names not used by the function yet */
+	used := make(map[string]bool)
+	stmtVars(fun.Body, used)
+	for _, p := range fun.Params {
+		used[p.Name] = true
+	}
+	body, ok := fun.Body.(*ast.StmtCompound)
+	if !ok {
+		body = &ast.StmtCompound{Decls: nil, Stmts: []ast.Stmt{fun.Body}}
+		fun.Body = body
+		am.invalidate(fun)
+	}
+	k := 0
+	for _, occ := range reused {
+		for used[fmt.Sprintf("_cse%d", k)] {
+			k++
+		}
+		occ.temp = fmt.Sprintf("_cse%d", k)
+		used[occ.temp] = true
+		body.Decls = append(body.Decls, &ast.Decl{VarType: &ast.TypePrimitive{Name: "long"}, Name: occ.temp})
+		if dump != nil {
+			fmt.Fprintf(dump, "cse: %s: %s computed once into %s, %d redundant computation(s) eliminated\n",
+				fun.Name, occ.expr.ExprString(), occ.temp, occ.reuse)
+		}
+	}
+
+	for _, bb := range cfg.blocks {
+		for _, n := range bb.nodes {
+			if e := n.expr(); e != nil {
+				n.setExpr(c.rewrite(e))
+			}
+		}
+	}
+}
+
+/*
+eliminate common subexpressions in every function of a program
+(in place). if dump is not nil, what was eliminated is reported to it
+*/
+func cse_program(program *ast.Program, am *Analyses, dump io.Writer) {
+	forFunctions(program, func(fun *ast.DefFun) { cseFunction(fun, am, dump) })
+}
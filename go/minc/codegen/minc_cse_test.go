@@ -0,0 +1,55 @@
+package codegen
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCSE(t *testing.T) {
+	const g = `long g(long x) { return x; } `
+	tests := []struct{ src, want, dump string }{
+		/* local value numbering */
+		{`long f(long x, long y) { long a; long b; a = x + y; b = x + y; return a * b; }`,
+			`long f(long x, long y) { long a; long b; long _cse0; a = (_cse0 = x + y); b = _cse0; return a * b; }`,
+			"cse: f: x + y computed once into _cse0, 1 redundant computation(s) eliminated\n"},
+		{`long f(long x, long y) { long a; a = (x + y) * (x + y) + (x + y); return a; }`,
+			`long f(long x, long y) { long a; long _cse0; a = ((_cse0 = x + y)) * (_cse0) + (_cse0); return a; }`,
+			"cse: f: x + y computed once into _cse0, 2 redundant computation(s) eliminated\n"},
+		/* global: from a dominating block, not from one that may not have run */
+		{`long f(long x, long y) { long a; long b; a = x + y; if (y) b = x + y; else b = 0; return a * b; }`,
+			`long f(long x, long y) { long a; long b; long _cse0; a = (_cse0 = x + y); if (y) b = _cse0; else b = 0; return a * b; }`,
+			"cse: f: x + y computed once into _cse0, 1 redundant computation(s) eliminated\n"},
+		{`long f(long x, long y) { long a; long b; a = 0; if (y) a = x + y; b = x + y; return a * b; }`,
+			`long f(long x, long y) { long a; long b; a = 0; if (y) a = x + y; b = x + y; return a * b; }`, ""},
+		/* an assignment to x, even on one path only, makes x + y another value */
+		{`long f(long x, long y) { long a; long b; a = x + y; x = 2; b = x + y; return a * b; }`,
+			`long f(long x, long y) { long a; long b; a = x + y; x = 2; b = x + y; return a * b; }`, ""},
+		{`long f(long x, long y) { long a; long b; a = x + y; if (y) x = 1; b = x + y; return a * b; }`,
+			`long f(long x, long y) { long a; long b; a = x + y; if (y) x = 1; b = x + y; return a * b; }`, ""},
+		{g + `long f(long x, long y) { long a; long b; a = x + y; b = g(x = 1) + (x + y); return a * b; }`,
+			g + `long f(long x, long y) { long a; long b; a = x + y; b = g(x = 1) + (x + y); return a * b; }`, ""},
+		/* a call cannot change the caller's variables, but its value is not reused */
+		{g + `long f(long x, long y) { long a; long b; a = x + y; g(x); b = x + y; return a * b; }`,
+			g + `long f(long x, long y) { long a; long b; long _cse0; a = (_cse0 = x + y); g(x); b = _cse0; return a * b; }`,
+			"cse: f: x + y computed once into _cse0, 1 redundant computation(s) eliminated\n"},
+		{g + `long f(long x, long y) { long a; long b; a = g(x) + y; b = g(x) + y; return a * b; }`,
+			g + `long f(long x, long y) { long a; long b; a = g(x) + y; b = g(x) + y; return a * b; }`, ""},
+		/* in a condition, the operand of a comparison is reused, but not the comparison */
+		{`long f(long x, long y) { long a; a = x * y; if (x * y > 3) a = (x * y) * 2; return a; }`,
+			`long f(long x, long y) { long a; long _cse0; a = (_cse0 = x * y); if (_cse0 > 3) a = (_cse0) * 2; return a; }`,
+			"cse: f: x * y computed once into _cse0, 2 redundant computation(s) eliminated\n"},
+		{`long f(long n) { long i; long s; i = 0; s = 0; while (i < n) { if (i < n) s = s + i; i = i + 1; } return s; }`,
+			`long f(long n) { long i; long s; i = 0; s = 0; while (i < n) { if (i < n) s = s + i; i = i + 1; } return s; }`, ""},
+		{`long f(long x, long y) { long a; a = x < y; if (x < y && !(x == y)) a = 2; return a; }`,
+			`long f(long x, long y) { long a; a = x < y; if (x < y && !(x == y)) a = 2; return a; }`, ""},
+	}
+	for _, tt := range tests {
+		program := mustParse(t, tt.src)
+		var dump bytes.Buffer
+		cse_program(program, newAnalyses(), &dump)
+		checkProgram(t, tt.src, program, tt.want)
+		if dump.String() != tt.dump {
+			t.Errorf("%s: -fdump %q, want %q", tt.src, dump.String(), tt.dump)
+		}
+	}
+}
@@ -0,0 +1,413 @@
+package codegen
+
+/* minc_dce
+
+   dead code elimination, driven by the control flow graph of
+   minc_cfg.
+
+   - unreachable code: statements whose block cannot be reached
+     from the entry (code after return/break/continue, the branch
+     of if (0) that is never taken, while (0) ...) are removed.
+   - dead stores: x = e where x is not live afterwards becomes e;
+     long x = e; becomes e; (x keeps its declaration).
+   - dead values: an expression statement whose value is unused
+     is reduced to the part that has side effects (x + f(y); -> f(y);)
+     or removed altogether when it has none.
+
+   each of these may expose more of the others, so they are
+   repeated until nothing changes. declarations of variables no
+   longer referenced are dropped at the end.
+
+   warn_unreachable reports statements the programmer wrote but
+   that can never execute.
+*/
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"minc/ast"
+)
+
+/* --- unreachable code --- */
+
+/* has control any way to reach stmt? */
+func stmtReachable(cfg *CFG, reach map[*BasicBlock]bool, stmt ast.Stmt) bool {
+	bb, ok := cfg.stmt_block[stmt]
+	return !ok || reach[bb]
+}
+
+/*
+rewrite stmt without its unreachable parts. declarations made by
+removed statements are moved to decls, the declarations of the
+enclosing compound statement
+*/
+func removeUnreachable(cfg *CFG, reach map[*BasicBlock]bool, stmt ast.Stmt, decls *[]*ast.Decl) ast.Stmt {
+	switch s := stmt.(type) {
+	case *ast.StmtCompound:
+		stmts := []ast.Stmt{}
+		for _, sub := range s.Stmts {
+			if !stmtReachable(cfg, reach, sub) {
+				keepDecls(sub, &s.Decls)
+				continue
+			}
+			sub = removeUnreachable(cfg, reach, sub, &s.Decls)
+			if _, ok := sub.(*ast.StmtEmpty); ok {
+				continue
+			}
+			stmts = append(stmts, sub)
+		}
+		s.Stmts = stmts
+		return s
+	case *ast.StmtIf:
+		may_true, may_false := condEdges(s.Cond)
+		if !may_false {
+			if s.ElseStmt != nil {
+				keepDecls(s.ElseStmt, decls)
+			}
+			return removeUnreachable(cfg, reach, s.ThenStmt, decls)
+		}
+		if !may_true {
+			keepDecls(s.ThenStmt, decls)
+			if s.ElseStmt == nil {
+				return &ast.StmtEmpty{}
+			}
+			return removeUnreachable(cfg, reach, s.ElseStmt, decls)
+		}
+		s.ThenStmt = removeUnreachable(cfg, reach, s.ThenStmt, decls)
+		if s.ElseStmt != nil {
+			s.ElseStmt = removeUnreachable(cfg, reach, s.ElseStmt, decls)
+		}
+		return s
+	case *ast.StmtWhile:
+		if may_true, _ := condEdges(s.Cond); !may_true {
+			keepDecls(s.Body, decls)
+			return &ast.StmtEmpty{}
+		}
+		s.Body = removeUnreachable(cfg, reach, s.Body, decls)
+		return s
+	case *ast.StmtFor:
+		if may_true, _ := condEdges(s.Cond); !may_true {
+			keepDecls(s.Body, decls)
+			return s.Init
+		}
+		s.Body = removeUnreachable(cfg, reach, s.Body, decls)
+		return s
+	}
+	return stmt
+}
+
+/* add the variables declared by stmt with an initializer to decls */
+func keepDecls(stmt ast.Stmt, decls *[]*ast.Decl) {
+	switch s := stmt.(type) {
+	case *ast.StmtDeclInit:
+		*decls = append(*decls, s.Decl)
+	case *ast.StmtCompound:
+		for _, sub := range s.Stmts {
+			keepDecls(sub, &s.Decls)
+		}
+		*decls = append(*decls, s.Decls...)
+	case *ast.StmtIf:
+		keepDecls(s.ThenStmt, decls)
+		if s.ElseStmt != nil {
+			keepDecls(s.ElseStmt, decls)
+		}
+	case *ast.StmtWhile:
+		keepDecls(s.Body, decls)
+	case *ast.StmtFor:
+		keepDecls(s.Body, decls)
+	}
+}
+
+/* --- dead stores and dead values --- */
+
+/*
+the part of expr that has to be evaluated for its side effects
+when its value is not used (nil if there is none)
+*/
+func sideEffects(expr ast.Expr) ast.Expr {
+	if exprIsPure(expr) {
+		return nil
+	}
+	switch e := expr.(type) {
+	case *ast.ExprParen:
+		return sideEffects(e.SubExpr)
+	case *ast.ExprOp:
+		if e.Op == "=" {
+			return e
+		}
+		if e.Op == "&&" || e.Op == "||" {
+			if exprIsPure(e.Args[1]) {
+				return sideEffects(e.Args[0])
+			}
+			return e
+		}
+		var impure ast.Expr
+		for _, arg := range e.Args {
+			if !exprIsPure(arg) {
+				if impure != nil {
+					return e
+				}
+				impure = arg
+			}
+		}
+		return sideEffects(impure)
+	}
+	return expr
+}
+
+/*
+remove stores to variables that are never read afterwards and
+values that are never used. returns true if anything changed
+*/
+func removeDeadStores(cfg *CFG, reach map[*BasicBlock]bool, dead map[ast.Stmt]bool) bool {
+	changed := false
+	live_out := cfg.liveOut()
+	for _, bb := range cfg.blocks {
+		if !reach[bb] {
+			continue
+		}
+		live := copySet(live_out[bb])
+		for j := len(bb.nodes) - 1; j >= 0; j-- {
+			n := bb.nodes[j]
+			switch s := n.stmt.(type) {
+			case *ast.StmtExpr:
+				if a, ok := ast.StripParen(s.Expr).(*ast.ExprOp); ok && a.Op == "=" {
+					if id, ok := ast.StripParen(a.Args[0]).(*ast.ExprId); ok && !live[id.Name] {
+						s.Expr = a.Args[1]
+						changed = true
+					}
+				}
+				if e := sideEffects(s.Expr); e == nil {
+					dead[s] = true
+				} else if e != s.Expr {
+					s.Expr = e
+					changed = true
+				}
+			case *ast.StmtDeclInit:
+				if !live[s.Decl.Name] {
+					dead[s] = true
+				}
+			}
+			if dead[n.stmt] {
+				changed = true
+				continue
+			}
+			transferLive(n, live)
+		}
+	}
+	return changed
+}
+
+/*
+replace the statements found dead by removeDeadStores.
+long x = f(y); becomes f(y); with x declared in the enclosing block
+*/
+func removeDeadStmts(stmt ast.Stmt, dead map[ast.Stmt]bool, decls *[]*ast.Decl) ast.Stmt {
+	switch s := stmt.(type) {
+	case *ast.StmtCompound:
+		stmts := []ast.Stmt{}
+		for _, sub := range s.Stmts {
+			sub = removeDeadStmts(sub, dead, &s.Decls)
+			if _, ok := sub.(*ast.StmtEmpty); ok {
+				continue
+			}
+			stmts = append(stmts, sub)
+		}
+		s.Stmts = stmts
+		return s
+	case *ast.StmtDeclInit:
+		if dead[s] {
+			*decls = append(*decls, s.Decl)
+			if e := sideEffects(s.Init); e != nil {
+				return &ast.StmtExpr{Expr: e}
+			}
+			return &ast.StmtEmpty{}
+		}
+	case *ast.StmtExpr:
+		if dead[s] {
+			return &ast.StmtEmpty{}
+		}
+	case *ast.StmtIf:
+		s.ThenStmt = removeDeadStmts(s.ThenStmt, dead, decls)
+		if s.ElseStmt != nil {
+			s.ElseStmt = removeDeadStmts(s.ElseStmt, dead, decls)
+		}
+	case *ast.StmtWhile:
+		s.Body = removeDeadStmts(s.Body, dead, decls)
+	case *ast.StmtFor:
+		s.Init = removeDeadStmts(s.Init, dead, decls)
+		s.Post = removeDeadStmts(s.Post, dead, decls)
+		s.Body = removeDeadStmts(s.Body, dead, decls)
+	}
+	return stmt
+}
+
+/* --- unused declarations --- */
+
+/* names of all variables a statement refers to */
+func stmtVars(stmt ast.Stmt, vars map[string]bool) {
+	switch s := stmt.(type) {
+	case *ast.StmtCompound:
+		for _, sub := range s.Stmts {
+			stmtVars(sub, vars)
+		}
+	case *ast.StmtIf:
+		exprVars(s.Cond, vars)
+		stmtVars(s.ThenStmt, vars)
+		if s.ElseStmt != nil {
+			stmtVars(s.ElseStmt, vars)
+		}
+	case *ast.StmtWhile:
+		exprVars(s.Cond, vars)
+		stmtVars(s.Body, vars)
+	case *ast.StmtFor:
+		stmtVars(s.Init, vars)
+		if s.Cond != nil {
+			exprVars(s.Cond, vars)
+		}
+		stmtVars(s.Post, vars)
+		stmtVars(s.Body, vars)
+	case *ast.StmtDeclInit:
+		vars[s.Decl.Name] = true
+		exprVars(s.Init, vars)
+	case *ast.StmtExpr:
+		exprVars(s.Expr, vars)
+	case *ast.StmtReturn:
+		exprVars(s.Expr, vars)
+	}
+}
+
+/* names of all variables an expression reads or assigns */
+func exprVars(expr ast.Expr, vars map[string]bool) {
+	switch e := expr.(type) {
+	case *ast.ExprId:
+		vars[e.Name] = true
+	case *ast.ExprParen:
+		exprVars(e.SubExpr, vars)
+	case *ast.ExprCall:
+		if _, ok := e.Fun.(*ast.ExprId); !ok {
+			exprVars(e.Fun, vars)
+		}
+		for _, arg := range e.Args {
+			exprVars(arg, vars)
+		}
+	case *ast.ExprOp:
+		for _, arg := range e.Args {
+			exprVars(arg, vars)
+		}
+	}
+}
+
+/* drop the declarations of variables that are not referenced */
+func removeUnusedDecls(stmt ast.Stmt, vars map[string]bool) {
+	switch s := stmt.(type) {
+	case *ast.StmtCompound:
+		decls := []*ast.Decl{}
+		for _, d := range s.Decls {
+			if vars[d.Name] {
+				decls = append(decls, d)
+			}
+		}
+		s.Decls = decls
+		for _, sub := range s.Stmts {
+			removeUnusedDecls(sub, vars)
+		}
+	case *ast.StmtIf:
+		removeUnusedDecls(s.ThenStmt, vars)
+		if s.ElseStmt != nil {
+			removeUnusedDecls(s.ElseStmt, vars)
+		}
+	case *ast.StmtWhile:
+		removeUnusedDecls(s.Body, vars)
+	case *ast.StmtFor:
+		removeUnusedDecls(s.Body, vars)
+	}
+}
+
+func dceFunction(fun *ast.DefFun, am *Analyses) {
+	var decls []*ast.Decl
+	for changed := true; changed; {
+		cfg := am.CFG(fun)
+		reach := cfg.reachable()
+		fun.Body = removeUnreachable(cfg, reach, fun.Body, &decls)
+		am.invalidate(fun)
+
+		cfg = am.CFG(fun)
+		reach = cfg.reachable()
+		dead := make(map[ast.Stmt]bool)
+		changed = removeDeadStores(cfg, reach, dead)
+		fun.Body = removeDeadStmts(fun.Body, dead, &decls)
+		am.invalidate(fun)
+	}
+	body, ok := fun.Body.(*ast.StmtCompound)
+	if !ok {
+		body = &ast.StmtCompound{Decls: nil, Stmts: []ast.Stmt{fun.Body}}
+		fun.Body = body
+	}
+	body.Decls = append(body.Decls, decls...)
+
+	vars := make(map[string]bool)
+	stmtVars(fun.Body, vars)
+	removeUnusedDecls(fun.Body, vars)
+}
+
+/* eliminate dead code from every function of a program (in place) */
+func dce_program(program *ast.Program, am *Analyses) {
+	forFunctions(program, func(fun *ast.DefFun) { dceFunction(fun, am) })
+}
+
+/* --- warnings --- */
+
+/* the first line of a statement, for messages */
+func stmtSummary(stmt ast.Stmt) string {
+	s := stmt.StmtString()
+	if i := strings.Index(s, "\n"); i >= 0 {
+		return s[:i] + " ..."
+	}
+	return s
+}
+
+/*
+report the first statement of each run of statements that can never
+execute, in a compound statement that itself can
+*/
+func warnUnreachableStmt(w io.Writer, cfg *CFG, reach map[*BasicBlock]bool, stmt ast.Stmt) {
+	if !stmtReachable(cfg, reach, stmt) {
+		return
+	}
+	switch s := stmt.(type) {
+	case *ast.StmtCompound:
+		prev_reachable := true
+		for _, sub := range s.Stmts {
+			r := stmtReachable(cfg, reach, sub)
+			if !r && prev_reachable {
+				fmt.Fprintf(w, "minc: warning: unreachable statement in function '%s': %s\n",
+					cfg.fun.Name, stmtSummary(sub))
+			}
+			prev_reachable = r
+			warnUnreachableStmt(w, cfg, reach, sub)
+		}
+	case *ast.StmtIf:
+		warnUnreachableStmt(w, cfg, reach, s.ThenStmt)
+		if s.ElseStmt != nil {
+			warnUnreachableStmt(w, cfg, reach, s.ElseStmt)
+		}
+	case *ast.StmtWhile:
+		warnUnreachableStmt(w, cfg, reach, s.Body)
+	case *ast.StmtFor:
+		warnUnreachableStmt(w, cfg, reach, s.Body)
+	}
+}
+
+/* warn (to w, if not nil) about statements of a program that can never execute */
+func warn_unreachable(program *ast.Program, am *Analyses, w io.Writer) {
+	if w == nil {
+		return
+	}
+	forFunctions(program, func(fun *ast.DefFun) {
+		cfg := am.CFG(fun)
+		warnUnreachableStmt(w, cfg, cfg.reachable(), fun.Body)
+	})
+}
@@ -0,0 +1,77 @@
+package codegen
+
+import (
+	"bytes"
+	"testing"
+
+	"minc/ast"
+	"minc/parse"
+)
+
+/* parse a program the test cannot do without */
+func mustParse(t *testing.T, src string) *ast.Program {
+	program, err := parse.C(src, "f.c")
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	return program
+}
+
+/* is program the same as src, once both are printed back as C? */
+func checkProgram(t *testing.T, what string, program *ast.Program, src string) {
+	if got, want := program.String(), mustParse(t, src).String(); got != want {
+		t.Errorf("%s:\n%s\nwant\n%s", what, got, want)
+	}
+}
+
+func TestDCE(t *testing.T) {
+	tests := []struct{ src, want string }{
+		/* unreachable code */
+		{`long f(long x) { return x; x = 1; return 2; }`,
+			`long f(long x) { return x; }`},
+		{`long f(long x) { while (1) { x = x + 1; if (x > 9) return x; } return 0; }`,
+			`long f(long x) { while (1) { x = x + 1; if (x > 9) return x; } }`},
+		{`long f(long x) { while (x) { break; x = 2; } return x; }`,
+			`long f(long x) { while (x) { break; } return x; }`},
+		{`long f(long x) { for (;;) { continue; x = 2; } }`,
+			`long f(long x) { for (;;) { continue; } }`},
+		/* and so is a block declaring a variable, declaration included */
+		{`long f(long x) { return x; { long y; y = 1; } }`,
+			`long f(long x) { return x; }`},
+		/* dead stores and dead values */
+		{`long f(long x) { long y; y = x * 2; return x; }`,
+			`long f(long x) { return x; }`},
+		{`long g(long x) { return x; } long f(long x) { long y; y = g(x); return x; }`,
+			`long g(long x) { return x; } long f(long x) { g(x); return x; }`},
+		{`long g(long x) { return x; } long f(long x) { x + g(x); x * 2; return x; }`,
+			`long g(long x) { return x; } long f(long x) { g(x); return x; }`},
+		{`long f(long x) { long y = x + 1; y = 2; return y; }`,
+			`long f(long x) { long y; y = 2; return y; }`},
+		/* stores that a later read needs are kept */
+		{`long f(long x) { long y; y = x; while (x) { x = x - y; } return y; }`,
+			`long f(long x) { long y; y = x; while (x) { x = x - y; } return y; }`},
+	}
+	for _, tt := range tests {
+		program := mustParse(t, tt.src)
+		dce_program(program, newAnalyses())
+		checkProgram(t, tt.src, program, tt.want)
+	}
+}
+
+func TestWarnUnreachable(t *testing.T) {
+	tests := []struct{ src, want string }{
+		{`long f(long x) { return x; x = 1; x = 2; }`,
+			"minc: warning: unreachable statement in function 'f': x = 1;\n"},
+		{`long f(long x) { while (x) { continue; x = 1; } if (x) return 1; else return 2; return 3; }`,
+			"minc: warning: unreachable statement in function 'f': x = 1;\n" +
+				"minc: warning: unreachable statement in function 'f': return 3;\n"},
+		{`long f(long x) { if (x) return 1; return 2; }`, ""},
+	}
+	for _, tt := range tests {
+		var w bytes.Buffer
+		warn_unreachable(mustParse(t, tt.src), newAnalyses(), &w)
+		if w.String() != tt.want {
+			t.Errorf("%s: %q, want %q", tt.src, w.String(), tt.want)
+		}
+	}
+}
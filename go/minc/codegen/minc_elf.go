@@ -0,0 +1,220 @@
+package codegen
+
+/* minc_elf
+
+   an Object (minc_as) as an ELF64 relocatable file (.o) for
+   AArch64 Linux, which ld (or gcc) links like the output of as:
+
+     ELF header
+     .text              the code
+     .rela.text         its relocations
+     .symtab            the symbols: the null one, the local ones
+                        ($x marks the code), then the global ones
+                        (sh_info tells where they start)
+     .strtab            the names of the symbols
+     .note.GNU-stack    empty: the stack need not be executable
+     .shstrtab          the names of the sections
+     section headers
+
+   the linker (minc_ld) writes executables with the same pieces.
+*/
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+)
+
+/* the sections, in the order of their headers */
+const (
+	elfSecText = 1 + iota
+	elfSecRelaText
+	elfSecSymtab
+	elfSecStrtab
+	elfSecNote
+	elfSecShstrtab
+	elfNumSections
+)
+
+var elfSectionNames = [elfNumSections]string{
+	"", ".text", ".rela.text", ".symtab", ".strtab", ".note.GNU-stack", ".shstrtab",
+}
+
+/* a string table: names and the offset of each */
+type elfStrtab struct {
+	buf bytes.Buffer
+}
+
+func newElfStrtab() *elfStrtab {
+	st := &elfStrtab{}
+	st.buf.WriteByte(0) // offset 0 is the empty name
+	return st
+}
+
+func (st *elfStrtab) add(name string) uint32 {
+	off := uint32(st.buf.Len())
+	st.buf.WriteString(name)
+	st.buf.WriteByte(0)
+	return off
+}
+
+/* pad buf with zeros to a multiple of align */
+func elfAlign(buf *bytes.Buffer, align int) {
+	for buf.Len()%align != 0 {
+		buf.WriteByte(0)
+	}
+}
+
+func elfWrite(buf *bytes.Buffer, v interface{}) {
+	binary.Write(buf, binary.LittleEndian, v)
+}
+
+/*
+the symbol table of symbols defined in .text at base + their value:
+the null symbol, the mapping symbol $x (what follows in .text is
+code), the local symbols, then the global ones (undefined ones are
+global). returns .symtab, .strtab, the first global symbol and the
+index of every symbol
+*/
+func elfSymtab(symbols []*ObjSymbol, base uint64) ([]byte, []byte, int, map[string]int) {
+	strtab := newElfStrtab()
+	syms := []elf.Sym64{{}, {
+		Name:  strtab.add("$x"),
+		Info:  elf.ST_INFO(elf.STB_LOCAL, elf.STT_NOTYPE),
+		Shndx: elfSecText,
+		Value: base,
+	}}
+	index := make(map[string]int)
+	firstGlobal := 0
+	for _, global := range []bool{false, true} {
+		if global {
+			firstGlobal = len(syms)
+		}
+		for _, sym := range symbols {
+			if (sym.global || !sym.defined) != global {
+				continue
+			}
+			typ, bind := elf.STT_NOTYPE, elf.STB_LOCAL
+			if sym.isFunc {
+				typ = elf.STT_FUNC
+			}
+			if global {
+				bind = elf.STB_GLOBAL
+			}
+			shndx, value := uint16(elf.SHN_UNDEF), uint64(0)
+			if sym.defined {
+				shndx, value = elfSecText, base+sym.value
+			}
+			index[sym.name] = len(syms)
+			syms = append(syms, elf.Sym64{
+				Name:  strtab.add(sym.name),
+				Info:  elf.ST_INFO(bind, typ),
+				Shndx: shndx,
+				Value: value,
+				Size:  sym.size,
+			})
+		}
+	}
+	var symtab bytes.Buffer
+	for _, sym := range syms {
+		elfWrite(&symtab, sym)
+	}
+	return symtab.Bytes(), strtab.buf.Bytes(), firstGlobal, index
+}
+
+/* an ELF file being written: its sections one after the other, then their headers */
+type elfFile struct {
+	out      bytes.Buffer
+	shstrtab *elfStrtab
+	headers  []elf.Section64
+}
+
+/*
+start a file with sections of these names (the first one "", the
+last one .shstrtab), leaving room for headerSize bytes of ELF
+header and program headers
+*/
+func newElfFile(headerSize int, names []string) *elfFile {
+	f := &elfFile{shstrtab: newElfStrtab(), headers: make([]elf.Section64, len(names))}
+	for i, name := range names {
+		if name != "" {
+			f.headers[i].Name = f.shstrtab.add(name)
+		}
+	}
+	f.out.Write(make([]byte, headerSize))
+	return f
+}
+
+/* append section i; its header is returned for the fields that depend on the section */
+func (f *elfFile) section(i int, typ elf.SectionType, flags elf.SectionFlag, data []byte, align int) *elf.Section64 {
+	elfAlign(&f.out, align)
+	f.headers[i] = elf.Section64{
+		Name:      f.headers[i].Name,
+		Type:      uint32(typ),
+		Flags:     uint64(flags),
+		Off:       uint64(f.out.Len()),
+		Size:      uint64(len(data)),
+		Addralign: uint64(align),
+	}
+	f.out.Write(data)
+	return &f.headers[i]
+}
+
+/* append .shstrtab and the section headers; the file, with the ELF header and program headers */
+func (f *elfFile) finish(typ elf.Type, entry uint64, progs []elf.Prog64) []byte {
+	last := len(f.headers) - 1
+	f.section(last, elf.SHT_STRTAB, 0, f.shstrtab.buf.Bytes(), 1)
+	elfAlign(&f.out, 8)
+	shoff := f.out.Len()
+	for _, h := range f.headers {
+		elfWrite(&f.out, h)
+	}
+
+	var header bytes.Buffer
+	ident := [elf.EI_NIDENT]byte{0x7f, 'E', 'L', 'F', byte(elf.ELFCLASS64), byte(elf.ELFDATA2LSB), byte(elf.EV_CURRENT)}
+	h := elf.Header64{
+		Ident:     ident,
+		Type:      uint16(typ),
+		Machine:   uint16(elf.EM_AARCH64),
+		Version:   uint32(elf.EV_CURRENT),
+		Entry:     entry,
+		Shoff:     uint64(shoff),
+		Ehsize:    64,
+		Shentsize: 64,
+		Shnum:     uint16(len(f.headers)),
+		Shstrndx:  uint16(last),
+	}
+	if len(progs) > 0 {
+		h.Phoff, h.Phentsize, h.Phnum = 64, 56, uint16(len(progs))
+	}
+	elfWrite(&header, h)
+	for _, p := range progs {
+		elfWrite(&header, p)
+	}
+	b := f.out.Bytes()
+	copy(b, header.Bytes())
+	return b
+}
+
+/* the ELF relocatable file of an object */
+func ElfObject(obj *Object) []byte {
+	symtab, strtab, firstGlobal, index := elfSymtab(obj.symbols, 0)
+	var rela bytes.Buffer
+	for _, r := range obj.relocs {
+		elfWrite(&rela, elf.Rela64{
+			Off:    r.offset,
+			Info:   elf.R_INFO(uint32(index[r.sym]), uint32(r.typ)),
+			Addend: r.addend,
+		})
+	}
+
+	f := newElfFile(64, elfSectionNames[:])
+	f.section(elfSecText, elf.SHT_PROGBITS, elf.SHF_ALLOC|elf.SHF_EXECINSTR, obj.text, 4)
+	h := f.section(elfSecRelaText, elf.SHT_RELA, elf.SHF_INFO_LINK, rela.Bytes(), 8)
+	h.Link, h.Info, h.Entsize = elfSecSymtab, elfSecText, 24
+	h = f.section(elfSecSymtab, elf.SHT_SYMTAB, 0, symtab, 8)
+	h.Link, h.Info, h.Entsize = elfSecStrtab, uint32(firstGlobal), 24
+	f.section(elfSecStrtab, elf.SHT_STRTAB, 0, strtab, 1)
+	f.section(elfSecNote, elf.SHT_PROGBITS, 0, nil, 1)
+	return f.finish(elf.ET_REL, 0, nil)
+}
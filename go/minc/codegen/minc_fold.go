@@ -0,0 +1,407 @@
+package codegen
+
+/* minc_fold
+
+   constant folding and algebraic simplification on the AST.
+
+   fold_program rewrites every expression of a program in place,
+   evaluating subexpressions whose operands are all literals with
+   the semantics of C long on a 64-bit two's complement machine:
+
+     1 + 2                   -> 3
+     9223372036854775807 + 1 -> -9223372036854775808 (wraparound)
+     -8 >> 1                 -> -4 (arithmetic shift)
+     1 / 0                   -> 1 / 0 (left alone; undefined in C)
+
+   and applying identities that do not need the operands' values:
+
+     x + 0, x - 0, x * 1, x / 1, x | 0, x ^ 0 -> x
+     x * 8                -> x << 3
+     x - x, x * 0, x & 0  -> 0  (only when x has no side effects)
+*/
+
+import (
+	"math"
+
+	"minc/ast"
+)
+
+/* does evaluating expr have no side effect (assignment or call)? */
+func exprIsPure(expr ast.Expr) bool {
+	switch e := expr.(type) {
+	case *ast.ExprIntLiteral, *ast.ExprId:
+		return true
+	case *ast.ExprParen:
+		return exprIsPure(e.SubExpr)
+	case *ast.ExprOp:
+		if e.Op == "=" {
+			return false
+		}
+		for _, arg := range e.Args {
+			if !exprIsPure(arg) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+/* structural equality of two expressions, ignoring parentheses */
+func exprEqual(a, b ast.Expr) bool {
+	a = ast.StripParen(a)
+	b = ast.StripParen(b)
+	switch x := a.(type) {
+	case *ast.ExprIntLiteral:
+		y, ok := b.(*ast.ExprIntLiteral)
+		return ok && x.Val == y.Val
+	case *ast.ExprId:
+		y, ok := b.(*ast.ExprId)
+		return ok && x.Name == y.Name
+	case *ast.ExprOp:
+		y, ok := b.(*ast.ExprOp)
+		if !ok || x.Op != y.Op || len(x.Args) != len(y.Args) {
+			return false
+		}
+		for i := range x.Args {
+			if !exprEqual(x.Args[i], y.Args[i]) {
+				return false
+			}
+		}
+		return true
+	case *ast.ExprCall:
+		y, ok := b.(*ast.ExprCall)
+		if !ok || len(x.Args) != len(y.Args) || !exprEqual(x.Fun, y.Fun) {
+			return false
+		}
+		for i := range x.Args {
+			if !exprEqual(x.Args[i], y.Args[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+/* the value of expr if it is an integer literal */
+func literalValue(expr ast.Expr) (int64, bool) {
+	if lit, ok := ast.StripParen(expr).(*ast.ExprIntLiteral); ok {
+		return lit.Val, true
+	}
+	return 0, false
+}
+
+func boolToLong(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+/* k if v == 2^k (k >= 1), -1 otherwise */
+func log2Exact(v int64) int {
+	if v < 2 || v&(v-1) != 0 {
+		return -1
+	}
+	k := 0
+	for v > 1 {
+		v >>= 1
+		k++
+	}
+	return k
+}
+
+/*
+evaluate a unary operator on a constant.
+the second result is false when the operation cannot be folded
+*/
+func evalUnaryOp(op string, x int64) (int64, bool) {
+	switch op {
+	case "+":
+		return x, true
+	case "-":
+		return -x, true
+	case "!":
+		return boolToLong(x == 0), true
+	case "~":
+		return ^x, true
+	}
+	return 0, false
+}
+
+/*
+evaluate a binary operator on constants with C long semantics.
+Go's int64 arithmetic already wraps around and >> on a signed
+value is an arithmetic shift. operations that are undefined in C
+(division by zero, LONG_MIN / -1, shifting by a negative amount or
+by 64 or more) are not folded, so they behave exactly as they
+would without this pass.
+*/
+func evalBinaryOp(op string, x, y int64) (int64, bool) {
+	switch op {
+	case "+":
+		return x + y, true
+	case "-":
+		return x - y, true
+	case "*":
+		return x * y, true
+	case "/", "%":
+		if y == 0 || (y == -1 && x == math.MinInt64) {
+			return 0, false
+		}
+		if op == "/" {
+			return x / y, true
+		}
+		return x % y, true
+	case "<<", ">>":
+		if y < 0 || y >= 64 {
+			return 0, false
+		}
+		if op == "<<" {
+			return x << uint(y), true
+		}
+		return x >> uint(y), true
+	case "&":
+		return x & y, true
+	case "|":
+		return x | y, true
+	case "^":
+		return x ^ y, true
+	case "==":
+		return boolToLong(x == y), true
+	case "!=":
+		return boolToLong(x != y), true
+	case "<":
+		return boolToLong(x < y), true
+	case "<=":
+		return boolToLong(x <= y), true
+	case ">":
+		return boolToLong(x > y), true
+	case ">=":
+		return boolToLong(x >= y), true
+	case "&&":
+		return boolToLong(x != 0 && y != 0), true
+	case "||":
+		return boolToLong(x != 0 || y != 0), true
+	}
+	return 0, false
+}
+
+/* fold an expression bottom up and return the simplified one */
+func foldExpr(expr ast.Expr) ast.Expr {
+	switch e := expr.(type) {
+	case *ast.ExprParen:
+		sub := foldExpr(e.SubExpr)
+		switch sub.(type) {
+		case *ast.ExprIntLiteral, *ast.ExprId, *ast.ExprParen, *ast.ExprCall:
+			return sub
+		}
+		return &ast.ExprParen{SubExpr: sub}
+
+	case *ast.ExprCall:
+		e.Fun = foldExpr(e.Fun)
+		for i, arg := range e.Args {
+			e.Args[i] = foldExpr(arg)
+		}
+		return e
+
+	case *ast.ExprOp:
+		for i, arg := range e.Args {
+			e.Args[i] = foldExpr(arg)
+		}
+		if len(e.Args) == 1 {
+			return foldUnaryOp(e)
+		}
+		if len(e.Args) == 2 {
+			return foldBinaryOp(e)
+		}
+		return e
+	}
+	return expr
+}
+
+func foldUnaryOp(e *ast.ExprOp) ast.Expr {
+	arg := e.Args[0]
+	if x, ok := literalValue(arg); ok {
+		if v, ok := evalUnaryOp(e.Op, x); ok {
+			return &ast.ExprIntLiteral{Val: v}
+		}
+	}
+	switch e.Op {
+	case "+":
+		return arg
+	case "-", "~":
+		// -(-x) -> x, ~(~x) -> x
+		if inner, ok := ast.StripParen(arg).(*ast.ExprOp); ok && inner.Op == e.Op && len(inner.Args) == 1 {
+			return inner.Args[0]
+		}
+	}
+	return e
+}
+
+func foldBinaryOp(e *ast.ExprOp) ast.Expr {
+	left, right := e.Args[0], e.Args[1]
+	x, lconst := literalValue(left)
+	y, rconst := literalValue(right)
+
+	if lconst && rconst {
+		if v, ok := evalBinaryOp(e.Op, x, y); ok {
+			return &ast.ExprIntLiteral{Val: v}
+		}
+		return e
+	}
+
+	switch e.Op {
+	case "+":
+		if lconst && x == 0 {
+			return right
+		}
+		if rconst && y == 0 {
+			return left
+		}
+		// (a + c1) + c2 -> a + (c1 + c2)
+		if rconst {
+			if inner, ok := left.(*ast.ExprOp); ok && inner.Op == "+" && len(inner.Args) == 2 {
+				if c, ok := literalValue(inner.Args[1]); ok {
+					return foldBinaryOp(&ast.ExprOp{Op: "+", Args: []ast.Expr{inner.Args[0], &ast.ExprIntLiteral{Val: c + y}}})
+				}
+			}
+		}
+	case "-":
+		if rconst && y == 0 {
+			return left
+		}
+		if lconst && x == 0 {
+			return foldUnaryOp(&ast.ExprOp{Op: "-", Args: []ast.Expr{right}})
+		}
+		if exprIsPure(left) && exprEqual(left, right) {
+			return &ast.ExprIntLiteral{Val: 0}
+		}
+		// (a + c1) - c2 -> a + (c1 - c2)
+		if rconst {
+			if inner, ok := left.(*ast.ExprOp); ok && inner.Op == "+" && len(inner.Args) == 2 {
+				if c, ok := literalValue(inner.Args[1]); ok {
+					return foldBinaryOp(&ast.ExprOp{Op: "+", Args: []ast.Expr{inner.Args[0], &ast.ExprIntLiteral{Val: c - y}}})
+				}
+			}
+		}
+	case "*":
+		if lconst && !rconst {
+			// canonicalize the constant to the right
+			left, right = right, left
+			x, y = y, x
+			rconst = true
+		}
+		if rconst {
+			switch {
+			case y == 1:
+				return left
+			case y == 0 && exprIsPure(left):
+				return &ast.ExprIntLiteral{Val: 0}
+			case y == -1:
+				return foldUnaryOp(&ast.ExprOp{Op: "-", Args: []ast.Expr{left}})
+			}
+			if k := log2Exact(y); k > 0 {
+				return &ast.ExprOp{Op: "<<", Args: []ast.Expr{left, &ast.ExprIntLiteral{Val: int64(k)}}}
+			}
+			return &ast.ExprOp{Op: "*", Args: []ast.Expr{left, right}}
+		}
+	case "/":
+		if rconst && y == 1 {
+			return left
+		}
+	case "%":
+		if rconst && (y == 1 || y == -1) && exprIsPure(left) {
+			return &ast.ExprIntLiteral{Val: 0}
+		}
+	case "<<", ">>":
+		if rconst && y == 0 {
+			return left
+		}
+	case "&":
+		if (lconst && x == 0 && exprIsPure(right)) || (rconst && y == 0 && exprIsPure(left)) {
+			return &ast.ExprIntLiteral{Val: 0}
+		}
+		if rconst && y == -1 {
+			return left
+		}
+		if lconst && x == -1 {
+			return right
+		}
+	case "|", "^":
+		if lconst && x == 0 {
+			return right
+		}
+		if rconst && y == 0 {
+			return left
+		}
+	case "==", "<=", ">=":
+		if exprIsPure(left) && exprEqual(left, right) {
+			return &ast.ExprIntLiteral{Val: 1}
+		}
+	case "!=", "<", ">":
+		if exprIsPure(left) && exprEqual(left, right) {
+			return &ast.ExprIntLiteral{Val: 0}
+		}
+	case "&&":
+		// the right operand is never evaluated when the left one is 0
+		if lconst && x == 0 {
+			return &ast.ExprIntLiteral{Val: 0}
+		}
+		if lconst {
+			return &ast.ExprOp{Op: "!=", Args: []ast.Expr{right, &ast.ExprIntLiteral{Val: 0}}}
+		}
+	case "||":
+		if lconst && x != 0 {
+			return &ast.ExprIntLiteral{Val: 1}
+		}
+		if lconst {
+			return &ast.ExprOp{Op: "!=", Args: []ast.Expr{right, &ast.ExprIntLiteral{Val: 0}}}
+		}
+	}
+	return e
+}
+
+/* fold every expression in a statement (in place) */
+func foldStmt(stmt ast.Stmt) {
+	switch s := stmt.(type) {
+	case *ast.StmtReturn:
+		if s.Expr != nil {
+			s.Expr = foldExpr(s.Expr)
+		}
+	case *ast.StmtExpr:
+		s.Expr = foldExpr(s.Expr)
+	case *ast.StmtDeclInit:
+		s.Init = foldExpr(s.Init)
+	case *ast.StmtCompound:
+		for _, sub := range s.Stmts {
+			foldStmt(sub)
+		}
+	case *ast.StmtIf:
+		s.Cond = foldExpr(s.Cond)
+		foldStmt(s.ThenStmt)
+		if s.ElseStmt != nil {
+			foldStmt(s.ElseStmt)
+		}
+	case *ast.StmtWhile:
+		s.Cond = foldExpr(s.Cond)
+		foldStmt(s.Body)
+	case *ast.StmtFor:
+		foldStmt(s.Init)
+		if s.Cond != nil {
+			s.Cond = foldExpr(s.Cond)
+		}
+		foldStmt(s.Post)
+		foldStmt(s.Body)
+	}
+}
+
+/* fold every expression in a program (in place) */
+func fold_program(program *ast.Program) {
+	for _, def := range program.Defs {
+		if d, ok := def.(*ast.DefFun); ok {
+			foldStmt(d.Body)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package codegen
+
+import (
+	"testing"
+
+	"minc/ast"
+	"minc/parse"
+)
+
+/* the expression e of long f(long x, long y) { return e; }, folded */
+func foldedReturn(t *testing.T, expr string) string {
+	program, err := parse.C("long g(long x) { return x; } long f(long x, long y) { return "+expr+"; }", "f.c")
+	if err != nil {
+		t.Fatalf("%s: %v", expr, err)
+	}
+	fold_program(program)
+	body := program.Defs[1].(*ast.DefFun).Body.(*ast.StmtCompound)
+	return body.Stmts[0].(*ast.StmtReturn).Expr.ExprString()
+}
+
+func TestFold(t *testing.T) {
+	tests := []struct{ expr, want string }{
+		/* constants, with C long semantics */
+		{"1 + 2 * 3", "7"},
+		{"9223372036854775807 + 1", "(-9223372036854775807 - 1)"},
+		{"-8 >> 1", "-4"},
+		{"-7 / 2", "-3"},
+		{"-7 % 2", "-1"},
+		{"!5 + ~0", "-1"},
+		/* undefined in C: left alone */
+		{"1 / 0", "1 / 0"},
+		{"1 % 0", "1 % 0"},
+		{"(-9223372036854775807 - 1) / -1", "(-9223372036854775807 - 1) / -1"},
+		{"(-9223372036854775807 - 1) % -1", "(-9223372036854775807 - 1) % -1"},
+		{"1 << 64", "1 << 64"},
+		{"1 << -1", "1 << -1"},
+		/* identities */
+		{"x + 0", "x"},
+		{"0 + x", "x"},
+		{"x - 0", "x"},
+		{"0 - x", "-x"},
+		{"x * 1", "x"},
+		{"1 * x", "x"},
+		{"x * -1", "-x"},
+		{"x / 1", "x"},
+		{"x | 0", "x"},
+		{"x & -1", "x"},
+		{"x << 0", "x"},
+		{"x * 8", "x << 3"},
+		{"8 * x", "x << 3"},
+		{"x * 6", "x * 6"},
+		{"x + 1 + 2", "x + 3"},
+		{"x + 5 - 2", "x + 3"},
+		/* to a constant only when x has no side effect */
+		{"x * 0", "0"},
+		{"x - x", "0"},
+		{"x & 0", "0"},
+		{"x % 1", "0"},
+		{"x == x", "1"},
+		{"x < x", "0"},
+		{"g(y) * 0", "g(y) * 0"},
+		{"0 * g(y)", "g(y) * 0"},
+		{"(x = y) * 0", "(x = y) * 0"},
+		{"g(y) - g(y)", "g(y) - g(y)"},
+		{"g(y) & 0", "g(y) & 0"},
+		{"g(y) % 1", "g(y) % 1"},
+		/* the right operand of && and || is not evaluated */
+		{"0 && g(y)", "0"},
+		{"1 || g(y)", "1"},
+		{"1 && g(y)", "g(y) != 0"},
+		{"x / 0", "x / 0"},
+	}
+	for _, tt := range tests {
+		if got := foldedReturn(t, tt.expr); got != tt.want {
+			t.Errorf("%s: %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package codegen
+
+/* minc_imm
+
+   immediates that do not fit in an AArch64 instruction.
+
+   an AArch64 instruction has room for only some constants:
+
+   - mov (move wide): a 16-bit value shifted by 0, 16, 32 or 48
+     (movz), or the complement of one (movn).
+   - mov (bitmask): a "logical immediate", a repeated pattern of a
+     rotated run of ones, such as 0x00ff00ff00ff00ff.
+   - add/sub: 0 ... 4095, optionally shifted left by 12.
+   - ldr/str: a multiple of 8 up to 32760, or -256 ... 255.
+
+   any other 64-bit value is built from a movz (or movn) followed
+   by a movk for each remaining 16-bit chunk, at most four
+   instructions:
+
+     0x7fffffffffffffff  ->  mov x0, #9223372036854775807 (bitmask)
+     0x123456789         ->  movz x0, #0x6789, lsl #0
+                             movk x0, #0x2345, lsl #16
+                             movk x0, #0x1, lsl #32
+     -0x123456789        ->  movn x0, #0x6788, lsl #0
+                             movk x0, #0xdcba, lsl #16
+                             movk x0, #0xfffe, lsl #32
+*/
+
+import "fmt"
+
+/* chunk k (0..3) of v, the bits 16k ... 16k+15 */
+func chunk16(v uint64, k int) uint64 {
+	return (v >> (16 * uint(k))) & 0xffff
+}
+
+/* can mov (movz or movn) load v in a single instruction? */
+func isMovWideImm(v uint64) bool {
+	zero, ones := 0, 0
+	for k := 0; k < 4; k++ {
+		switch chunk16(v, k) {
+		case 0:
+			zero++
+		case 0xffff:
+			ones++
+		}
+	}
+	return zero >= 3 || ones >= 3
+}
+
+/* is v encodable as the immediate of a 64-bit and/orr/eor? */
+func isLogicalImm(v uint64) bool {
+	if v == 0 || v == ^uint64(0) {
+		return false
+	}
+	/* the smallest element the value is a repetition of */
+	size := uint(64)
+	for size > 2 {
+		half := size / 2
+		mask := uint64(1)<<half - 1
+		if v&mask != (v>>half)&mask {
+			break
+		}
+		size = half
+	}
+	mask := ^uint64(0)
+	if size < 64 {
+		mask = uint64(1)<<size - 1
+	}
+	elem := v & mask
+	/* the element must be a rotation of a contiguous run of ones */
+	for r := uint(0); r < size; r++ {
+		rot := ((elem >> r) | (elem << (size - r))) & mask
+		if rot&(rot+1) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+/* is v (>= 0) encodable as the immediate of add or sub? */
+func isAddSubImm(v int64) bool {
+	return (v >= 0 && v <= 4095) || (v&0xfff == 0 && v>>12 >= 0 && v>>12 <= 4095)
+}
+
+/* can ldr/str address [base, #offset] directly? */
+func isMemOffset(offset int) bool {
+	return (offset >= -256 && offset <= 255) || (offset >= 0 && offset <= 32760 && offset%8 == 0)
+}
+
+/* the immediate operand #v */
+func imm(v int64) string {
+	return fmt.Sprintf("#%d", v)
+}
+
+/* the operand lsl #n, shifting the immediate before it */
+func lsl(n int) string {
+	return fmt.Sprintf("lsl #%d", n)
+}
+
+/* load the constant v into reg */
+func (t *AArch64) movImm(g *CodeGen, reg string, v int64) {
+	u := uint64(v)
+	if isMovWideImm(u) || isLogicalImm(u) {
+		g.emit("mov", reg, imm(v))
+		return
+	}
+	/* start from all zeros (movz) or all ones (movn), whichever leaves fewer chunks */
+	zero, ones := 0, 0
+	for k := 0; k < 4; k++ {
+		switch chunk16(u, k) {
+		case 0:
+			zero++
+		case 0xffff:
+			ones++
+		}
+	}
+	fill := uint64(0)
+	if ones > zero {
+		fill = 0xffff
+	}
+	first := true
+	for k := 0; k < 4; k++ {
+		c := chunk16(u, k)
+		if c == fill {
+			continue
+		}
+		switch {
+		case first && fill == 0:
+			g.emit("movz", reg, fmt.Sprintf("#0x%x", c), lsl(16*k))
+		case first:
+			g.emit("movn", reg, fmt.Sprintf("#0x%x", c^0xffff), lsl(16*k))
+		default:
+			g.emit("movk", reg, fmt.Sprintf("#0x%x", c), lsl(16*k))
+		}
+		first = false
+	}
+}
+
+/*
+dst = src + v, where src and dst may be sp. large values are split
+into a part shifted by 12 and the rest, or loaded into x16 (which
+nothing else uses) when they do not fit in 24 bits
+*/
+func (t *AArch64) addImm(g *CodeGen, dst, src string, v int64) {
+	op := "add"
+	if v < 0 {
+		op, v = "sub", -v
+	}
+	switch {
+	case isAddSubImm(v):
+		g.emit(op, dst, src, imm(v))
+	case v < 1<<24:
+		g.emit(op, dst, src, imm(v>>12), lsl(12))
+		g.emit(op, dst, dst, imm(v&0xfff))
+	default:
+		t.movImm(g, "x16", v)
+		g.emit(op, dst, src, "x16")
+	}
+}
+
+/* the address operand for [base + offset], computing it into x9 if needed */
+func (t *AArch64) memAddr(g *CodeGen, base string, offset int) string {
+	if isMemOffset(offset) {
+		return fmt.Sprintf("[%s, #%d]", base, offset)
+	}
+	t.addImm(g, "x9", base, int64(offset))
+	return "[x9]"
+}
@@ -0,0 +1,825 @@
+package codegen
+
+/* minc_inline
+
+   function inlining.
+
+   a call to a small function defined in the same program is
+   replaced by a copy of the function's body. the callee's
+   parameters and local variables become new local variables of
+   the caller (_inl0, _inl1, ...), its returns assign the variable
+   standing for the call's value, and the statement containing the
+   call reads that variable:
+
+     long inc(long x) { return x + 1; }
+     ...
+     result = inc(y * 2);
+       ->
+     _inl0 = y * 2;
+     { _inl1 = _inl0 + 1; }
+     result = _inl1;
+
+   a parameter whose argument is a variable or a literal and that
+   the callee never assigns is replaced by the argument itself, so
+   square(result) becomes result * result.
+
+   - cost model: only functions whose body has at most limit nodes
+     (statements and expressions, see stmtSize) are inlined.
+     functions are processed callees first, so the size that counts
+     is the one after their own calls were inlined.
+   - recursion: a function that can call itself, directly or through
+     other functions, is never inlined.
+   - the call must be evaluated unconditionally (not in the right
+     operand of && or ||), at the statement level (not in the
+     condition of a loop), with arguments without side effects, and
+     nothing with a side effect may be evaluated before it in its
+     statement; the call is then evaluated first.
+   - the callee's returns must be able to become the last statement
+     of the copy (if (c) return a; return b; can, a return inside a
+     loop cannot), as minc has no goto.
+*/
+
+import (
+	"fmt"
+	"io"
+
+	"minc/ast"
+)
+
+/* default maximum size of an inlined function (-finline-limit) */
+const DefaultInlineLimit = 40
+
+/* --- call graph --- */
+
+/* names of the functions called by an expression */
+func exprCalls(expr ast.Expr, calls map[string]bool) {
+	switch e := expr.(type) {
+	case *ast.ExprParen:
+		exprCalls(e.SubExpr, calls)
+	case *ast.ExprCall:
+		if id, ok := e.Fun.(*ast.ExprId); ok {
+			calls[id.Name] = true
+		} else {
+			exprCalls(e.Fun, calls)
+		}
+		for _, arg := range e.Args {
+			exprCalls(arg, calls)
+		}
+	case *ast.ExprOp:
+		for _, arg := range e.Args {
+			exprCalls(arg, calls)
+		}
+	}
+}
+
+/* names of the functions called by a statement */
+func stmtCalls(stmt ast.Stmt, calls map[string]bool) {
+	switch s := stmt.(type) {
+	case *ast.StmtCompound:
+		for _, sub := range s.Stmts {
+			stmtCalls(sub, calls)
+		}
+	case *ast.StmtIf:
+		exprCalls(s.Cond, calls)
+		stmtCalls(s.ThenStmt, calls)
+		if s.ElseStmt != nil {
+			stmtCalls(s.ElseStmt, calls)
+		}
+	case *ast.StmtWhile:
+		exprCalls(s.Cond, calls)
+		stmtCalls(s.Body, calls)
+	case *ast.StmtFor:
+		stmtCalls(s.Init, calls)
+		if s.Cond != nil {
+			exprCalls(s.Cond, calls)
+		}
+		stmtCalls(s.Post, calls)
+		stmtCalls(s.Body, calls)
+	case *ast.StmtDeclInit:
+		exprCalls(s.Init, calls)
+	case *ast.StmtExpr:
+		exprCalls(s.Expr, calls)
+	case *ast.StmtReturn:
+		if s.Expr != nil {
+			exprCalls(s.Expr, calls)
+		}
+	}
+}
+
+/* --- cost model --- */
+
+/* number of expression nodes */
+func exprSize(expr ast.Expr) int {
+	switch e := expr.(type) {
+	case *ast.ExprParen:
+		return exprSize(e.SubExpr)
+	case *ast.ExprCall:
+		n := 1
+		for _, arg := range e.Args {
+			n += exprSize(arg)
+		}
+		return n
+	case *ast.ExprOp:
+		n := 1
+		for _, arg := range e.Args {
+			n += exprSize(arg)
+		}
+		return n
+	}
+	return 1
+}
+
+/* number of statement and expression nodes */
+func stmtSize(stmt ast.Stmt) int {
+	switch s := stmt.(type) {
+	case *ast.StmtCompound:
+		n := 1
+		for _, sub := range s.Stmts {
+			n += stmtSize(sub)
+		}
+		return n
+	case *ast.StmtIf:
+		n := 1 + exprSize(s.Cond) + stmtSize(s.ThenStmt)
+		if s.ElseStmt != nil {
+			n += stmtSize(s.ElseStmt)
+		}
+		return n
+	case *ast.StmtWhile:
+		return 1 + exprSize(s.Cond) + stmtSize(s.Body)
+	case *ast.StmtFor:
+		n := 1 + stmtSize(s.Init) + stmtSize(s.Post) + stmtSize(s.Body)
+		if s.Cond != nil {
+			n += exprSize(s.Cond)
+		}
+		return n
+	case *ast.StmtDeclInit:
+		return 1 + exprSize(s.Init)
+	case *ast.StmtExpr:
+		return 1 + exprSize(s.Expr)
+	case *ast.StmtReturn:
+		if s.Expr != nil {
+			return 1 + exprSize(s.Expr)
+		}
+	}
+	return 1
+}
+
+/* --- copying the callee --- */
+
+/*
+copies a function body. subst maps the callee's variables to what
+replaces them in the copy; fresh names a new caller variable
+*/
+type inlineCopier struct {
+	subst map[string]ast.Expr
+	fresh func() string
+	decls []*ast.Decl // the variables created for the callee's locals
+}
+
+func (c *inlineCopier) declare(name string) string {
+	temp := c.fresh()
+	c.subst[name] = &ast.ExprId{Name: temp}
+	c.decls = append(c.decls, &ast.Decl{VarType: &ast.TypePrimitive{Name: "long"}, Name: temp})
+	return temp
+}
+
+func (c *inlineCopier) copyExpr(expr ast.Expr) ast.Expr {
+	switch e := expr.(type) {
+	case *ast.ExprIntLiteral:
+		return &ast.ExprIntLiteral{Val: e.Val}
+	case *ast.ExprId:
+		/* a variable or a literal: the caller's, not to be renamed again */
+		switch r := c.subst[e.Name].(type) {
+		case *ast.ExprId:
+			return &ast.ExprId{Name: r.Name}
+		case *ast.ExprIntLiteral:
+			return &ast.ExprIntLiteral{Val: r.Val}
+		}
+		return &ast.ExprId{Name: e.Name}
+	case *ast.ExprParen:
+		return &ast.ExprParen{SubExpr: c.copyExpr(e.SubExpr)}
+	case *ast.ExprCall:
+		args := make([]ast.Expr, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = c.copyExpr(arg)
+		}
+		fun := e.Fun
+		if _, ok := fun.(*ast.ExprId); !ok {
+			fun = c.copyExpr(fun)
+		}
+		return &ast.ExprCall{Fun: fun, Args: args}
+	case *ast.ExprOp:
+		args := make([]ast.Expr, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = c.copyExpr(arg)
+		}
+		return &ast.ExprOp{Op: e.Op, Args: args}
+	}
+	return expr
+}
+
+/*
+copy a statement. the declarations of compound statements are
+dropped: every local variable gets a new name, declared by the caller
+*/
+func (c *inlineCopier) copyStmt(stmt ast.Stmt) ast.Stmt {
+	switch s := stmt.(type) {
+	case *ast.StmtCompound:
+		/* an inner declaration hides an outer one until the end of the block */
+		saved := make(map[string]ast.Expr, len(c.subst))
+		for k, v := range c.subst {
+			saved[k] = v
+		}
+		for _, d := range s.Decls {
+			c.declare(d.Name)
+		}
+		stmts := make([]ast.Stmt, len(s.Stmts))
+		for i, sub := range s.Stmts {
+			stmts[i] = c.copyStmt(sub)
+		}
+		c.subst = saved
+		return &ast.StmtCompound{Decls: nil, Stmts: stmts}
+	case *ast.StmtIf:
+		var else_stmt ast.Stmt
+		if s.ElseStmt != nil {
+			else_stmt = c.copyStmt(s.ElseStmt)
+		}
+		return &ast.StmtIf{Cond: c.copyExpr(s.Cond), ThenStmt: c.copyStmt(s.ThenStmt), ElseStmt: else_stmt}
+	case *ast.StmtWhile:
+		return &ast.StmtWhile{Cond: c.copyExpr(s.Cond), Body: c.copyStmt(s.Body)}
+	case *ast.StmtFor:
+		var cond ast.Expr
+		init := c.copyStmt(s.Init)
+		if s.Cond != nil {
+			cond = c.copyExpr(s.Cond)
+		}
+		return &ast.StmtFor{Init: init, Cond: cond, Post: c.copyStmt(s.Post), Body: c.copyStmt(s.Body)}
+	case *ast.StmtDeclInit:
+		/* long x = e; becomes _inlN = e; */
+		init := c.copyExpr(s.Init)
+		temp := c.declare(s.Decl.Name)
+		return &ast.StmtExpr{Expr: &ast.ExprOp{Op: "=", Args: []ast.Expr{&ast.ExprId{Name: temp}, init}}}
+	case *ast.StmtExpr:
+		return &ast.StmtExpr{Expr: c.copyExpr(s.Expr)}
+	case *ast.StmtReturn:
+		if s.Expr == nil {
+			return &ast.StmtReturn{Expr: nil}
+		}
+		return &ast.StmtReturn{Expr: c.copyExpr(s.Expr)}
+	case *ast.StmtEmpty:
+		return &ast.StmtEmpty{}
+	case *ast.StmtBreak:
+		return &ast.StmtBreak{}
+	case *ast.StmtContinue:
+		return &ast.StmtContinue{}
+	}
+	return stmt
+}
+
+/* --- returns --- */
+
+func containsReturn(stmt ast.Stmt) bool {
+	switch s := stmt.(type) {
+	case *ast.StmtReturn:
+		return true
+	case *ast.StmtCompound:
+		for _, sub := range s.Stmts {
+			if containsReturn(sub) {
+				return true
+			}
+		}
+	case *ast.StmtIf:
+		return containsReturn(s.ThenStmt) || (s.ElseStmt != nil && containsReturn(s.ElseStmt))
+	case *ast.StmtWhile:
+		return containsReturn(s.Body)
+	case *ast.StmtFor:
+		return containsReturn(s.Body)
+	}
+	return false
+}
+
+/* does every path through stmt end with a return? */
+func alwaysReturns(stmt ast.Stmt) bool {
+	switch s := stmt.(type) {
+	case *ast.StmtReturn:
+		return true
+	case *ast.StmtCompound:
+		for _, sub := range s.Stmts {
+			if alwaysReturns(sub) {
+				return true
+			}
+		}
+	case *ast.StmtIf:
+		return s.ElseStmt != nil && alwaysReturns(s.ThenStmt) && alwaysReturns(s.ElseStmt)
+	}
+	return false
+}
+
+/* the statements of a branch, followed by rest unless the branch returns */
+func branchWithRest(branch ast.Stmt, rest []ast.Stmt) []ast.Stmt {
+	var stmts []ast.Stmt
+	if branch != nil {
+		stmts = append(stmts, branch)
+	}
+	if branch == nil || !alwaysReturns(branch) {
+		stmts = append(stmts, rest...)
+	}
+	return stmts
+}
+
+/*
+rewrite a list of statements so that every return is the last
+statement executed: what follows an if that may return is moved into
+its branches that do not, and what follows a return is dropped.
+false if this cannot be done without copying code (both branches of
+an if may fall through to it) or a return is inside a loop
+*/
+func tailReturns(stmts []ast.Stmt) ([]ast.Stmt, bool) {
+	for i, stmt := range stmts {
+		rest := stmts[i+1:]
+		head := append([]ast.Stmt{}, stmts[:i]...)
+		if !containsReturn(stmt) {
+			continue
+		}
+		switch s := stmt.(type) {
+		case *ast.StmtReturn:
+			return append(head, s), true
+		case *ast.StmtCompound:
+			inner, ok := tailReturns(append(append([]ast.Stmt{}, s.Stmts...), rest...))
+			return append(head, &ast.StmtCompound{Decls: nil, Stmts: inner}), ok
+		case *ast.StmtIf:
+			may_fall := 0
+			for _, branch := range []ast.Stmt{s.ThenStmt, s.ElseStmt} {
+				if branch == nil || !alwaysReturns(branch) {
+					may_fall++
+				}
+			}
+			if may_fall == 2 && len(rest) > 0 {
+				return nil, false
+			}
+			then_stmts, ok1 := tailReturns(branchWithRest(s.ThenStmt, rest))
+			else_stmts, ok2 := tailReturns(branchWithRest(s.ElseStmt, rest))
+			var else_stmt ast.Stmt
+			if len(else_stmts) > 0 {
+				else_stmt = &ast.StmtCompound{Decls: nil, Stmts: else_stmts}
+			}
+			if_stmt := &ast.StmtIf{Cond: s.Cond, ThenStmt: &ast.StmtCompound{Decls: nil, Stmts: then_stmts}, ElseStmt: else_stmt}
+			return append(head, if_stmt), ok1 && ok2
+		}
+		return nil, false
+	}
+	return stmts, true
+}
+
+/* make the (tail) returns of stmt assign their value to ret */
+func returnsToAssign(stmt ast.Stmt, ret string) ast.Stmt {
+	switch s := stmt.(type) {
+	case *ast.StmtReturn:
+		if s.Expr == nil {
+			return &ast.StmtEmpty{}
+		}
+		return &ast.StmtExpr{Expr: &ast.ExprOp{Op: "=", Args: []ast.Expr{&ast.ExprId{Name: ret}, s.Expr}}}
+	case *ast.StmtCompound:
+		for i, sub := range s.Stmts {
+			s.Stmts[i] = returnsToAssign(sub, ret)
+		}
+	case *ast.StmtIf:
+		s.ThenStmt = returnsToAssign(s.ThenStmt, ret)
+		if s.ElseStmt != nil {
+			s.ElseStmt = returnsToAssign(s.ElseStmt, ret)
+		}
+	}
+	return stmt
+}
+
+/* --- call sites --- */
+
+/*
+calls of expr that are always evaluated, innermost first.
+parents records the expression each node is an argument of
+*/
+func unconditionalCalls(expr ast.Expr, parent ast.Expr, parents map[ast.Expr]ast.Expr, calls *[]*ast.ExprCall) {
+	parents[expr] = parent
+	switch e := expr.(type) {
+	case *ast.ExprParen:
+		unconditionalCalls(e.SubExpr, e, parents, calls)
+	case *ast.ExprCall:
+		for _, arg := range e.Args {
+			unconditionalCalls(arg, e, parents, calls)
+		}
+		*calls = append(*calls, e)
+	case *ast.ExprOp:
+		for i, arg := range e.Args {
+			if i > 0 && (e.Op == "&&" || e.Op == "||") {
+				break
+			}
+			unconditionalCalls(arg, e, parents, calls)
+		}
+	}
+}
+
+type inlineState struct {
+	funs      map[string]*ast.DefFun
+	recursive map[string]bool
+	pure      map[string]bool // functions without side effects
+	limit     int
+	dump      io.Writer
+	am        *Analyses // the graphs of the callees
+}
+
+/*
+is evaluating expr free of side effects, counting calls to functions
+without side effects as such? the nodes in skip are not examined
+*/
+func (st *inlineState) harmless(expr ast.Expr, skip map[ast.Expr]bool) bool {
+	if skip[expr] {
+		switch e := expr.(type) {
+		case *ast.ExprParen:
+			return st.harmless(e.SubExpr, skip)
+		case *ast.ExprCall:
+			for _, arg := range e.Args {
+				if !st.harmless(arg, skip) {
+					return false
+				}
+			}
+		case *ast.ExprOp:
+			for _, arg := range e.Args {
+				if !st.harmless(arg, skip) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	switch e := expr.(type) {
+	case *ast.ExprParen:
+		return st.harmless(e.SubExpr, skip)
+	case *ast.ExprCall:
+		id, ok := e.Fun.(*ast.ExprId)
+		if !ok || !st.pure[id.Name] {
+			return false
+		}
+		for _, arg := range e.Args {
+			if !st.harmless(arg, skip) {
+				return false
+			}
+		}
+		return true
+	case *ast.ExprOp:
+		if e.Op == "=" {
+			return false
+		}
+		for _, arg := range e.Args {
+			if !st.harmless(arg, skip) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+/* the function call in expr to inline, if any */
+func (st *inlineState) callToInline(caller *ast.DefFun, expr ast.Expr) (*ast.ExprCall, *ast.DefFun) {
+	parents := make(map[ast.Expr]ast.Expr)
+	var calls []*ast.ExprCall
+	unconditionalCalls(expr, nil, parents, &calls)
+	for _, call := range calls {
+		id, ok := call.Fun.(*ast.ExprId)
+		if !ok {
+			continue
+		}
+		callee := st.funs[id.Name]
+		if callee == nil || callee == caller || st.recursive[id.Name] ||
+			len(callee.Params) != len(call.Args) || stmtSize(callee.Body) > st.limit {
+			continue
+		}
+		args_pure := true
+		for _, arg := range call.Args {
+			args_pure = args_pure && exprIsPure(arg)
+		}
+		if !args_pure {
+			continue
+		}
+		/* the call and the expressions it is part of are evaluated after it */
+		skip := map[ast.Expr]bool{}
+		for x := ast.Expr(call); x != nil; x = parents[x] {
+			skip[x] = true
+		}
+		if !st.harmless(expr, skip) {
+			continue
+		}
+		if _, ok := tailReturns([]ast.Stmt{callee.Body}); !ok {
+			continue
+		}
+		return call, callee
+	}
+	return nil, nil
+}
+
+/* replace call (compared by pointer) in expr */
+func replaceCall(expr ast.Expr, call *ast.ExprCall, repl ast.Expr) ast.Expr {
+	switch e := expr.(type) {
+	case *ast.ExprParen:
+		e.SubExpr = replaceCall(e.SubExpr, call, repl)
+	case *ast.ExprCall:
+		if e == call {
+			return repl
+		}
+		for i, arg := range e.Args {
+			e.Args[i] = replaceCall(arg, call, repl)
+		}
+	case *ast.ExprOp:
+		for i, arg := range e.Args {
+			e.Args[i] = replaceCall(arg, call, repl)
+		}
+	}
+	return expr
+}
+
+/* the expression of a statement a call of which can be inlined */
+func inlineSiteExpr(stmt ast.Stmt) *ast.Expr {
+	switch s := stmt.(type) {
+	case *ast.StmtExpr:
+		return &s.Expr
+	case *ast.StmtReturn:
+		if s.Expr != nil {
+			return &s.Expr
+		}
+	case *ast.StmtDeclInit:
+		return &s.Init
+	case *ast.StmtIf:
+		return &s.Cond
+	}
+	return nil
+}
+
+/* statements of stmt, recursively, in order */
+func allStmts(stmt ast.Stmt, stmts *[]ast.Stmt) {
+	*stmts = append(*stmts, stmt)
+	switch s := stmt.(type) {
+	case *ast.StmtCompound:
+		for _, sub := range s.Stmts {
+			allStmts(sub, stmts)
+		}
+	case *ast.StmtIf:
+		allStmts(s.ThenStmt, stmts)
+		if s.ElseStmt != nil {
+			allStmts(s.ElseStmt, stmts)
+		}
+	case *ast.StmtWhile:
+		allStmts(s.Body, stmts)
+	case *ast.StmtFor:
+		allStmts(s.Init, stmts)
+		allStmts(s.Body, stmts)
+	}
+}
+
+/*
+inline call, in the expression at site of statement stmt of fun, of
+the function of cfg (cfg.fun), whose returns tailReturns can make the
+last statements it runs. used holds the names of fun's variables, to
+which those made for the copy of the callee are added
+*/
+func inlineCall(fun *ast.DefFun, stmt ast.Stmt, site *ast.Expr, call *ast.ExprCall, cfg *CFG, used map[string]bool) {
+	callee := cfg.fun
+	body := fun.Body.(*ast.StmtCompound)
+	c := &inlineCopier{subst: make(map[string]ast.Expr)}
+	c.fresh = func() string {
+		k := 0
+		for used[fmt.Sprintf("_inl%d", k)] {
+			k++
+		}
+		name := fmt.Sprintf("_inl%d", k)
+		used[name] = true
+		return name
+	}
+
+	/* parameters */
+	assigned := make(map[string]bool)
+	for _, bb := range cfg.blocks {
+		for _, n := range bb.nodes {
+			for v := range n.assigned() {
+				assigned[v] = true
+			}
+		}
+	}
+	var pre []ast.Stmt
+	for i, p := range callee.Params {
+		arg := ast.StripParen(call.Args[i])
+		switch arg.(type) {
+		case *ast.ExprIntLiteral, *ast.ExprId:
+			if !assigned[p.Name] {
+				c.subst[p.Name] = arg
+				continue
+			}
+		}
+		temp := c.declare(p.Name)
+		pre = append(pre, &ast.StmtExpr{Expr: &ast.ExprOp{Op: "=", Args: []ast.Expr{&ast.ExprId{Name: temp}, call.Args[i]}}})
+	}
+
+	/* body */
+	ret := c.fresh()
+	c.decls = append(c.decls, &ast.Decl{VarType: &ast.TypePrimitive{Name: "long"}, Name: ret})
+	copied, _ := tailReturns([]ast.Stmt{c.copyStmt(callee.Body)})
+	var inlined ast.Stmt = &ast.StmtCompound{Decls: nil, Stmts: copied}
+	if len(copied) == 1 {
+		inlined = copied[0]
+	}
+	inlined = returnsToAssign(inlined, ret)
+	foldStmt(inlined)
+	pre = append(pre, inlined)
+
+	body.Decls = append(body.Decls, c.decls...)
+	*site = replaceCall(*site, call, &ast.ExprId{Name: ret})
+	fun.Body = replaceStmt(fun.Body, stmt, func() []ast.Stmt {
+		return append(pre, stmt)
+	})
+}
+
+/*
+inline one call in fun.
+returns false if there is no call that can be inlined
+*/
+func (st *inlineState) inlineOne(fun *ast.DefFun, used map[string]bool) bool {
+	var stmts []ast.Stmt
+	allStmts(fun.Body, &stmts)
+	for _, stmt := range stmts {
+		site := inlineSiteExpr(stmt)
+		if site == nil {
+			continue
+		}
+		call, callee := st.callToInline(fun, *site)
+		if call == nil {
+			continue
+		}
+		inlineCall(fun, stmt, site, call, st.am.CFG(callee), used)
+		if st.dump != nil {
+			fmt.Fprintf(st.dump, "inline: %s: call to %s inlined (size %d)\n",
+				fun.Name, callee.Name, stmtSize(callee.Body))
+		}
+		return true
+	}
+	return false
+}
+
+func (st *inlineState) inlineFunction(fun *ast.DefFun) {
+	if _, ok := fun.Body.(*ast.StmtCompound); !ok {
+		fun.Body = &ast.StmtCompound{Decls: nil, Stmts: []ast.Stmt{fun.Body}}
+	}
+	used := make(map[string]bool)
+	stmtVars(fun.Body, used)
+	for _, p := range fun.Params {
+		used[p.Name] = true
+	}
+	for st.inlineOne(fun, used) {
+	}
+	st.am.invalidate(fun)
+}
+
+/*
+inline calls to small functions in every function of a program
+(in place). functions are visited callees first. calls to functions
+with more than limit nodes are left alone (limit 0: none is inlined).
+if dump is not nil, the calls inlined are reported to it
+*/
+func inline_program(program *ast.Program, am *Analyses, limit int, dump io.Writer) {
+	st := &inlineState{
+		am:        am,
+		funs:      make(map[string]*ast.DefFun),
+		recursive: make(map[string]bool),
+		pure:      make(map[string]bool),
+		limit:     limit,
+		dump:      dump,
+	}
+	var funs []*ast.DefFun
+	calls := make(map[string]map[string]bool)
+	for _, def := range program.Defs {
+		if d, ok := def.(*ast.DefFun); ok {
+			st.funs[d.Name] = d
+			funs = append(funs, d)
+			calls[d.Name] = make(map[string]bool)
+			stmtCalls(d.Body, calls[d.Name])
+		}
+	}
+
+	/* recursive functions: those that can reach themselves */
+	for _, f := range funs {
+		seen := make(map[string]bool)
+		work := []string{f.Name}
+		for len(work) > 0 {
+			x := work[len(work)-1]
+			work = work[:len(work)-1]
+			for y := range calls[x] {
+				if y == f.Name {
+					st.recursive[f.Name] = true
+				}
+				if !seen[y] {
+					seen[y] = true
+					work = append(work, y)
+				}
+			}
+		}
+	}
+
+	/* functions without side effects: those calling only such functions */
+	for _, f := range funs {
+		st.pure[f.Name] = true
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, f := range funs {
+			for y := range calls[f.Name] {
+				if st.pure[f.Name] && !st.pure[y] {
+					st.pure[f.Name] = false
+					changed = true
+				}
+			}
+		}
+	}
+
+	if limit <= 0 {
+		return
+	}
+	/* callees first */
+	done := make(map[string]bool)
+	var visit func(f *ast.DefFun)
+	visit = func(f *ast.DefFun) {
+		if done[f.Name] {
+			return
+		}
+		done[f.Name] = true
+		for _, g := range funs {
+			if calls[f.Name][g.Name] {
+				visit(g)
+			}
+		}
+		st.inlineFunction(f)
+	}
+	for _, f := range funs {
+		visit(f)
+	}
+}
+
+/* --- for minc reduce --- */
+
+/*
+a call that can be inlined, for programs that shrink programs rather
+than optimize them (minc reduce): one the inline pass could inline,
+whatever the size of the callee, of a function that calls no other,
+so that inlining it leaves a call fewer
+*/
+type InlineSite struct {
+	Caller, Callee *ast.DefFun
+	stmt           ast.Stmt
+	site           *ast.Expr
+	call           *ast.ExprCall
+}
+
+/* the calls of program that can be inlined, by function and statement */
+func InlineSites(program *ast.Program) []*InlineSite {
+	funs := make(map[string]*ast.DefFun)
+	forFunctions(program, func(fun *ast.DefFun) { funs[fun.Name] = fun })
+	var sites []*InlineSite
+	forFunctions(program, func(fun *ast.DefFun) {
+		var stmts []ast.Stmt
+		allStmts(fun.Body, &stmts)
+		for _, stmt := range stmts {
+			site := inlineSiteExpr(stmt)
+			if site == nil {
+				continue
+			}
+			var calls []*ast.ExprCall
+			unconditionalCalls(*site, nil, make(map[ast.Expr]ast.Expr), &calls)
+			for _, call := range calls {
+				id, ok := call.Fun.(*ast.ExprId)
+				if !ok || funs[id.Name] == nil {
+					continue
+				}
+				callee := funs[id.Name]
+				callees := make(map[string]bool)
+				stmtCalls(callee.Body, callees)
+				if len(callee.Params) != len(call.Args) || len(callees) > 0 {
+					continue
+				}
+				if _, ok := tailReturns([]ast.Stmt{callee.Body}); !ok {
+					continue
+				}
+				sites = append(sites, &InlineSite{fun, callee, stmt, site, call})
+			}
+		}
+	})
+	return sites
+}
+
+/* replace the call by a copy of the callee's body (in place) */
+func (s *InlineSite) Inline() {
+	if _, ok := s.Caller.Body.(*ast.StmtCompound); !ok {
+		s.Caller.Body = &ast.StmtCompound{Decls: nil, Stmts: []ast.Stmt{s.Caller.Body}}
+	}
+	used := make(map[string]bool)
+	stmtVars(s.Caller.Body, used)
+	for _, p := range s.Caller.Params {
+		used[p.Name] = true
+	}
+	inlineCall(s.Caller, s.stmt, s.site, s.call, buildCFG(s.Callee), used)
+}
@@ -0,0 +1,42 @@
+package codegen
+
+import "testing"
+
+func TestInline(t *testing.T) {
+	const inc = `long inc(long x) { return x + 1; } `
+	tests := []struct {
+		src   string
+		limit int
+		want  string
+	}{
+		/* the argument is computed once into a parameter variable */
+		{inc + `long f(long y) { long r; r = inc(y * 2); return r; }`, 40,
+			inc + `long f(long y) { long r; long _inl0; long _inl1; _inl0 = y * 2; { _inl1 = _inl0 + 1; } r = _inl1; return r; }`},
+		/* a variable argument stands for the parameter */
+		{`long sq(long x) { return x * x; } long f(long y) { long r; r = sq(y); return r; }`, 40,
+			`long sq(long x) { return x * x; } long f(long y) { long r; long _inl0; { _inl0 = y * y; } r = _inl0; return r; }`},
+		/* the limit: inc has 5 nodes */
+		{inc + `long f(long y) { long r; r = inc(y); return r; }`, 5,
+			inc + `long f(long y) { long r; long _inl0; { _inl0 = y + 1; } r = _inl0; return r; }`},
+		{inc + `long f(long y) { long r; r = inc(y); return r; }`, 4,
+			inc + `long f(long y) { long r; r = inc(y); return r; }`},
+		{inc + `long f(long y) { long r; r = inc(y); return r; }`, 0,
+			inc + `long f(long y) { long r; r = inc(y); return r; }`},
+		/* recursive functions, directly or not, are not inlined */
+		{`long fact(long n) { if (n < 2) return 1; return n * fact(n - 1); } long f(long y) { return fact(y); }`, 40,
+			`long fact(long n) { if (n < 2) return 1; return n * fact(n - 1); } long f(long y) { return fact(y); }`},
+		{`long even(long n) { if (n == 0) return 1; return odd(n - 1); } long odd(long n) { if (n == 0) return 0; return even(n - 1); } long f(long y) { return odd(y); }`, 40,
+			`long even(long n) { if (n == 0) return 1; return odd(n - 1); } long odd(long n) { if (n == 0) return 0; return even(n - 1); } long f(long y) { return odd(y); }`},
+		/* but calls from and to them are */
+		{inc + `long fact(long n) { if (n < 2) return 1; return n * fact(inc(n) - 2); } long h(long n) { return fact(n) + 1; } long f(long y) { long r; r = h(y); return r; }`, 40,
+			inc + `long fact(long n) { long _inl0; if (n < 2) return 1; { _inl0 = n + 1; } return n * fact(_inl0 - 2); } long h(long n) { return fact(n) + 1; } long f(long y) { long r; long _inl0; { _inl0 = fact(y) + 1; } r = _inl0; return r; }`},
+		/* calls that may not be evaluated, or are evaluated repeatedly, stay */
+		{inc + `long f(long y) { long r; r = 0; if (y || inc(y)) r = 1; while (inc(y) < 3) y = y + 1; return r; }`, 40,
+			inc + `long f(long y) { long r; r = 0; if (y || inc(y)) r = 1; while (inc(y) < 3) y = y + 1; return r; }`},
+	}
+	for _, tt := range tests {
+		program := mustParse(t, tt.src)
+		inline_program(program, newAnalyses(), tt.limit, nil)
+		checkProgram(t, tt.src, program, tt.want)
+	}
+}
@@ -0,0 +1,288 @@
+package codegen
+
+/* minc_ld
+
+   the linker: minC objects (minc -c) to an AArch64 Linux
+   executable, without gcc or ld:
+
+     ./minc -o prog main.o f.o
+
+   the .text sections of the objects are put one after the other,
+   after a startup routine that calls main and exits with the value
+   it returns (main must be defined, minC having no libc):
+
+     _start:  bl main
+              mov x8, #93       (the exit system call)
+              svc #0
+
+   the symbols the objects define are given addresses, and the
+   relocations that refer to them (minc_as) are patched in the code.
+
+   the executable is a single segment, readable and executable, at
+   0x400000: the ELF header, the program headers and the code. the
+   symbol table follows, outside the segment, for the debuggers.
+*/
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+)
+
+/* the startup code, assembled like the output of the compiler */
+const ldStart = `
+.text
+.globl _start
+.type _start, @function
+_start:
+  bl main
+  mov x8, #93
+  svc #0
+`
+
+const (
+	LdBase     = 0x400000 // the address the executable is loaded at
+	ldPageSize = 0x10000  // the segment is aligned for pages up to 64 KiB
+)
+
+/* the sections of an executable */
+const (
+	exeSecSymtab   = 2 // after elfSecText
+	exeSecStrtab   = 3
+	exeNumSections = 5 // with .shstrtab
+)
+
+var exeSectionNames = [exeNumSections]string{
+	"", ".text", ".symtab", ".strtab", ".shstrtab",
+}
+
+/* an object being linked, and where its code goes */
+type linkInput struct {
+	file string
+	obj  *Object
+	addr uint64
+}
+
+/* read an ELF object written by minc -c (minc_elf) */
+func ReadElfObject(file string) (*Object, error) {
+	f, err := elf.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if f.Class != elf.ELFCLASS64 || f.Machine != elf.EM_AARCH64 || f.Type != elf.ET_REL {
+		return nil, fmt.Errorf("%s: not an AArch64 ELF object", file)
+	}
+	obj := &Object{}
+	textIndex := -1
+	for i, sec := range f.Sections {
+		switch {
+		case sec.Name == ".text":
+			textIndex = i
+			if obj.text, err = sec.Data(); err != nil {
+				return nil, fmt.Errorf("%s: %v", file, err)
+			}
+		case sec.Flags&elf.SHF_ALLOC != 0 && sec.Size > 0:
+			return nil, fmt.Errorf("%s: section %s not supported (only .text)", file, sec.Name)
+		}
+	}
+
+	syms, err := f.Symbols()
+	if err != nil && err != elf.ErrNoSymbols {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	/* the symbol of each index of the symbol table (0 is the null symbol) */
+	byIndex := make([]*ObjSymbol, len(syms)+1)
+	for i, s := range syms {
+		sym := &ObjSymbol{
+			name:   s.Name,
+			value:  s.Value,
+			size:   s.Size,
+			global: elf.ST_BIND(s.Info) != elf.STB_LOCAL,
+			isFunc: elf.ST_TYPE(s.Info) == elf.STT_FUNC,
+		}
+		switch {
+		case elf.ST_TYPE(s.Info) == elf.STT_SECTION && int(s.Section) == textIndex:
+			/* relocations may refer to .text itself */
+			sym.name = ".text"
+			sym.defined = true
+		case elf.ST_TYPE(s.Info) == elf.STT_SECTION || elf.ST_TYPE(s.Info) == elf.STT_FILE:
+			continue
+		case s.Name == "$x" || s.Name == "$d":
+			continue // mapping symbols
+		case s.Section == elf.SHN_UNDEF:
+		case int(s.Section) == textIndex:
+			sym.defined = true
+		default:
+			return nil, fmt.Errorf("%s: symbol %s not in .text", file, s.Name)
+		}
+		byIndex[i+1] = sym
+		obj.symbols = append(obj.symbols, sym)
+	}
+
+	for _, sec := range f.Sections {
+		if sec.Type != elf.SHT_RELA || int(sec.Info) != textIndex {
+			continue
+		}
+		data, err := sec.Data()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		for off := 0; off+24 <= len(data); off += 24 {
+			info := binary.LittleEndian.Uint64(data[off+8:])
+			i := elf.R_SYM64(info)
+			if int(i) >= len(byIndex) || byIndex[i] == nil {
+				return nil, fmt.Errorf("%s: relocation against a bad symbol", file)
+			}
+			obj.relocs = append(obj.relocs, &Reloc{
+				offset: binary.LittleEndian.Uint64(data[off:]),
+				sym:    byIndex[i].name,
+				typ:    elf.R_AARCH64(elf.R_TYPE64(info)),
+				addend: int64(binary.LittleEndian.Uint64(data[off+16:])),
+			})
+		}
+	}
+	return obj, nil
+}
+
+/* the address of a symbol an object refers to: its own local one, or a global one */
+func (in *linkInput) resolve(name string, globals map[string]uint64) (uint64, bool) {
+	for _, sym := range in.obj.symbols {
+		if sym.name == name && sym.defined && !sym.global {
+			return in.addr + sym.value, true
+		}
+	}
+	addr, ok := globals[name]
+	return addr, ok
+}
+
+/* patch the instruction at P (word) for a reference to the address S + A */
+func ldPatch(word uint32, typ elf.R_AARCH64, P, SA uint64) (uint32, error) {
+	switch typ {
+	case elf.R_AARCH64_CALL26, elf.R_AARCH64_JUMP26:
+		d := int64(SA - P)
+		if d < -(1<<27) || d >= 1<<27 {
+			return 0, fmt.Errorf("branch out of range")
+		}
+		return word&^0x3ffffff | uint32(d>>2)&0x3ffffff, nil
+	case elf.R_AARCH64_ADR_PREL_PG_HI21:
+		pages := int64(SA&^0xfff-P&^0xfff) >> 12
+		if pages < -(1<<20) || pages >= 1<<20 {
+			return 0, fmt.Errorf("address out of range")
+		}
+		immlo, immhi := uint32(pages)&3, uint32(pages>>2)&0x7ffff
+		return word&^(3<<29|0x7ffff<<5) | immlo<<29 | immhi<<5, nil
+	case elf.R_AARCH64_ADD_ABS_LO12_NC:
+		return word&^(0xfff<<10) | uint32(SA&0xfff)<<10, nil
+	}
+	return 0, fmt.Errorf("relocation %v not supported", typ)
+}
+
+/* linked code: where it goes and where its symbols are */
+type Image struct {
+	Text    []byte
+	Addr    uint64            // of text[0]
+	Globals map[string]uint64 // the address of each global symbol
+	symbols []*ObjSymbol      // the defined ones, relative to text[0]
+}
+
+/* link objects (read from files) into code at addr */
+func LinkImage(files []string, objs []*Object, addr uint64) (*Image, error) {
+	img := &Image{Addr: addr, Globals: make(map[string]uint64)}
+	var inputs []*linkInput
+	for i, obj := range objs {
+		inputs = append(inputs, &linkInput{file: files[i], obj: obj, addr: addr + uint64(len(img.Text))})
+		img.Text = append(img.Text, obj.text...)
+	}
+
+	definedIn := make(map[string]string)
+	for _, in := range inputs {
+		for _, sym := range in.obj.symbols {
+			if !sym.defined || !sym.global {
+				continue
+			}
+			if other, dup := definedIn[sym.name]; dup {
+				return nil, fmt.Errorf("multiple definition of '%s' (in %s and %s)", sym.name, other, in.file)
+			}
+			img.Globals[sym.name] = in.addr + sym.value
+			definedIn[sym.name] = in.file
+		}
+	}
+
+	for _, in := range inputs {
+		for _, r := range in.obj.relocs {
+			S, ok := in.resolve(r.sym, img.Globals)
+			if !ok {
+				return nil, fmt.Errorf("%s: undefined reference to '%s'", in.file, r.sym)
+			}
+			if r.offset+4 > uint64(len(in.obj.text)) {
+				return nil, fmt.Errorf("%s: relocation outside .text", in.file)
+			}
+			P := in.addr + r.offset
+			off := P - addr
+			word, err := ldPatch(binary.LittleEndian.Uint32(img.Text[off:]), r.typ, P, S+uint64(r.addend))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v for '%s'", in.file, err, r.sym)
+			}
+			binary.LittleEndian.PutUint32(img.Text[off:], word)
+		}
+	}
+
+	for _, in := range inputs {
+		for _, sym := range in.obj.symbols {
+			if sym.defined && sym.name != ".text" {
+				s := *sym
+				s.value = in.addr + sym.value - addr
+				img.symbols = append(img.symbols, &s)
+			}
+		}
+	}
+	return img, nil
+}
+
+/* link objects (read from files) into an executable, after _start */
+func LinkObjects(files []string, objs []*Object) ([]byte, error) {
+	start, err := Assemble(ParseAsm(ldStart))
+	if err != nil {
+		return nil, err
+	}
+	/* the code after the ELF header and two program headers */
+	textOff := uint64(64 + 2*56)
+	img, err := LinkImage(append([]string{"_start"}, files...), append([]*Object{start}, objs...), LdBase+textOff)
+	if err != nil {
+		return nil, err
+	}
+	return elf_executable(img.Text, textOff, img.Globals["_start"], img.symbols), nil
+}
+
+/* an executable of the code text, loaded at LdBase + textOff */
+func elf_executable(text []byte, textOff uint64, entry uint64, symbols []*ObjSymbol) []byte {
+	symtab, strtab, firstGlobal, _ := elfSymtab(symbols, LdBase+textOff)
+	f := newElfFile(int(textOff), exeSectionNames[:])
+	h := f.section(elfSecText, elf.SHT_PROGBITS, elf.SHF_ALLOC|elf.SHF_EXECINSTR, text, 4)
+	h.Addr = LdBase + textOff
+	h = f.section(exeSecSymtab, elf.SHT_SYMTAB, 0, symtab, 8)
+	h.Link, h.Info, h.Entsize = exeSecStrtab, uint32(firstGlobal), 24
+	f.section(exeSecStrtab, elf.SHT_STRTAB, 0, strtab, 1)
+
+	size := textOff + uint64(len(text))
+	progs := []elf.Prog64{
+		{
+			Type:   uint32(elf.PT_LOAD),
+			Flags:  uint32(elf.PF_R | elf.PF_X),
+			Off:    0,
+			Vaddr:  LdBase,
+			Paddr:  LdBase,
+			Filesz: size,
+			Memsz:  size,
+			Align:  ldPageSize,
+		},
+		{
+			/* the stack need not be executable */
+			Type:  uint32(elf.PT_GNU_STACK),
+			Flags: uint32(elf.PF_R | elf.PF_W),
+			Align: 16,
+		},
+	}
+	return f.finish(elf.ET_EXEC, entry, progs)
+}
@@ -0,0 +1,464 @@
+package codegen
+
+/* minc_licm
+
+   loop optimizations on the natural loops of minc_cfg.
+
+   - loop-invariant code motion: a pure expression inside a loop
+     whose variables are not assigned anywhere in the loop computes
+     the same value in every iteration. it is computed once in a
+     preheader, statements inserted just before the loop (after the
+     initialization of a for), and the loop reads the result:
+
+       while (i < n) { s = s + n * m; i = i + 1; }
+         ->
+       _licm0 = n * m;
+       while (i < n) { s = s + _licm0; i = i + 1; }
+
+     the preheader runs even when the loop body does not, so only
+     expressions that cannot trap are moved (no division or modulo
+     by anything other than a constant).
+
+   - strength reduction: when the only assignments to i in a loop
+     are of the form i = i + c (c a constant), i is an induction
+     variable and i * k (or i << k) is one as well. it is kept in a
+     new variable, updated by an addition next to every update of i:
+
+       for (i = 0; i < n; i = i + 1) s = s + i * 24;
+         ->
+       i = 0; _iv0 = i * 24;
+       for (; i < n; { i = i + 1; _iv0 = _iv0 + 24; }) s = s + _iv0;
+
+   loops are processed from the outermost, so an expression is moved
+   as far out as it can go.
+*/
+
+import (
+	"fmt"
+	"io"
+
+	"minc/ast"
+)
+
+/* can evaluating this (pure) expression fail at run time? */
+func exprMayTrap(expr ast.Expr) bool {
+	switch e := expr.(type) {
+	case *ast.ExprParen:
+		return exprMayTrap(e.SubExpr)
+	case *ast.ExprOp:
+		if e.Op == "/" || e.Op == "%" {
+			if v, ok := literalValue(e.Args[1]); !ok || v == 0 || v == -1 {
+				return true
+			}
+		}
+		for _, arg := range e.Args {
+			if exprMayTrap(arg) {
+				return true
+			}
+		}
+	case *ast.ExprCall:
+		return true
+	}
+	return false
+}
+
+/* variables that may be assigned in a loop */
+func loopAssigned(cfg *CFG, loop *NaturalLoop) map[string]bool {
+	vars := make(map[string]bool)
+	for _, n := range loop.nodes(cfg) {
+		for v := range n.assigned() {
+			vars[v] = true
+		}
+	}
+	return vars
+}
+
+/* is expr pure, safe to evaluate early and independent of the loop? */
+func loopInvariant(expr ast.Expr, assigned map[string]bool) bool {
+	if !exprIsPure(expr) || exprMayTrap(expr) {
+		return false
+	}
+	uses := make(map[string]bool)
+	exprUses(expr, uses)
+	for v := range uses {
+		if assigned[v] {
+			return false
+		}
+	}
+	return true
+}
+
+/* the largest invariant operations in expr */
+func collectInvariants(expr ast.Expr, assigned map[string]bool, found *[]*ast.ExprOp) {
+	switch e := expr.(type) {
+	case *ast.ExprParen:
+		collectInvariants(e.SubExpr, assigned, found)
+	case *ast.ExprCall:
+		for _, arg := range e.Args {
+			collectInvariants(arg, assigned, found)
+		}
+	case *ast.ExprOp:
+		if loopInvariant(e, assigned) {
+			*found = append(*found, e)
+			return
+		}
+		for i, arg := range e.Args {
+			if e.Op == "=" && i == 0 {
+				continue
+			}
+			collectInvariants(arg, assigned, found)
+		}
+	}
+}
+
+/* replace the expressions in repl (compared by pointer) */
+func replaceExprs(expr ast.Expr, repl map[*ast.ExprOp]ast.Expr) ast.Expr {
+	switch e := expr.(type) {
+	case *ast.ExprParen:
+		e.SubExpr = replaceExprs(e.SubExpr, repl)
+	case *ast.ExprCall:
+		for i, arg := range e.Args {
+			e.Args[i] = replaceExprs(arg, repl)
+		}
+	case *ast.ExprOp:
+		if r, ok := repl[e]; ok {
+			return r
+		}
+		for i, arg := range e.Args {
+			e.Args[i] = replaceExprs(arg, repl)
+		}
+	}
+	return expr
+}
+
+/*
+rewrite stmt, replacing statement target with the statements
+produced by f (in a compound statement when not inside one already)
+*/
+func replaceStmt(stmt ast.Stmt, target ast.Stmt, f func() []ast.Stmt) ast.Stmt {
+	if stmt == target {
+		return &ast.StmtCompound{Decls: nil, Stmts: f()}
+	}
+	switch s := stmt.(type) {
+	case *ast.StmtCompound:
+		stmts := []ast.Stmt{}
+		for _, sub := range s.Stmts {
+			if sub == target {
+				stmts = append(stmts, f()...)
+			} else {
+				stmts = append(stmts, replaceStmt(sub, target, f))
+			}
+		}
+		s.Stmts = stmts
+	case *ast.StmtIf:
+		s.ThenStmt = replaceStmt(s.ThenStmt, target, f)
+		if s.ElseStmt != nil {
+			s.ElseStmt = replaceStmt(s.ElseStmt, target, f)
+		}
+	case *ast.StmtWhile:
+		s.Body = replaceStmt(s.Body, target, f)
+	case *ast.StmtFor:
+		s.Init = replaceStmt(s.Init, target, f)
+		s.Post = replaceStmt(s.Post, target, f)
+		s.Body = replaceStmt(s.Body, target, f)
+	}
+	return stmt
+}
+
+/*
+the statements replacing a loop whose preheader is pre.
+the initialization of a for runs before its preheader
+*/
+func withPreheader(loop ast.Stmt, pre []ast.Stmt) []ast.Stmt {
+	if s, ok := loop.(*ast.StmtFor); ok {
+		stmts := append([]ast.Stmt{s.Init}, pre...)
+		s.Init = &ast.StmtEmpty{}
+		return append(stmts, s)
+	}
+	return append(pre, loop)
+}
+
+type licmState struct {
+	fun  *ast.DefFun
+	used map[string]bool // variable names taken
+	dump io.Writer
+}
+
+/* a new local variable named prefix0, prefix1, ... */
+func (st *licmState) newTemp(prefix string) string {
+	k := 0
+	for st.used[fmt.Sprintf("%s%d", prefix, k)] {
+		k++
+	}
+	name := fmt.Sprintf("%s%d", prefix, k)
+	st.used[name] = true
+	body := st.fun.Body.(*ast.StmtCompound)
+	body.Decls = append(body.Decls, &ast.Decl{VarType: &ast.TypePrimitive{Name: "long"}, Name: name})
+	return name
+}
+
+/*
+move the invariant computations of a loop to its preheader.
+returns false if there is none
+*/
+func (st *licmState) hoistInvariants(cfg *CFG, loop *NaturalLoop) bool {
+	assigned := loopAssigned(cfg, loop)
+	var found []*ast.ExprOp
+	for _, n := range loop.nodes(cfg) {
+		if e := n.expr(); e != nil {
+			collectInvariants(e, assigned, &found)
+		}
+	}
+	if len(found) == 0 {
+		return false
+	}
+	/* one variable for each distinct expression */
+	repl := make(map[*ast.ExprOp]ast.Expr)
+	var temps []string
+	var exprs []*ast.ExprOp
+	for _, e := range found {
+		temp := ""
+		for i, other := range exprs {
+			if exprEqual(e, other) {
+				temp = temps[i]
+			}
+		}
+		if temp == "" {
+			temp = st.newTemp("_licm")
+			temps = append(temps, temp)
+			exprs = append(exprs, e)
+			if st.dump != nil {
+				fmt.Fprintf(st.dump, "licm: %s: %s hoisted out of the loop into %s\n",
+					st.fun.Name, e.ExprString(), temp)
+			}
+		}
+		repl[e] = &ast.ExprId{Name: temp}
+	}
+	for _, n := range loop.nodes(cfg) {
+		if e := n.expr(); e != nil {
+			n.setExpr(replaceExprs(e, repl))
+		}
+	}
+	pre := make([]ast.Stmt, len(exprs))
+	for i, e := range exprs {
+		pre[i] = &ast.StmtExpr{Expr: &ast.ExprOp{Op: "=", Args: []ast.Expr{&ast.ExprId{Name: temps[i]}, e}}}
+	}
+	st.fun.Body = replaceStmt(st.fun.Body, loop.stmt, func() []ast.Stmt {
+		return withPreheader(loop.stmt, pre)
+	})
+	return true
+}
+
+/* --- strength reduction --- */
+
+/*
+if stmt is i = i + c, i = c + i or i = i - c, return i and the
+amount (c or -c) i is incremented by
+*/
+func inductionStep(stmt ast.Stmt) (string, int64, bool) {
+	s, ok := stmt.(*ast.StmtExpr)
+	if !ok {
+		return "", 0, false
+	}
+	a, ok := ast.StripParen(s.Expr).(*ast.ExprOp)
+	if !ok || a.Op != "=" {
+		return "", 0, false
+	}
+	id, ok := ast.StripParen(a.Args[0]).(*ast.ExprId)
+	if !ok {
+		return "", 0, false
+	}
+	rhs, ok := ast.StripParen(a.Args[1]).(*ast.ExprOp)
+	if !ok || len(rhs.Args) != 2 || (rhs.Op != "+" && rhs.Op != "-") {
+		return "", 0, false
+	}
+	isVar := func(e ast.Expr) bool {
+		x, ok := ast.StripParen(e).(*ast.ExprId)
+		return ok && x.Name == id.Name
+	}
+	if c, ok := literalValue(rhs.Args[1]); ok && isVar(rhs.Args[0]) {
+		if rhs.Op == "-" {
+			c = -c
+		}
+		return id.Name, c, true
+	}
+	if c, ok := literalValue(rhs.Args[0]); ok && isVar(rhs.Args[1]) && rhs.Op == "+" {
+		return id.Name, c, true
+	}
+	return "", 0, false
+}
+
+/*
+basic induction variables of a loop: variable -> the statements
+updating it
+*/
+func inductionVars(cfg *CFG, loop *NaturalLoop) map[string][]ast.Stmt {
+	updates := make(map[string][]ast.Stmt)
+	other := make(map[string]bool)
+	for _, n := range loop.nodes(cfg) {
+		if v, _, ok := inductionStep(n.stmt); ok {
+			updates[v] = append(updates[v], n.stmt)
+			continue
+		}
+		for v := range n.assigned() {
+			other[v] = true
+		}
+	}
+	for v := range other {
+		delete(updates, v)
+	}
+	return updates
+}
+
+/* if expr is i * k, k * i or i << k for an induction variable i, return i and k */
+func derivedInduction(expr *ast.ExprOp, ivs map[string][]ast.Stmt) (string, int64, bool) {
+	if len(expr.Args) != 2 {
+		return "", 0, false
+	}
+	l, r := ast.StripParen(expr.Args[0]), ast.StripParen(expr.Args[1])
+	switch expr.Op {
+	case "*":
+		if _, ok := literalValue(l); ok {
+			l, r = r, l
+		}
+		id, ok := l.(*ast.ExprId)
+		k, kok := literalValue(r)
+		if ok && kok && ivs[id.Name] != nil {
+			return id.Name, k, true
+		}
+	case "<<":
+		id, ok := l.(*ast.ExprId)
+		k, kok := literalValue(r)
+		if ok && kok && k >= 0 && k < 64 && ivs[id.Name] != nil {
+			return id.Name, int64(1) << uint(k), true
+		}
+	}
+	return "", 0, false
+}
+
+func collectDerived(expr ast.Expr, ivs map[string][]ast.Stmt, found *[]*ast.ExprOp) {
+	switch e := expr.(type) {
+	case *ast.ExprParen:
+		collectDerived(e.SubExpr, ivs, found)
+	case *ast.ExprCall:
+		for _, arg := range e.Args {
+			collectDerived(arg, ivs, found)
+		}
+	case *ast.ExprOp:
+		if _, _, ok := derivedInduction(e, ivs); ok {
+			*found = append(*found, e)
+			return
+		}
+		for _, arg := range e.Args {
+			collectDerived(arg, ivs, found)
+		}
+	}
+}
+
+/*
+replace i * k in a loop by a variable updated along with i.
+returns false if there is nothing to replace
+*/
+func (st *licmState) reduceStrength(cfg *CFG, loop *NaturalLoop) bool {
+	ivs := inductionVars(cfg, loop)
+	if len(ivs) == 0 {
+		return false
+	}
+	var found []*ast.ExprOp
+	for _, n := range loop.nodes(cfg) {
+		if _, _, ok := inductionStep(n.stmt); ok {
+			continue
+		}
+		if e := n.expr(); e != nil {
+			collectDerived(e, ivs, &found)
+		}
+	}
+	if len(found) == 0 {
+		return false
+	}
+	type derived struct {
+		iv   string
+		k    int64
+		temp string
+	}
+	var ds []*derived
+	repl := make(map[*ast.ExprOp]ast.Expr)
+	for _, e := range found {
+		iv, k, _ := derivedInduction(e, ivs)
+		var d *derived
+		for _, other := range ds {
+			if other.iv == iv && other.k == k {
+				d = other
+			}
+		}
+		if d == nil {
+			d = &derived{iv, k, st.newTemp("_iv")}
+			ds = append(ds, d)
+			if st.dump != nil {
+				fmt.Fprintf(st.dump, "licm: %s: %s strength-reduced to induction variable %s\n",
+					st.fun.Name, e.ExprString(), d.temp)
+			}
+		}
+		repl[e] = &ast.ExprId{Name: d.temp}
+	}
+	for _, n := range loop.nodes(cfg) {
+		if e := n.expr(); e != nil {
+			n.setExpr(replaceExprs(e, repl))
+		}
+	}
+	/* t = t + c * k after each update i = i + c */
+	for _, d := range ds {
+		for _, upd := range ivs[d.iv] {
+			_, c, _ := inductionStep(upd)
+			d, upd := d, upd
+			st.fun.Body = replaceStmt(st.fun.Body, upd, func() []ast.Stmt {
+				step := &ast.ExprOp{Op: "+", Args: []ast.Expr{&ast.ExprId{Name: d.temp}, &ast.ExprIntLiteral{Val: c * d.k}}}
+				return []ast.Stmt{upd, &ast.StmtExpr{Expr: &ast.ExprOp{Op: "=", Args: []ast.Expr{&ast.ExprId{Name: d.temp}, step}}}}
+			})
+		}
+	}
+	/* t = i * k in the preheader */
+	pre := make([]ast.Stmt, len(ds))
+	for i, d := range ds {
+		init := &ast.ExprOp{Op: "*", Args: []ast.Expr{&ast.ExprId{Name: d.iv}, &ast.ExprIntLiteral{Val: d.k}}}
+		pre[i] = &ast.StmtExpr{Expr: &ast.ExprOp{Op: "=", Args: []ast.Expr{&ast.ExprId{Name: d.temp}, init}}}
+	}
+	st.fun.Body = replaceStmt(st.fun.Body, loop.stmt, func() []ast.Stmt {
+		return withPreheader(loop.stmt, pre)
+	})
+	return true
+}
+
+func licmFunction(fun *ast.DefFun, am *Analyses, dump io.Writer) {
+	if _, ok := fun.Body.(*ast.StmtCompound); !ok {
+		fun.Body = &ast.StmtCompound{Decls: nil, Stmts: []ast.Stmt{fun.Body}}
+		am.invalidate(fun)
+	}
+	st := &licmState{fun: fun, used: make(map[string]bool), dump: dump}
+	stmtVars(fun.Body, st.used)
+	for _, p := range fun.Params {
+		st.used[p.Name] = true
+	}
+	for _, transform := range []func(*CFG, *NaturalLoop) bool{st.hoistInvariants, st.reduceStrength} {
+		for changed := true; changed; {
+			changed = false
+			cfg := am.CFG(fun)
+			for _, loop := range am.Loops(fun) {
+				if loop.stmt != nil && transform(cfg, loop) {
+					/* the AST changed: start over with a fresh graph */
+					am.invalidate(fun)
+					changed = true
+					break
+				}
+			}
+		}
+	}
+}
+
+/*
+move loop-invariant computations out of the loops of a program and
+reduce the strength of induction variable multiplications (in place)
+*/
+func licm_program(program *ast.Program, am *Analyses, dump io.Writer) {
+	forFunctions(program, func(fun *ast.DefFun) { licmFunction(fun, am, dump) })
+}
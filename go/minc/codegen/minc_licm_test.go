@@ -0,0 +1,33 @@
+package codegen
+
+import "testing"
+
+func TestLICM(t *testing.T) {
+	tests := []struct{ src, want string }{
+		/* an invariant moves to the preheader */
+		{`long f(long n, long m) { long i; long s; i = 0; s = 0; while (i < n) { s = s + n * m; i = i + 1; } return s; }`,
+			`long f(long n, long m) { long i; long s; long _licm0; i = 0; s = 0; _licm0 = n * m; while (i < n) { s = s + _licm0; i = i + 1; } return s; }`},
+		/* out of both loops when invariant in both, after the initialization of a for */
+		{`long f(long n, long m) { long i; long j; long s; s = 0; for (i = 0; i < n; i = i + 1) for (j = 0; j < n; j = j + 1) s = s + n * m; return s; }`,
+			`long f(long n, long m) { long i; long j; long s; long _licm0; s = 0; i = 0; _licm0 = n * m; for (; i < n; i = i + 1) for (j = 0; j < n; j = j + 1) s = s + _licm0; return s; }`},
+		/* a division by a constant cannot trap; one by a variable stays */
+		{`long f(long n, long m) { long i; long s; i = 0; s = 0; while (i < n) { s = s + n / 4; i = i + 1; } return s; }`,
+			`long f(long n, long m) { long i; long s; long _licm0; i = 0; s = 0; _licm0 = n / 4; while (i < n) { s = s + _licm0; i = i + 1; } return s; }`},
+		{`long f(long n, long m) { long i; long s; i = 0; s = 0; while (i < n) { s = s + n / m; i = i + 1; } return s; }`,
+			`long f(long n, long m) { long i; long s; i = 0; s = 0; while (i < n) { s = s + n / m; i = i + 1; } return s; }`},
+		/* a variable assigned in the loop is not invariant */
+		{`long f(long n, long m) { long i; long s; i = 0; s = 0; while (i < n) { m = m + 1; s = s + n * m; i = i + 1; } return s; }`,
+			`long f(long n, long m) { long i; long s; i = 0; s = 0; while (i < n) { m = m + 1; s = s + n * m; i = i + 1; } return s; }`},
+		/* i * 24 of an induction variable i becomes an addition */
+		{`long f(long n) { long i; long s; i = 0; s = 0; while (i < n) { s = s + i * 24; i = i + 1; } return s; }`,
+			`long f(long n) { long i; long s; long _iv0; i = 0; s = 0; _iv0 = i * 24; while (i < n) { s = s + _iv0; i = i + 1; _iv0 = _iv0 + 24; } return s; }`},
+		/* but not when i is also assigned otherwise */
+		{`long f(long n) { long i; long s; i = 0; s = 0; while (i < n) { s = s + i * 24; i = i * 2 + 1; } return s; }`,
+			`long f(long n) { long i; long s; i = 0; s = 0; while (i < n) { s = s + i * 24; i = i * 2 + 1; } return s; }`},
+	}
+	for _, tt := range tests {
+		program := mustParse(t, tt.src)
+		licm_program(program, newAnalyses(), nil)
+		checkProgram(t, tt.src, program, tt.want)
+	}
+}
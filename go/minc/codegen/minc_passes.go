@@ -0,0 +1,445 @@
+package codegen
+
+/* minc_passes
+
+   the pass manager.
+
+   every optimization is registered here as a pass with a name, the
+   lowest optimization level that runs it, and the passes it depends
+   on. passes run in the order they are registered, which must put
+   the dependencies of a pass before it.
+
+   a pass is one of
+     analysis    computes something of each function for the passes
+                 that depend on it (cfg, domtree, loops); run when
+                 one of them is about to, if it is not computed yet
+     transform   rewrites the program; what the analyses computed is
+                 forgotten after it, but for those it preserves
+     warning     reports on the program and leaves it alone
+
+   the analyses are kept in Analyses: a pass asks it for the graph,
+   dominators or loops of a function, which are computed the first
+   time and kept until the function changes. a pass that changes a
+   function in the middle of its work calls invalidate on it.
+
+     -O0             no optimization (the unreachable-code warnings
+                     are still given)
+     -O1             folding, dead code, tail calls, peephole
+     -O2 (default)   all of the passes
+     -fpass=name     run a pass whatever the level
+     -fno-pass=name  do not run it
+     --time-passes   report the time each pass took
+
+   running a pass runs its dependencies as well; disabling one that
+   a pass that runs depends on is an error.
+
+   Options are what a program is compiled with: the passes (of a
+   level and the flags), the target, and where passes write.
+*/
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"minc/ast"
+)
+
+/* what to compile with */
+type Options struct {
+	Level       int             // -O0, -O1, -O2
+	PassFlags   map[string]bool // -fpass=name (true), -fno-pass=name (false)
+	InlineLimit int             // -finline-limit=N: largest function inlined (0: none)
+	Dump        io.Writer       // -fdump: where passes report what they eliminated (nil: nowhere)
+	Warnings    io.Writer       // where passes warn (nil: nowhere)
+	Pipeline    []*Pass         // the passes to run (ResolvePasses)
+	Target      Target          // --target= (in minc_target.go)
+}
+
+/* the options of -O level and nothing else */
+func DefaultOptions(level int) (*Options, error) {
+	opts := &Options{InlineLimit: DefaultInlineLimit, Level: level, PassFlags: make(map[string]bool)}
+	t, err := LookupTarget(DefaultTarget)
+	if err != nil {
+		return nil, err
+	}
+	opts.Target = t
+	if opts.Pipeline, err = ResolvePasses(level, opts.PassFlags); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+/* does the pipeline include the pass? */
+func (opts *Options) Enabled(name string) bool {
+	for _, p := range opts.Pipeline {
+		if p.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+/* run the passes of the pipeline on a program */
+func RunPasses(program *ast.Program, opts *Options, timer *PassTimer) {
+	runPasses(program, opts, timer)
+}
+
+/* RunPasses, returning the analyses as the last pass left them */
+func runPasses(program *ast.Program, opts *Options, timer *PassTimer) *Analyses {
+	am := newAnalyses()
+	for _, p := range opts.Pipeline {
+		/* the analyses run when a pass needs them */
+		if p.kind != passAnalysis {
+			am.runPass(p, program, opts, timer)
+		}
+	}
+	return am
+}
+
+type passKind int
+
+const (
+	passAnalysis  passKind = iota // computes what other passes use (Analyses)
+	passTransform                 // rewrites the program
+	passWarning                   // reports on the program, leaves it alone
+)
+
+type Pass struct {
+	name      string
+	kind      passKind
+	level     int      // lowest -O level that runs it (analyses: when a pass needs them)
+	deps      []string // passes that must run before this one
+	preserves []string // analyses still valid after this transform
+	desc      string
+	/* the pass itself; nil if the code generator does it */
+	run func(program *ast.Program, opts *Options, am *Analyses)
+}
+
+var passRegistry []*Pass
+
+/* add a pass to the end of the pipeline */
+func registerPass(p *Pass) {
+	for _, dep := range p.deps {
+		if FindPass(dep) == nil {
+			panic(fmt.Sprintf("pass %s registered before its dependency %s", p.name, dep))
+		}
+	}
+	/* an analysis is only valid if what it is computed from is */
+	for _, name := range p.preserves {
+		a := FindPass(name)
+		if a == nil || a.kind != passAnalysis {
+			panic(fmt.Sprintf("pass %s preserves %s, which is not an analysis", p.name, name))
+		}
+		for _, dep := range a.deps {
+			if !contains(p.preserves, dep) {
+				panic(fmt.Sprintf("pass %s preserves %s but not %s", p.name, name, dep))
+			}
+		}
+	}
+	passRegistry = append(passRegistry, p)
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func FindPass(name string) *Pass {
+	for _, p := range passRegistry {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func init() {
+	registerPass(&Pass{
+		name: "cfg", kind: passAnalysis,
+		desc: "control flow graphs (minc_cfg)",
+		run: func(program *ast.Program, opts *Options, am *Analyses) {
+			forFunctions(program, func(fun *ast.DefFun) { am.CFG(fun) })
+		},
+	})
+	registerPass(&Pass{
+		name: "domtree", kind: passAnalysis, deps: []string{"cfg"},
+		desc: "immediate dominators of the blocks",
+		run: func(program *ast.Program, opts *Options, am *Analyses) {
+			forFunctions(program, func(fun *ast.DefFun) { am.Dominators(fun) })
+		},
+	})
+	registerPass(&Pass{
+		name: "loops", kind: passAnalysis, deps: []string{"domtree"},
+		desc: "natural loops",
+		run: func(program *ast.Program, opts *Options, am *Analyses) {
+			forFunctions(program, func(fun *ast.DefFun) { am.Loops(fun) })
+		},
+	})
+	registerPass(&Pass{
+		name: "fold", kind: passTransform, level: 1,
+		desc: "constant folding and algebraic simplification",
+		run:  func(program *ast.Program, opts *Options, am *Analyses) { fold_program(program) },
+	})
+	registerPass(&Pass{
+		name: "warn-unreachable", kind: passWarning, level: 0, deps: []string{"cfg"},
+		desc: "warn about statements that can never execute",
+		run: func(program *ast.Program, opts *Options, am *Analyses) {
+			warn_unreachable(program, am, opts.Warnings)
+		},
+	})
+	registerPass(&Pass{
+		name: "inline", kind: passTransform, level: 2, deps: []string{"cfg"},
+		desc: "inline calls to small functions",
+		run: func(program *ast.Program, opts *Options, am *Analyses) {
+			inline_program(program, am, opts.InlineLimit, opts.Dump)
+		},
+	})
+	registerPass(&Pass{
+		name: "dce", kind: passTransform, level: 1, deps: []string{"cfg"},
+		desc: "remove unreachable code, dead stores and unused values",
+		run:  func(program *ast.Program, opts *Options, am *Analyses) { dce_program(program, am) },
+	})
+	registerPass(&Pass{
+		name: "licm", kind: passTransform, level: 2, deps: []string{"loops"},
+		desc: "loop-invariant code motion and strength reduction",
+		run: func(program *ast.Program, opts *Options, am *Analyses) {
+			licm_program(program, am, opts.Dump)
+		},
+	})
+	/* cse rewrites expressions only: the blocks, and so the analyses, stay */
+	registerPass(&Pass{
+		name: "cse", kind: passTransform, level: 2, deps: []string{"domtree"},
+		preserves: []string{"cfg", "domtree", "loops"},
+		desc:      "common subexpression elimination",
+		run: func(program *ast.Program, opts *Options, am *Analyses) {
+			cse_program(program, am, opts.Dump)
+		},
+	})
+	registerPass(&Pass{
+		name: "tailcall", kind: passTransform, level: 1,
+		desc: "compile calls in return statements to jumps",
+	})
+	registerPass(&Pass{
+		name: "peephole", kind: passTransform, level: 1,
+		desc: "simplify short sequences of the generated instructions (AArch64)",
+	})
+}
+
+/*
+the passes to run, in order, for an optimization level and the
+-fpass/-fno-pass flags (name -> true/false)
+*/
+func ResolvePasses(level int, flags map[string]bool) ([]*Pass, error) {
+	enabled := make(map[string]bool)
+	for _, p := range passRegistry {
+		on, ok := flags[p.name]
+		if !ok {
+			on = p.kind != passAnalysis && p.level <= level
+		}
+		enabled[p.name] = on
+	}
+	/* dependencies, last to first, so that those of dependencies are added too */
+	for i := len(passRegistry) - 1; i >= 0; i-- {
+		p := passRegistry[i]
+		if !enabled[p.name] {
+			continue
+		}
+		for _, dep := range p.deps {
+			if on, ok := flags[dep]; ok && !on {
+				return nil, fmt.Errorf("pass '%s' requires '%s', which is disabled", p.name, dep)
+			}
+			enabled[dep] = true
+		}
+	}
+	var pipeline []*Pass
+	for _, p := range passRegistry {
+		if enabled[p.name] {
+			pipeline = append(pipeline, p)
+		}
+	}
+	return pipeline, nil
+}
+
+func PassNames() []string {
+	var names []string
+	for _, p := range passRegistry {
+		names = append(names, p.name)
+	}
+	return names
+}
+
+/* --- analyses --- */
+
+/* the functions of a program */
+func forFunctions(program *ast.Program, f func(fun *ast.DefFun)) {
+	for _, def := range program.Defs {
+		if d, ok := def.(*ast.DefFun); ok {
+			f(d)
+		}
+	}
+}
+
+/*
+the analyses of the functions of a program: computed when a pass
+asks for them, kept until a pass changes the function
+*/
+type Analyses struct {
+	cfgs  map[*ast.DefFun]*CFG
+	idoms map[*ast.DefFun]map[*BasicBlock]*BasicBlock
+	loops map[*ast.DefFun][]*NaturalLoop
+	valid map[string]bool // the analysis passes run since the last transform
+	runs  map[string]int  // how many times each analysis was computed (of a function)
+}
+
+func newAnalyses() *Analyses {
+	return &Analyses{
+		cfgs:  make(map[*ast.DefFun]*CFG),
+		idoms: make(map[*ast.DefFun]map[*BasicBlock]*BasicBlock),
+		loops: make(map[*ast.DefFun][]*NaturalLoop),
+		valid: make(map[string]bool),
+		runs:  make(map[string]int),
+	}
+}
+
+/* the control flow graph of fun */
+func (am *Analyses) CFG(fun *ast.DefFun) *CFG {
+	cfg, ok := am.cfgs[fun]
+	if !ok {
+		cfg = buildCFG(fun)
+		am.cfgs[fun] = cfg
+		am.runs["cfg"]++
+	}
+	return cfg
+}
+
+/* the immediate dominators of the blocks of CFG(fun) */
+func (am *Analyses) Dominators(fun *ast.DefFun) map[*BasicBlock]*BasicBlock {
+	idom, ok := am.idoms[fun]
+	if !ok {
+		idom = am.CFG(fun).dominators()
+		am.idoms[fun] = idom
+		am.runs["domtree"]++
+	}
+	return idom
+}
+
+/* the natural loops of CFG(fun), outermost first */
+func (am *Analyses) Loops(fun *ast.DefFun) []*NaturalLoop {
+	loops, ok := am.loops[fun]
+	if !ok {
+		loops = am.CFG(fun).naturalLoops(am.Dominators(fun))
+		am.loops[fun] = loops
+		am.runs["loops"]++
+	}
+	return loops
+}
+
+/* forget what was computed of fun, which a pass has changed */
+func (am *Analyses) invalidate(fun *ast.DefFun) {
+	delete(am.cfgs, fun)
+	delete(am.idoms, fun)
+	delete(am.loops, fun)
+}
+
+/* forget every analysis but those of keep (after a transform) */
+func (am *Analyses) invalidateExcept(keep []string) {
+	if !contains(keep, "cfg") {
+		am.cfgs = make(map[*ast.DefFun]*CFG)
+		am.valid["cfg"] = false
+	}
+	if !contains(keep, "domtree") {
+		am.idoms = make(map[*ast.DefFun]map[*BasicBlock]*BasicBlock)
+		am.valid["domtree"] = false
+	}
+	if !contains(keep, "loops") {
+		am.loops = make(map[*ast.DefFun][]*NaturalLoop)
+		am.valid["loops"] = false
+	}
+}
+
+/* run p, after the analyses it depends on that are not valid */
+func (am *Analyses) runPass(p *Pass, program *ast.Program, opts *Options, timer *PassTimer) {
+	if p.kind == passAnalysis && am.valid[p.name] {
+		return
+	}
+	for _, dep := range p.deps {
+		if d := FindPass(dep); d.kind == passAnalysis {
+			am.runPass(d, program, opts, timer)
+		}
+	}
+	if p.run == nil {
+		return
+	}
+	timer.Time(p.name, func() { p.run(program, opts, am) })
+	switch p.kind {
+	case passAnalysis:
+		am.valid[p.name] = true
+	case passTransform:
+		am.invalidateExcept(p.preserves)
+	}
+}
+
+/* --- --time-passes --- */
+
+type passTime struct {
+	name string
+	d    time.Duration
+	runs int
+}
+
+/* records how long each phase of a compilation takes (if enabled) */
+type PassTimer struct {
+	Enabled bool
+	times   []passTime
+}
+
+func (t *PassTimer) Time(name string, f func()) {
+	if !t.Enabled {
+		f()
+		return
+	}
+	start := time.Now()
+	f()
+	d := time.Since(start)
+	/* an analysis may run several times */
+	for i := range t.times {
+		if t.times[i].name == name {
+			t.times[i].d += d
+			t.times[i].runs++
+			return
+		}
+	}
+	t.times = append(t.times, passTime{name, d, 1})
+}
+
+/* the times recorded, slowest first, with their share of the total */
+func (t *PassTimer) Report(w io.Writer) {
+	if !t.Enabled || w == nil {
+		return
+	}
+	var total time.Duration
+	for _, pt := range t.times {
+		total += pt.d
+	}
+	times := append([]passTime{}, t.times...)
+	sort.SliceStable(times, func(i, j int) bool { return times[i].d > times[j].d })
+	fmt.Fprintf(w, "minc: time passes\n")
+	for _, pt := range times {
+		share := 0.0
+		if total > 0 {
+			share = 100 * float64(pt.d) / float64(total)
+		}
+		fmt.Fprintf(w, "  %-18s %12s %6.1f%%", pt.name, pt.d, share)
+		if pt.runs > 1 {
+			fmt.Fprintf(w, " (%d runs)", pt.runs)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	fmt.Fprintf(w, "  %-18s %12s\n", "total", total)
+}
@@ -0,0 +1,76 @@
+package codegen
+
+import (
+	"testing"
+
+	"minc/parse"
+)
+
+/* the pipelines of levels and flags, analyses included where a pass needs them */
+func TestResolvePasses(t *testing.T) {
+	tests := []struct {
+		level int
+		flags map[string]bool
+		want  string
+	}{
+		{0, nil, "cfg warn-unreachable"},
+		{1, nil, "cfg fold warn-unreachable dce tailcall peephole"},
+		{2, nil, "cfg domtree loops fold warn-unreachable inline dce licm cse tailcall peephole"},
+		{0, map[string]bool{"cse": true, "warn-unreachable": false}, "cfg domtree cse"},
+		{2, map[string]bool{"fold": false}, "cfg domtree loops warn-unreachable inline dce licm cse tailcall peephole"},
+	}
+	for _, tt := range tests {
+		pipeline, err := ResolvePasses(tt.level, tt.flags)
+		if err != nil {
+			t.Errorf("-O%d %v: %v", tt.level, tt.flags, err)
+			continue
+		}
+		got := ""
+		for _, p := range pipeline {
+			if got != "" {
+				got += " "
+			}
+			got += p.name
+		}
+		if got != tt.want {
+			t.Errorf("-O%d %v: %s, want %s", tt.level, tt.flags, got, tt.want)
+		}
+	}
+	if _, err := ResolvePasses(0, map[string]bool{"cfg": false}); err == nil {
+		t.Errorf("warn-unreachable without cfg: no error")
+	}
+}
+
+/* the analyses are computed once, and again only after a transform that does not preserve them */
+func TestAnalysesCached(t *testing.T) {
+	const src = `long f(long x) { long y; y = x + 1; if (x > 0) return x + 1; return y; }`
+	tests := []struct {
+		passes  []string
+		cfg     int // times the graph of f is built
+		domtree int
+	}{
+		{[]string{"warn-unreachable"}, 1, 0},
+		{[]string{"warn-unreachable", "cse"}, 1, 1},
+		/* inline (with no limit, inlining nothing) is a transform all the same */
+		{[]string{"warn-unreachable", "inline", "cse"}, 2, 1},
+	}
+	for _, tt := range tests {
+		program, err := parse.C(src, "f.c")
+		if err != nil {
+			t.Fatal(err)
+		}
+		flags := map[string]bool{"warn-unreachable": false}
+		for _, name := range tt.passes {
+			flags[name] = true
+		}
+		opts := &Options{PassFlags: flags}
+		if opts.Pipeline, err = ResolvePasses(0, flags); err != nil {
+			t.Fatal(err)
+		}
+		am := runPasses(program, opts, &PassTimer{})
+		if am.runs["cfg"] != tt.cfg || am.runs["domtree"] != tt.domtree {
+			t.Errorf("%v: cfg built %d times, domtree %d, want %d, %d",
+				tt.passes, am.runs["cfg"], am.runs["domtree"], tt.cfg, tt.domtree)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package codegen
+
+/* minc_peephole
+
+   a peephole optimizer over the instructions of minc_asm.
+
+   each rule looks at a short window of instructions and replaces it
+   by a cheaper equivalent; the rules are applied until none does
+   anything. a rewrite that drops the value of a register (or of
+   the condition flags) is only made when that value is dead: no
+   path from there reads it before it is written again (liveAt).
+
+     str x0, [sp, #144]        str x0, [sp, #144]
+     mov x0, #16               mov x1, #16
+     mov x1, x0          ->    mul x0, x0, x1
+     ldr x0, [sp, #144]
+     mul x0, x0, x1
+
+     cmp x0, x1                cmp x0, x1
+     cset x0, lt         ->    b.ge .L.end.2
+     cmp x0, #0
+     beq .L.end.2
+*/
+
+type peephole struct {
+	code   []*Instr
+	labels map[string]int // label -> its index in code
+}
+
+/* a rule: rewrite the window starting at i, returns true if it did */
+type peepholeRule func(p *peephole, i int) bool
+
+var peepholeRules = []peepholeRule{
+	(*peephole).forwardStore,
+	(*peephole).removeSelfMove,
+	(*peephole).propagateCopy,
+	(*peephole).branchOnCondition,
+	(*peephole).compareAndBranch,
+}
+
+/*
+may the value reg has before code[i] be read? control flow is
+followed through branches to labels of the same code; what is not
+understood is assumed to read everything
+*/
+func (p *peephole) liveAt(i int, reg string, seen map[int]bool) bool {
+	for ; i < len(p.code); i++ {
+		in := p.code[i]
+		switch in.kind {
+		case instrLabel:
+			/* a path already being followed */
+			if seen[i] {
+				return false
+			}
+			seen[i] = true
+			continue
+		case instrDirective:
+			return true
+		}
+		if _, ok := branchCond(in.op); ok || in.op == "cbz" || in.op == "cbnz" || in.op == "b" {
+			target := in.args[len(in.args)-1]
+			j, ok := p.labels[target]
+			if !ok || (in.op != "b" && reg == "nzcv") {
+				return true
+			}
+			if (in.op == "cbz" || in.op == "cbnz") && operandReg(in.args[0]) == reg {
+				return true
+			}
+			if p.liveAt(j, reg, seen) {
+				return true
+			}
+			if in.op == "b" {
+				return false
+			}
+			continue
+		}
+		reads, writes, ok := in.regEffects()
+		if !ok {
+			return true
+		}
+		if containsReg(reads, reg) {
+			return true
+		}
+		if containsReg(writes, reg) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *peephole) dead(i int, reg string) bool {
+	return !p.liveAt(i, reg, make(map[int]bool))
+}
+
+/*
+str xA, [b, #k] ... ldr xC, [b, #k] -> str xA, [b, #k] ... mov xC, xA
+as long as nothing in between changes xA, b or the memory at b + k
+*/
+func (p *peephole) forwardStore(i int) bool {
+	st := p.code[i]
+	if st.kind != instrOp || st.op != "str" || len(st.args) != 2 {
+		return false
+	}
+	src := operandReg(st.args[0])
+	base, off, ok := memOperand(st.args[1])
+	if src == "" || src == "xzr" || !ok || st.args[0][0] != 'x' {
+		return false
+	}
+	for j := i + 1; j < len(p.code); j++ {
+		in := p.code[j]
+		_, writes, ok := in.regEffects()
+		if !ok {
+			return false
+		}
+		if in.op == "ldr" && in.args[0][0] == 'x' {
+			if b, o, ok := memOperand(in.args[1]); ok && b == base && o == off {
+				dst := operandReg(in.args[0])
+				if dst == src {
+					p.code[j] = nil
+				} else {
+					p.code[j] = &Instr{kind: instrOp, op: "mov", args: []string{dst, src}}
+				}
+				return true
+			}
+		}
+		if in.op == "str" {
+			b, o, ok := memOperand(in.args[1])
+			if !ok || b != base || (o < off+8 && off < o+8) {
+				return false
+			}
+		}
+		if containsReg(writes, src) || containsReg(writes, base) {
+			return false
+		}
+	}
+	return false
+}
+
+/* mov xA, xA -> (nothing) */
+func (p *peephole) removeSelfMove(i int) bool {
+	in := p.code[i]
+	if in.kind == instrOp && in.op == "mov" && len(in.args) == 2 && in.args[0] == in.args[1] {
+		p.code[i] = nil
+		return true
+	}
+	return false
+}
+
+/*
+op xA, ...; mov xB, xA -> op xB, ...
+when xA is dead after the mov
+*/
+func (p *peephole) propagateCopy(i int) bool {
+	def := p.code[i]
+	if def.kind != instrOp || !defOps[def.op] || i+1 >= len(p.code) {
+		return false
+	}
+	mv := p.code[i+1]
+	if mv.kind != instrOp || mv.op != "mov" || len(mv.args) != 2 {
+		return false
+	}
+	a, b := operandReg(def.args[0]), operandReg(mv.args[1])
+	dst := operandReg(mv.args[0])
+	if a == "" || a == "sp" || a == "xzr" || a != b || def.args[0][0] != 'x' ||
+		dst == "" || dst == "sp" || dst == "xzr" || mv.args[0][0] != 'x' {
+		return false
+	}
+	if !p.dead(i+2, a) {
+		return false
+	}
+	args := append([]string{mv.args[0]}, def.args[1:]...)
+	p.code[i] = &Instr{kind: instrOp, op: def.op, args: args}
+	p.code[i+1] = nil
+	return true
+}
+
+/*
+cset xA, cc; cmp xA, #0; beq L -> b.<not cc> L   (bne: b.cc L)
+when xA and the flags the cmp sets are dead afterwards
+*/
+func (p *peephole) branchOnCondition(i int) bool {
+	if i+2 >= len(p.code) {
+		return false
+	}
+	cs, cmp, br := p.code[i], p.code[i+1], p.code[i+2]
+	if cs.kind != instrOp || cs.op != "cset" || len(cs.args) != 2 ||
+		cmp.kind != instrOp || cmp.op != "cmp" || len(cmp.args) != 2 || cmp.args[1] != "#0" ||
+		br.kind != instrOp {
+		return false
+	}
+	reg := operandReg(cs.args[0])
+	cond, ok := branchCond(br.op)
+	if reg == "" || operandReg(cmp.args[0]) != reg || !ok || (cond != "eq" && cond != "ne") {
+		return false
+	}
+	cc := cs.args[1]
+	if cond == "eq" {
+		if cc, ok = invertedCond[cc]; !ok {
+			return false
+		}
+	}
+	if !p.dead(i+3, reg) || !p.dead(i+3, "nzcv") {
+		return false
+	}
+	if j, ok := p.labels[br.args[0]]; !ok || !p.dead(j, reg) || !p.dead(j, "nzcv") {
+		return false
+	}
+	p.code[i] = &Instr{kind: instrOp, op: "b." + cc, args: []string{br.args[0]}}
+	p.code[i+1] = nil
+	p.code[i+2] = nil
+	return true
+}
+
+/* cmp xA, #0; beq L -> cbz xA, L   (bne: cbnz) when the flags are dead afterwards */
+func (p *peephole) compareAndBranch(i int) bool {
+	if i+1 >= len(p.code) {
+		return false
+	}
+	cmp, br := p.code[i], p.code[i+1]
+	if cmp.kind != instrOp || cmp.op != "cmp" || len(cmp.args) != 2 || cmp.args[1] != "#0" ||
+		br.kind != instrOp || operandReg(cmp.args[0]) == "" {
+		return false
+	}
+	cond, ok := branchCond(br.op)
+	if !ok || (cond != "eq" && cond != "ne") {
+		return false
+	}
+	if !p.dead(i+2, "nzcv") {
+		return false
+	}
+	if j, ok := p.labels[br.args[0]]; !ok || !p.dead(j, "nzcv") {
+		return false
+	}
+	op := "cbz"
+	if cond == "ne" {
+		op = "cbnz"
+	}
+	p.code[i] = &Instr{kind: instrOp, op: op, args: []string{cmp.args[0], br.args[0]}}
+	p.code[i+1] = nil
+	return true
+}
+
+/* drop the instructions rules removed and index the labels */
+func (p *peephole) compact() {
+	code := p.code[:0]
+	for _, in := range p.code {
+		if in != nil {
+			code = append(code, in)
+		}
+	}
+	p.code = code
+	p.labels = make(map[string]int)
+	for i, in := range p.code {
+		if in.kind == instrLabel {
+			p.labels[in.op] = i
+		}
+	}
+}
+
+/* apply the peephole rules to code until none applies */
+func peephole_optimize(code []*Instr) []*Instr {
+	p := &peephole{code: code}
+	p.compact()
+	for changed := true; changed; {
+		changed = false
+		for i := 0; i < len(p.code); i++ {
+			for _, rule := range peepholeRules {
+				if i < len(p.code) && rule(p, i) {
+					changed = true
+					p.compact()
+				}
+			}
+		}
+	}
+	return p.code
+}
@@ -0,0 +1,61 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPeephole(t *testing.T) {
+	tests := []struct{ code, want string }{
+		/* forwardStore */
+		{"str x0, [sp, #16]; mov x1, #2; ldr x2, [sp, #16]; ret",
+			"str x0, [sp, #16]; mov x1, #2; mov x2, x0; ret"},
+		{"str x0, [sp, #16]; ldr x0, [sp, #16]; ret",
+			"str x0, [sp, #16]; ret"},
+		/* not when the register, the base or the memory changes in between */
+		{"str x0, [sp, #16]; mov x0, #2; ldr x2, [sp, #16]; ret",
+			"str x0, [sp, #16]; mov x0, #2; ldr x2, [sp, #16]; ret"},
+		{"str x0, [x3, #16]; add x3, x3, #8; ldr x2, [x3, #16]; ret",
+			"str x0, [x3, #16]; add x3, x3, #8; ldr x2, [x3, #16]; ret"},
+		{"str x0, [sp, #16]; str x1, [sp, #20]; ldr x2, [sp, #16]; ret",
+			"str x0, [sp, #16]; str x1, [sp, #20]; ldr x2, [sp, #16]; ret"},
+		{"str x0, [sp, #16]; bl f; ldr x2, [sp, #16]; ret",
+			"str x0, [sp, #16]; bl f; ldr x2, [sp, #16]; ret"},
+		/* removeSelfMove */
+		{"mov x1, x1; ret", "ret"},
+		/* propagateCopy, when x0 is dead after the mov */
+		{"add x0, x2, x3; mov x1, x0; mov x0, #0; ret",
+			"add x1, x2, x3; mov x0, #0; ret"},
+		{"add x0, x2, x3; mov x1, x0; ret",
+			"add x0, x2, x3; mov x1, x0; ret"},
+		{"add x0, x2, x3; mov x1, x0; add x4, x0, x1; mov x0, #0; ret",
+			"add x0, x2, x3; mov x1, x0; add x4, x0, x1; mov x0, #0; ret"},
+		/* branchOnCondition, when x0 and the flags are dead on both paths */
+		{"cmp x0, x1; cset x0, lt; cmp x0, #0; beq .L1; add x1, x1, #1; .L1:; cmp x1, #9; cset x0, lt; ret",
+			"cmp x0, x1; b.ge .L1; add x1, x1, #1; .L1:; cmp x1, #9; cset x0, lt; ret"},
+		{"cmp x0, x1; cset x0, lt; cmp x0, #0; bne .L1; add x1, x1, #1; .L1:; cmp x1, #9; cset x0, lt; ret",
+			"cmp x0, x1; b.lt .L1; add x1, x1, #1; .L1:; cmp x1, #9; cset x0, lt; ret"},
+		/* x0 is read: compareAndBranch still applies */
+		{"cmp x0, x1; cset x0, lt; cmp x0, #0; beq .L1; add x1, x0, #1; .L1:; cmp x1, #9; cset x0, lt; ret",
+			"cmp x0, x1; cset x0, lt; cbz x0, .L1; add x1, x0, #1; .L1:; cmp x1, #9; cset x0, lt; ret"},
+		{"cmp x0, x1; cset x0, lt; cmp x0, #0; beq .L1; add x1, x1, #1; .L1:; add x1, x0, #1; cmp x1, #9; cset x0, lt; ret",
+			"cmp x0, x1; cset x0, lt; cbz x0, .L1; add x1, x1, #1; .L1:; add x1, x0, #1; cmp x1, #9; cset x0, lt; ret"},
+		/* compareAndBranch, when the flags are dead on both paths */
+		{"cmp x0, #0; bne .L1; add x1, x1, #1; .L1:; cmp x1, #9; cset x0, lt; ret",
+			"cbnz x0, .L1; add x1, x1, #1; .L1:; cmp x1, #9; cset x0, lt; ret"},
+		{"cmp x0, #0; beq .L1; cset x2, gt; .L1:; cmp x1, #9; cset x0, lt; ret",
+			"cmp x0, #0; beq .L1; cset x2, gt; .L1:; cmp x1, #9; cset x0, lt; ret"},
+		{"cmp x0, #0; beq .L1; add x1, x1, #1; .L1:; cset x0, gt; ret",
+			"cmp x0, #0; beq .L1; add x1, x1, #1; .L1:; cset x0, gt; ret"},
+		/* a label of other code: nothing is known about it */
+		{"cmp x0, #0; beq .L9; cmp x1, #9; cset x0, lt; ret",
+			"cmp x0, #0; beq .L9; cmp x1, #9; cset x0, lt; ret"},
+	}
+	lines := func(code string) string { return strings.ReplaceAll(code, "; ", "\n") }
+	for _, tt := range tests {
+		got := InstrsToString(peephole_optimize(ParseAsm(lines(tt.code))))
+		if want := InstrsToString(ParseAsm(lines(tt.want))); got != want {
+			t.Errorf("%s:\n%s\nwant\n%s", tt.code, got, want)
+		}
+	}
+}
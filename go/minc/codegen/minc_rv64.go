@@ -0,0 +1,321 @@
+package codegen
+
+/* minc_rv64
+
+   the RISC-V target (--target=riscv64-linux): RV64IM, LP64 calling
+   convention.
+
+   the accumulator is a0 and the second register a1; pushed values go
+   on the stack. the frame is addressed from s0, which points where
+   sp was on entry:
+
+     s0 + 8k          argument 9 + k (passed on the stack)
+     s0 - 8           return address
+     s0 - 16          s0 of the caller
+     s0 - 24 - 8i     parameter i < 8 (stored from its register)
+     below            local variables
+     sp               the values pushed so far
+
+   arguments are passed in a0 ... a7, the others on the stack, and
+   sp is a multiple of 16 at each call. RV64IM has no flags:
+   comparisons are slt (and seqz/snez), conditions are branches
+   comparing two registers.
+*/
+
+import (
+	"fmt"
+
+	"minc/ast"
+)
+
+type RV64 struct{}
+
+func newRV64() *RV64 {
+	return &RV64{}
+}
+
+var rv64ABI = ABI{
+	ArgRegs:    []string{"a0", "a1", "a2", "a3", "a4", "a5", "a6", "a7"},
+	wordSize:   8,
+	stackAlign: 16,
+}
+
+func (t *RV64) abi() *ABI         { return &rv64ABI }
+func (t *RV64) dialect() *Dialect { return &elfDialect }
+
+/* the branch for a comparison, and whether its operands are swapped (a > b is b < a) */
+var rv64Branches = map[string]struct {
+	op   string
+	swap bool
+}{
+	"==": {"beq", false}, "!=": {"bne", false},
+	"<": {"blt", false}, ">=": {"bge", false},
+	">": {"blt", true}, "<=": {"bge", true},
+}
+
+var rv64NegatedCmp = map[string]string{
+	"==": "!=", "!=": "==", "<": ">=", ">=": "<", ">": "<=", "<=": ">",
+}
+
+/* is v a 12-bit signed immediate (addi, ld, sd)? */
+func isRiscvImm12(v int64) bool {
+	return v >= -2048 && v <= 2047
+}
+
+func (t *RV64) prologue(g *CodeGen) {
+	g.emit("addi", "sp", "sp", "-16")
+	g.emit("sd", "ra", "8(sp)")
+	g.emit("sd", "s0", "0(sp)")
+	g.emit("addi", "s0", "sp", "16")
+	size := alignTo(len(g.params)*8+g.localVars.stackSize, 16)
+	switch {
+	case size == 0:
+	case isRiscvImm12(int64(-size)):
+		g.emit("addi", "sp", "sp", fmt.Sprint(-size))
+	default:
+		g.emit("li", "t0", fmt.Sprint(size))
+		g.emit("sub", "sp", "sp", "t0")
+	}
+	for i := range g.params {
+		if i < len(rv64ABI.ArgRegs) {
+			g.emit("sd", rv64ABI.ArgRegs[i], fmt.Sprintf("%d(s0)", -24-8*i))
+		}
+	}
+}
+
+/* release the frame (sp, s0 and ra as on entry) */
+func (t *RV64) epilogue(g *CodeGen) {
+	g.emit("addi", "sp", "s0", "-16")
+	g.emit("ld", "ra", "8(sp)")
+	g.emit("ld", "s0", "0(sp)")
+	g.emit("addi", "sp", "sp", "16")
+}
+
+func (t *RV64) ret(g *CodeGen) {
+	t.epilogue(g)
+	g.emit("ret")
+}
+
+/* the offset from s0 of a variable */
+func (t *RV64) varOffset(g *CodeGen, v varRef) int {
+	switch {
+	case v.param >= 0 && v.param < 8:
+		return -24 - 8*v.param
+	case v.param >= 8:
+		return 8 * (v.param - 8)
+	}
+	return -16 - len(g.params)*8 - v.local
+}
+
+/* the address operand for s0 + offset, computing it into t0 if needed */
+func (t *RV64) frameAddr(g *CodeGen, offset int) string {
+	if isRiscvImm12(int64(offset)) {
+		return fmt.Sprintf("%d(s0)", offset)
+	}
+	g.emit("li", "t0", fmt.Sprint(offset))
+	g.emit("add", "t0", "s0", "t0")
+	return "0(t0)"
+}
+
+func (t *RV64) loadImm(g *CodeGen, v int64) {
+	g.emit("li", "a0", fmt.Sprint(v))
+}
+
+func (t *RV64) loadAddr(g *CodeGen, sym string) {
+	g.emit("la", "a0", sym)
+}
+
+func (t *RV64) loadVar(g *CodeGen, v varRef) {
+	g.emit("ld", "a0", t.frameAddr(g, t.varOffset(g, v)))
+}
+
+func (t *RV64) storeVar(g *CodeGen, v varRef) {
+	g.emit("sd", "a0", t.frameAddr(g, t.varOffset(g, v)))
+}
+
+func (t *RV64) push(g *CodeGen) {
+	g.emit("addi", "sp", "sp", "-8")
+	g.emit("sd", "a0", "0(sp)")
+	g.depth++
+}
+
+func (t *RV64) pop(g *CodeGen, reg string) {
+	g.emit("ld", reg, "0(sp)")
+	g.emit("addi", "sp", "sp", "8")
+	g.depth--
+}
+
+func (t *RV64) popOperand(g *CodeGen) {
+	g.emit("mv", "a1", "a0")
+	t.pop(g, "a0")
+}
+
+func (t *RV64) unaryOp(g *CodeGen, op string) {
+	switch op {
+	case "-":
+		g.emit("neg", "a0", "a0")
+	case "!":
+		g.emit("seqz", "a0", "a0")
+	case "~":
+		g.emit("not", "a0", "a0")
+	}
+}
+
+func (t *RV64) binaryOp(g *CodeGen, op string) {
+	switch op {
+	case "+":
+		g.emit("add", "a0", "a0", "a1")
+	case "-":
+		g.emit("sub", "a0", "a0", "a1")
+	case "*":
+		g.emit("mul", "a0", "a0", "a1")
+	case "/":
+		g.emit("div", "a0", "a0", "a1")
+	case "%":
+		g.emit("rem", "a0", "a0", "a1")
+	case "<<":
+		g.emit("sll", "a0", "a0", "a1")
+	case ">>":
+		g.emit("sra", "a0", "a0", "a1")
+	case "&":
+		g.emit("and", "a0", "a0", "a1")
+	case "|":
+		g.emit("or", "a0", "a0", "a1")
+	case "^":
+		g.emit("xor", "a0", "a0", "a1")
+	case "==":
+		g.emit("sub", "a0", "a0", "a1")
+		g.emit("seqz", "a0", "a0")
+	case "!=":
+		g.emit("sub", "a0", "a0", "a1")
+		g.emit("snez", "a0", "a0")
+	case "<":
+		g.emit("slt", "a0", "a0", "a1")
+	case ">":
+		g.emit("slt", "a0", "a1", "a0")
+	case "<=":
+		g.emit("slt", "a0", "a1", "a0")
+		g.emit("xori", "a0", "a0", "1")
+	case ">=":
+		g.emit("slt", "a0", "a0", "a1")
+		g.emit("xori", "a0", "a0", "1")
+	}
+}
+
+func (t *RV64) jump(g *CodeGen, label string) {
+	g.emit("j", label)
+}
+
+func (t *RV64) branchZero(g *CodeGen, label string, jumpIf bool) {
+	if jumpIf {
+		g.emit("bnez", "a0", label)
+	} else {
+		g.emit("beqz", "a0", label)
+	}
+}
+
+/* a < b: blt a0, a1 (bge when jumping if false) */
+func (t *RV64) branchOp(g *CodeGen, e *ast.ExprOp, label string, jumpIf bool) bool {
+	if _, ok := rv64Branches[e.Op]; !ok || len(e.Args) != 2 {
+		return false
+	}
+	op := e.Op
+	if !jumpIf {
+		op = rv64NegatedCmp[op]
+	}
+	br := rv64Branches[op]
+	g.genOperands(e.Args[0], e.Args[1])
+	if br.swap {
+		g.emit(br.op, "a1", "a0", label)
+	} else {
+		g.emit(br.op, "a0", "a1", label)
+	}
+	return true
+}
+
+/*
+evaluate the arguments, leaving the first eight on the stack for
+popArgs and the others where the callee expects them. returns the
+bytes to release after the call (stack arguments and padding)
+*/
+func (t *RV64) pushArgs(g *CodeGen, args []ast.Expr) int {
+	nregs := len(rv64ABI.ArgRegs)
+	stackArgs := 0
+	if len(args) > nregs {
+		stackArgs = len(args) - nregs
+	}
+	/* once the register arguments are popped, depth must be even */
+	pad := 0
+	if (g.depth+stackArgs)%2 != 0 {
+		g.emit("addi", "sp", "sp", "-8")
+		g.depth++
+		pad = 1
+	}
+	for i := len(args) - 1; i >= nregs; i-- {
+		g.genExpr(args[i])
+		t.push(g)
+	}
+	for i := 0; i < len(args) && i < nregs; i++ {
+		g.genExpr(args[i])
+		t.push(g)
+	}
+	return 8 * (stackArgs + pad)
+}
+
+func (t *RV64) popArgs(g *CodeGen, args []ast.Expr) {
+	for i := min(len(args), len(rv64ABI.ArgRegs)) - 1; i >= 0; i-- {
+		t.pop(g, rv64ABI.ArgRegs[i])
+	}
+}
+
+func (t *RV64) call(g *CodeGen, call *ast.ExprCall) {
+	size := t.pushArgs(g, call.Args)
+	funId, direct := call.Fun.(*ast.ExprId)
+	if !direct {
+		g.genExpr(call.Fun)
+		g.emit("mv", "t1", "a0")
+	}
+	t.popArgs(g, call.Args)
+	if direct {
+		g.emit("call", g.symbol(funId.Name))
+	} else {
+		g.emit("jalr", "t1")
+	}
+	if size > 0 {
+		g.emit("addi", "sp", "sp", fmt.Sprint(size))
+		g.depth -= size / 8
+	}
+}
+
+/*
+return f(...); as a jump (see tailCall in minc_aarch64): to the
+start of the body for the function itself, to f with tail after
+the epilogue otherwise. only calls with all arguments in registers
+*/
+func (t *RV64) tailCall(g *CodeGen, call *ast.ExprCall) bool {
+	funId, ok := call.Fun.(*ast.ExprId)
+	nregs := len(rv64ABI.ArgRegs)
+	if !ok || len(call.Args) > nregs {
+		return false
+	}
+	for _, arg := range call.Args {
+		g.genExpr(arg)
+		t.push(g)
+	}
+	if funId.Name == g.funName && len(g.params) <= nregs {
+		for i := len(call.Args) - 1; i >= 0; i-- {
+			t.pop(g, "a0")
+			g.emit("sd", "a0", fmt.Sprintf("%d(s0)", -24-8*i))
+		}
+		g.emit("j", g.tailLabel)
+		return true
+	}
+	t.popArgs(g, call.Args)
+	t.epilogue(g)
+	g.emit("tail", g.symbol(funId.Name))
+	return true
+}
+
+func (t *RV64) peephole(code []*Instr) []*Instr {
+	return code
+}
@@ -0,0 +1,100 @@
+package codegen
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+/*
+the operands of each instruction the RV64 target may emit:
+r a register, i an immediate (12 bits unless li), m an address
+off(reg) with a 12-bit offset, l a label or symbol
+*/
+var rv64Forms = map[string]string{
+	"add": "rrr", "sub": "rrr", "mul": "rrr", "div": "rrr", "rem": "rrr",
+	"and": "rrr", "or": "rrr", "xor": "rrr", "sll": "rrr", "sra": "rrr", "slt": "rrr",
+	"addi": "rri", "xori": "rri",
+	"li": "ri", "la": "rl",
+	"mv": "rr", "neg": "rr", "not": "rr", "seqz": "rr", "snez": "rr",
+	"ld": "rm", "sd": "rm",
+	"beq": "rrl", "bne": "rrl", "blt": "rrl", "bge": "rrl",
+	"beqz": "rl", "bnez": "rl",
+	"j": "l", "call": "l", "tail": "l", "jalr": "r", "ret": "",
+}
+
+var (
+	rv64Reg   = regexp.MustCompile(`^(zero|ra|sp|gp|tp|t[0-6]|s[0-9]|s1[01]|a[0-7])$`)
+	rv64Mem   = regexp.MustCompile(`^(-?[0-9]+)\((zero|ra|sp|gp|tp|t[0-6]|s[0-9]|s1[01]|a[0-7])\)$`)
+	rv64Label = regexp.MustCompile(`^[A-Za-z_.][A-Za-z0-9_.]*$`)
+)
+
+/* does the operand have the shape form (one letter of rv64Forms)? */
+func rv64Operand(op string, form byte, arg string) bool {
+	switch form {
+	case 'r':
+		return rv64Reg.MatchString(arg)
+	case 'i':
+		v, err := strconv.ParseInt(arg, 10, 64)
+		return err == nil && (op == "li" || isRiscvImm12(v))
+	case 'm':
+		m := rv64Mem.FindStringSubmatch(arg)
+		if m == nil {
+			return false
+		}
+		v, err := strconv.ParseInt(m[1], 10, 64)
+		return err == nil && isRiscvImm12(v)
+	case 'l':
+		return rv64Label.MatchString(arg) && !rv64Reg.MatchString(arg)
+	}
+	return false
+}
+
+/*
+the RV64 output for test/src is made of RV64IM instructions with
+operands they take; with llvm-mc (or riscv64-linux-gnu-as) in the
+PATH, it is also assembled. it runs under qemu in TestRV64Qemu
+(package main) and make riscv in test/, where qemu is installed
+*/
+func TestRV64(t *testing.T) {
+	var as []string
+	if path, err := exec.LookPath("llvm-mc"); err == nil {
+		as = []string{path, "-triple=riscv64", "-mattr=+m", "-filetype=obj"}
+	} else if path, err := exec.LookPath("riscv64-linux-gnu-as"); err == nil {
+		as = []string{path, "-march=rv64im"}
+	} else {
+		t.Log("no RISC-V assembler (llvm-mc, riscv64-linux-gnu-as): checking the instructions only")
+	}
+	dir := t.TempDir()
+	forTestSrc(t, "riscv64-linux", func(file string, level int, code []*Instr) {
+		for _, in := range code {
+			if in.kind != instrOp {
+				continue
+			}
+			form, ok := rv64Forms[in.op]
+			if !ok || len(in.args) != len(form) {
+				t.Errorf("%s, -O%d: not an RV64IM instruction: %s", file, level, in)
+				continue
+			}
+			for k, arg := range in.args {
+				if !rv64Operand(in.op, form[k], arg) {
+					t.Errorf("%s, -O%d: bad operand %q: %s", file, level, arg, in)
+				}
+			}
+		}
+		if as == nil {
+			return
+		}
+		asm := filepath.Join(dir, "f.s")
+		if err := os.WriteFile(asm, []byte(InstrsToString(code)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.Command(as[0], append(as[1:], "-o", filepath.Join(dir, "f.o"), asm)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("%s, -O%d: %s: %v\n%s", file, level, filepath.Base(as[0]), err, out)
+		}
+	})
+}
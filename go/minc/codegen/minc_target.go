@@ -0,0 +1,125 @@
+package codegen
+
+/* minc_target
+
+   what the code generator needs to know about a machine.
+
+   the code generator (minc_cogen) walks the program and decides
+   what is computed when and where control goes; a Target turns that
+   into instructions for one machine and one assembler:
+
+     ABI        the registers that pass arguments, the size of a
+                word and the alignment of the stack at calls
+     Dialect    how the assembler wants directives, symbols and
+                local labels written
+     the rest   instruction emission, from loading a constant to
+                the frame of a function and the calling convention
+
+   all of them compute the value of an expression into a register of
+   their own, the accumulator; an operand computed before the other
+   one waits for it pushed on the stack (push), and is then popped
+   into a second register (popOperand).
+
+   a target is selected with --target=; adding one is adding a file
+   implementing Target and a line to targetRegistry.
+*/
+
+import (
+	"fmt"
+	"sort"
+
+	"minc/ast"
+)
+
+/* the calling convention and data layout of a target */
+type ABI struct {
+	ArgRegs    []string // the registers passing the first arguments, in order
+	wordSize   int      // bytes of a long, and of a stack slot
+	stackAlign int      // sp is a multiple of it at every call
+}
+
+/* the assembler dialect of a target */
+type Dialect struct {
+	header       []string // directives at the start of the file
+	footer       []string // directives at the end of the file
+	localPrefix  string   // of labels that do not become symbols
+	symbolPrefix string   // of the symbol of a function
+	typeDirs     bool     // declare functions with .type name, @function
+}
+
+/* a variable: parameter param (>= 0), or local variable at offset local of LocalVars */
+type varRef struct {
+	param int
+	local int
+}
+
+type Target interface {
+	abi() *ABI
+	dialect() *Dialect
+
+	/* enter a function (g.params, g.localVars) and store its parameters */
+	prologue(g *CodeGen)
+	/* leave the function, returning the accumulator */
+	ret(g *CodeGen)
+
+	loadImm(g *CodeGen, v int64)     // accumulator = v
+	loadAddr(g *CodeGen, sym string) // accumulator = the address of a symbol
+	loadVar(g *CodeGen, v varRef)    // accumulator = variable
+	storeVar(g *CodeGen, v varRef)   // variable = accumulator
+	push(g *CodeGen)                 // push the accumulator
+	popOperand(g *CodeGen)           // second register = accumulator, accumulator = popped
+	unaryOp(g *CodeGen, op string)   // accumulator = op accumulator
+	binaryOp(g *CodeGen, op string)  // accumulator = accumulator op second register
+
+	jump(g *CodeGen, label string)
+	/* jump if the accumulator is not 0 (jumpIf) or is 0 (!jumpIf) */
+	branchZero(g *CodeGen, label string, jumpIf bool)
+	/*
+	   jump if e is true (jumpIf) or false (!jumpIf) with the
+	   instructions the target has for it (comparisons, ...); returns
+	   false, having emitted nothing, for the operators it has none for
+	*/
+	branchOp(g *CodeGen, e *ast.ExprOp, label string, jumpIf bool) bool
+
+	/* call a function, leaving its value in the accumulator */
+	call(g *CodeGen, call *ast.ExprCall)
+	/* return call(...); as a jump; false (nothing emitted) if it cannot */
+	tailCall(g *CodeGen, call *ast.ExprCall) bool
+
+	/* the peephole optimizer of the target (the code unchanged if none) */
+	peephole(code []*Instr) []*Instr
+}
+
+/* the targets --target= accepts, and the default */
+var targetRegistry = map[string]func() Target{
+	"aarch64-linux":      func() Target { return newAArch64(false) },
+	"arm64-apple-darwin": func() Target { return newAArch64(true) },
+	"x86_64-linux":       func() Target { return newX86_64() },
+	"riscv64-linux":      func() Target { return newRV64() },
+}
+
+const DefaultTarget = "aarch64-linux"
+
+func TargetNames() []string {
+	var names []string
+	for name := range targetRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func LookupTarget(name string) (Target, error) {
+	newTarget, ok := targetRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown target '%s'", name)
+	}
+	return newTarget(), nil
+}
+
+/* the GNU assembler on ELF systems */
+var elfDialect = Dialect{
+	header:      []string{".text"},
+	localPrefix: ".L",
+	typeDirs:    true,
+}
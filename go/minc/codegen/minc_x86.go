@@ -0,0 +1,297 @@
+package codegen
+
+/* minc_x86
+
+   the x86-64 target (--target=x86_64-linux): System V calling
+   convention, Intel syntax.
+
+   the accumulator is rax and the second register rdi; pushed values
+   go on the stack with push. the frame is addressed from rbp:
+
+     rbp + 16 + 8k    argument 7 + k (passed on the stack)
+     rbp + 8          return address
+     rbp              rbp of the caller
+     rbp - 8 - 8i     parameter i < 6 (stored from its register)
+     below            local variables
+     rsp              the values pushed so far
+
+   the first six arguments are passed in rdi, rsi, rdx, rcx, r8 and
+   r9, the others on the stack, and rsp is a multiple of 16 at each
+   call; g.depth, the number of values pushed, tells when it is not.
+*/
+
+import (
+	"fmt"
+
+	"minc/ast"
+)
+
+type X86_64 struct{}
+
+func newX86_64() *X86_64 {
+	return &X86_64{}
+}
+
+var x86ABI = ABI{
+	ArgRegs:    []string{"rdi", "rsi", "rdx", "rcx", "r8", "r9"},
+	wordSize:   8,
+	stackAlign: 16,
+}
+
+var x86Dialect = Dialect{
+	header:      []string{".intel_syntax noprefix", ".text"},
+	footer:      []string{`.section .note.GNU-stack,"",@progbits`}, // the stack need not be executable
+	localPrefix: ".L",
+	typeDirs:    true,
+}
+
+func (t *X86_64) abi() *ABI         { return &x86ABI }
+func (t *X86_64) dialect() *Dialect { return &x86Dialect }
+
+var x86CondCodes = map[string]string{
+	"==": "e", "!=": "ne", "<": "l", "<=": "le", ">": "g", ">=": "ge",
+}
+
+var x86InvertedCond = map[string]string{
+	"e": "ne", "ne": "e", "l": "ge", "ge": "l", "g": "le", "le": "g",
+}
+
+func (t *X86_64) prologue(g *CodeGen) {
+	g.emit("push", "rbp")
+	g.emit("mov", "rbp", "rsp")
+	if size := alignTo(len(g.params)*8+g.localVars.stackSize, 16); size > 0 {
+		g.emit("sub", "rsp", fmt.Sprint(size))
+	}
+	for i := range g.params {
+		if i < len(x86ABI.ArgRegs) {
+			g.emit("mov", x86Mem(-8-8*i), x86ABI.ArgRegs[i])
+		}
+	}
+}
+
+func (t *X86_64) ret(g *CodeGen) {
+	g.emit("leave")
+	g.emit("ret")
+}
+
+/* [rbp + offset] */
+func x86Mem(offset int) string {
+	return fmt.Sprintf("QWORD PTR [rbp%+d]", offset)
+}
+
+/* the frame slot of a variable */
+func (t *X86_64) varAddr(g *CodeGen, v varRef) string {
+	switch {
+	case v.param >= 0 && v.param < 6:
+		return x86Mem(-8 - 8*v.param)
+	case v.param >= 6:
+		return x86Mem(16 + 8*(v.param-6))
+	}
+	return x86Mem(-(len(g.params)*8 + v.local))
+}
+
+/* does v fit in the sign-extended 32-bit immediate of an instruction? */
+func isX86Imm32(v int64) bool {
+	return v >= -1<<31 && v < 1<<31
+}
+
+func (t *X86_64) loadImm(g *CodeGen, v int64) {
+	/* a constant that does not fit in 32 bits becomes movabs */
+	g.emit("mov", "rax", fmt.Sprint(v))
+}
+
+func (t *X86_64) loadAddr(g *CodeGen, sym string) {
+	g.emit("lea", "rax", "[rip+"+sym+"]")
+}
+
+func (t *X86_64) loadVar(g *CodeGen, v varRef) {
+	g.emit("mov", "rax", t.varAddr(g, v))
+}
+
+func (t *X86_64) storeVar(g *CodeGen, v varRef) {
+	g.emit("mov", t.varAddr(g, v), "rax")
+}
+
+func (t *X86_64) push(g *CodeGen) {
+	g.emit("push", "rax")
+	g.depth++
+}
+
+func (t *X86_64) pop(g *CodeGen, reg string) {
+	g.emit("pop", reg)
+	g.depth--
+}
+
+func (t *X86_64) popOperand(g *CodeGen) {
+	g.emit("mov", "rdi", "rax")
+	t.pop(g, "rax")
+}
+
+func (t *X86_64) unaryOp(g *CodeGen, op string) {
+	switch op {
+	case "-":
+		g.emit("neg", "rax")
+	case "!":
+		g.emit("test", "rax", "rax")
+		g.emit("sete", "al")
+		g.emit("movzx", "eax", "al")
+	case "~":
+		g.emit("not", "rax")
+	}
+}
+
+func (t *X86_64) binaryOp(g *CodeGen, op string) {
+	if cc, ok := x86CondCodes[op]; ok {
+		g.emit("cmp", "rax", "rdi")
+		g.emit("set"+cc, "al")
+		g.emit("movzx", "eax", "al")
+		return
+	}
+	switch op {
+	case "+":
+		g.emit("add", "rax", "rdi")
+	case "-":
+		g.emit("sub", "rax", "rdi")
+	case "*":
+		g.emit("imul", "rax", "rdi")
+	case "/":
+		g.emit("cqo")
+		g.emit("idiv", "rdi")
+	case "%":
+		g.emit("cqo")
+		g.emit("idiv", "rdi")
+		g.emit("mov", "rax", "rdx")
+	case "<<":
+		g.emit("mov", "rcx", "rdi")
+		g.emit("sal", "rax", "cl")
+	case ">>":
+		g.emit("mov", "rcx", "rdi")
+		g.emit("sar", "rax", "cl")
+	case "&":
+		g.emit("and", "rax", "rdi")
+	case "|":
+		g.emit("or", "rax", "rdi")
+	case "^":
+		g.emit("xor", "rax", "rdi")
+	}
+}
+
+func (t *X86_64) jump(g *CodeGen, label string) {
+	g.emit("jmp", label)
+}
+
+func (t *X86_64) branchZero(g *CodeGen, label string, jumpIf bool) {
+	g.emit("test", "rax", "rax")
+	if jumpIf {
+		g.emit("jne", label)
+	} else {
+		g.emit("je", label)
+	}
+}
+
+/* a < b: cmp + jl (jge when jumping if false), with an immediate b if it fits */
+func (t *X86_64) branchOp(g *CodeGen, e *ast.ExprOp, label string, jumpIf bool) bool {
+	cc, ok := x86CondCodes[e.Op]
+	if !ok || len(e.Args) != 2 {
+		return false
+	}
+	if v, ok := literalValue(e.Args[1]); ok && isX86Imm32(v) {
+		g.genExpr(e.Args[0])
+		g.emit("cmp", "rax", fmt.Sprint(v))
+	} else {
+		g.genOperands(e.Args[0], e.Args[1])
+		g.emit("cmp", "rax", "rdi")
+	}
+	if !jumpIf {
+		cc = x86InvertedCond[cc]
+	}
+	g.emit("j"+cc, label)
+	return true
+}
+
+/*
+evaluate the arguments, leaving the first six on the stack for
+popArgs and the others where the callee expects them. returns the
+bytes to release after the call (stack arguments and padding)
+*/
+func (t *X86_64) pushArgs(g *CodeGen, args []ast.Expr) int {
+	nregs := len(x86ABI.ArgRegs)
+	stackArgs := 0
+	if len(args) > nregs {
+		stackArgs = len(args) - nregs
+	}
+	/* once the register arguments are popped, depth must be even */
+	pad := 0
+	if (g.depth+stackArgs)%2 != 0 {
+		g.emit("sub", "rsp", "8")
+		g.depth++
+		pad = 1
+	}
+	for i := len(args) - 1; i >= nregs; i-- {
+		g.genExpr(args[i])
+		t.push(g)
+	}
+	for i := 0; i < len(args) && i < nregs; i++ {
+		g.genExpr(args[i])
+		t.push(g)
+	}
+	return 8 * (stackArgs + pad)
+}
+
+func (t *X86_64) popArgs(g *CodeGen, args []ast.Expr) {
+	for i := min(len(args), len(x86ABI.ArgRegs)) - 1; i >= 0; i-- {
+		t.pop(g, x86ABI.ArgRegs[i])
+	}
+}
+
+func (t *X86_64) call(g *CodeGen, call *ast.ExprCall) {
+	size := t.pushArgs(g, call.Args)
+	funId, direct := call.Fun.(*ast.ExprId)
+	if !direct {
+		g.genExpr(call.Fun)
+		g.emit("mov", "r11", "rax")
+	}
+	t.popArgs(g, call.Args)
+	if direct {
+		g.emit("call", g.symbol(funId.Name))
+	} else {
+		g.emit("call", "r11")
+	}
+	if size > 0 {
+		g.emit("add", "rsp", fmt.Sprint(size))
+		g.depth -= size / 8
+	}
+}
+
+/*
+return f(...); as a jump (see tailCall in minc_aarch64): to the
+start of the body for the function itself, to f after leave
+otherwise. only calls with all arguments in registers
+*/
+func (t *X86_64) tailCall(g *CodeGen, call *ast.ExprCall) bool {
+	funId, ok := call.Fun.(*ast.ExprId)
+	nregs := len(x86ABI.ArgRegs)
+	if !ok || len(call.Args) > nregs {
+		return false
+	}
+	for _, arg := range call.Args {
+		g.genExpr(arg)
+		t.push(g)
+	}
+	if funId.Name == g.funName && len(g.params) <= nregs {
+		for i := len(call.Args) - 1; i >= 0; i-- {
+			t.pop(g, "rax")
+			g.emit("mov", x86Mem(-8-8*i), "rax")
+		}
+		g.emit("jmp", g.tailLabel)
+		return true
+	}
+	t.popArgs(g, call.Args)
+	g.emit("leave")
+	g.emit("jmp", g.symbol(funId.Name))
+	return true
+}
+
+func (t *X86_64) peephole(code []*Instr) []*Instr {
+	return code
+}
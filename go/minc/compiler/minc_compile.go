@@ -0,0 +1,164 @@
+/* package compiler: minC source into assembly or objects, all stages at once */
+package compiler
+
+/* minc_compile
+
+   the compiler, stage after stage, for programs that compile minC
+   (such as the command, minc.go):
+
+     src --parse--> AST --check--> AST --passes--> AST --codegen--> asm
+          (minc_cparse,    (minc_check)   (minc_passes)   (minc_cogen)
+           minc_parse)
+                                                 --assemble--> object
+                                                   (minc_as, minc_elf)
+
+   Parse stops after check, Program after the passes, and Compile
+   goes all the way. each stage returns what is wrong as an error (a list of
+   diagnostics, minc_diag); nothing is written anywhere but where
+   the options say.
+*/
+
+import (
+	"io"
+
+	"minc/ast"
+	"minc/check"
+	"minc/codegen"
+	"minc/diag"
+	"minc/parse"
+)
+
+/* what to compile with; the zero value compiles at -O0 for the default target */
+type Options struct {
+	codegen.Options           // the passes and the target (codegen.DefaultOptions)
+	Name            string    // the source's name, in diagnostics; XML if it ends in .xml, C otherwise
+	Language        string    // "c" or "xml", whatever the name ("": by the name)
+	Object          bool      // assemble an ELF object as well (the default target only)
+	Times           io.Writer // where the time each stage takes is reported (nil: nowhere)
+}
+
+/* what a program compiles to */
+type Result struct {
+	Program *ast.Program     // after the passes
+	Code    []*codegen.Instr // the instructions of the assembly (in minc_asm.go)
+	Asm     string
+	Object  []byte // the ELF object, if asked for
+}
+
+/* opts with what is not set (the target, the passes) as by default */
+func resolveOptions(opts *Options) error {
+	if opts.Target == nil {
+		t, err := codegen.LookupTarget(codegen.DefaultTarget)
+		if err != nil {
+			return err
+		}
+		opts.Target = t
+	}
+	if opts.Pipeline == nil {
+		pipeline, err := codegen.ResolvePasses(opts.Level, opts.PassFlags)
+		if err != nil {
+			return diag.UsageErrorf("%v", err)
+		}
+		opts.Pipeline = pipeline
+	}
+	if opts.Object && !codegen.CanAssemble(opts.Target) {
+		return diag.UsageErrorf("objects are only supported for %s", codegen.DefaultTarget)
+	}
+	return nil
+}
+
+/* src parsed, as C or XML (opts.Language) */
+func parseSource(src []byte, opts *Options) (*ast.Program, error) {
+	switch opts.Language {
+	case "":
+		return parse.Source(src, opts.Name) // in minc_cparse.go
+	case "c":
+		return parse.C(string(src), opts.Name)
+	case "xml":
+		return parse.XML(string(src), opts.Name) // in minc_parse.go
+	}
+	return nil, diag.UsageErrorf("unknown language '%s' (languages: c, xml)", opts.Language)
+}
+
+/* src parsed and checked */
+func checkAst(src []byte, opts *Options, timer *codegen.PassTimer) (*ast.Program, error) {
+	var program *ast.Program
+	var err error
+	timer.Time("parse", func() {
+		program, err = parseSource(src, opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+	timer.Time("check", func() {
+		err = check.Program(program, opts.Name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return program, nil
+}
+
+/* src parsed and checked, and run the passes on */
+func compileAst(src []byte, opts *Options, timer *codegen.PassTimer) (*ast.Program, error) {
+	program, err := checkAst(src, opts, timer)
+	if err != nil {
+		return nil, err
+	}
+	codegen.RunPasses(program, &opts.Options, timer)
+	return program, nil
+}
+
+/* the program of src, checked, as it is written (before the passes) */
+func Parse(src []byte, opts Options) (*ast.Program, error) {
+	if err := resolveOptions(&opts); err != nil {
+		return nil, err
+	}
+	timer := &codegen.PassTimer{Enabled: opts.Times != nil}
+	program, err := checkAst(src, &opts, timer)
+	timer.Report(opts.Times)
+	return program, err
+}
+
+/* the program of src, checked, after the passes (what Compile generates code for) */
+func Program(src []byte, opts Options) (*ast.Program, error) {
+	if err := resolveOptions(&opts); err != nil {
+		return nil, err
+	}
+	timer := &codegen.PassTimer{Enabled: opts.Times != nil}
+	program, err := compileAst(src, &opts, timer)
+	timer.Report(opts.Times)
+	return program, err
+}
+
+/* compile src into assembly (and an object, if opts.Object) */
+func Compile(src []byte, opts Options) (*Result, error) {
+	if err := resolveOptions(&opts); err != nil {
+		return nil, err
+	}
+	timer := &codegen.PassTimer{Enabled: opts.Times != nil}
+	defer timer.Report(opts.Times)
+	program, err := compileAst(src, &opts, timer)
+	if err != nil {
+		return nil, err
+	}
+	res := &Result{Program: program}
+	timer.Time("codegen", func() {
+		res.Code, err = codegen.Generate(program, opts.Target, opts.Enabled("tailcall"), opts.Enabled("peephole")) // in minc_cogen.go
+	})
+	if err != nil {
+		return nil, diag.InFile(err, opts.Name)
+	}
+	res.Asm = codegen.InstrsToString(res.Code)
+	if opts.Object {
+		var obj *codegen.Object
+		timer.Time("assemble", func() {
+			obj, err = codegen.Assemble(res.Code) // in minc_as.go
+		})
+		if err != nil {
+			return nil, diag.InFile(diag.CompileError([]diag.Diagnostic{{Msg: err.Error()}}), opts.Name)
+		}
+		res.Object = codegen.ElfObject(obj) // in minc_elf.go
+	}
+	return res, nil
+}
@@ -0,0 +1,133 @@
+/* package diag: the errors of minc, lists of diagnostics */
+package diag
+
+/* minc_diag
+
+   the errors of minc. no stage exits or panics on what it is given:
+   reading (parse.File), parsing (minc_cparse, minc_parse), checking
+   (minc_check), code generation (minc_cogen) and writing all return
+   an error, which main (or whoever calls them) reports. what is
+   wrong with a program is a list of diagnostics, as many as are
+   found, each saying where it is as well as it is known:
+
+     f20.c:3: expected ';', found '}'
+     f20.c: f: undeclared variable x
+
+   and each error is of a kind, the status minc exits with:
+
+     1  the program is wrong: it does not compile, link or run
+     2  the command line is wrong
+     3  a file cannot be read or written, or is not what it should be
+*/
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type Kind int
+
+const (
+	Compile Kind = 1
+	Usage   Kind = 2
+	Input   Kind = 3
+)
+
+/* something wrong, and where */
+type Diagnostic struct {
+	File string // "" if not known
+	Line int    // 0 if not known (the AST has no lines)
+	Fun  string // the function it is in, "" if none or not known
+	Msg  string
+}
+
+func (d Diagnostic) String() string {
+	where := []string{}
+	if d.File != "" && d.Line > 0 {
+		where = append(where, fmt.Sprintf("%s:%d", d.File, d.Line))
+	} else if d.File != "" {
+		where = append(where, d.File)
+	}
+	if d.Fun != "" {
+		where = append(where, d.Fun)
+	}
+	return strings.Join(append(where, d.Msg), ": ")
+}
+
+/* an error of minc: its kind and diagnostics (at least one) */
+type Error struct {
+	Kind  Kind
+	Diags []Diagnostic
+}
+
+func (e *Error) Error() string {
+	lines := make([]string, len(e.Diags))
+	for i, d := range e.Diags {
+		lines[i] = d.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+/* the error of diagnostics of a program (nil if there are none) */
+func CompileError(diags []Diagnostic) error {
+	if len(diags) == 0 {
+		return nil
+	}
+	return &Error{Compile, diags}
+}
+
+/* err, its diagnostics in file unless they say where they are */
+func InFile(err error, file string) error {
+	e, ok := err.(*Error)
+	if !ok {
+		return err
+	}
+	diags := make([]Diagnostic, len(e.Diags))
+	for i, d := range e.Diags {
+		if d.File == "" {
+			d.File = file
+		}
+		diags[i] = d
+	}
+	return &Error{e.Kind, diags}
+}
+
+/* a command line error */
+func UsageErrorf(format string, args ...interface{}) error {
+	return &Error{Usage, []Diagnostic{{Msg: fmt.Sprintf(format, args...)}}}
+}
+
+/* a file that cannot be read or written (err from os, say) */
+func InputError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Input, []Diagnostic{{Msg: err.Error()}}}
+}
+
+/* the kind of err; an error not of minc (emuFault, ...) is the program's */
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return Compile
+}
+
+/* errs as one error, their diagnostics in turn, of the kind of the first (nil if none) */
+func Join(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	joined := &Error{Kind: KindOf(errs[0])}
+	for _, err := range errs {
+		var e *Error
+		if errors.As(err, &e) {
+			joined.Diags = append(joined.Diags, e.Diags...)
+		} else {
+			joined.Diags = append(joined.Diags, Diagnostic{Msg: err.Error()})
+		}
+	}
+	return joined
+}
@@ -1,26 +1,573 @@
 package main
-import "os"
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
 
-/* read XML file and convert it to assembly string */
-func file_xml_to_asm(file_xml string) string {
-	program := file_xml_to_ast(file_xml) // in minc_parse.go
-	asm := ast_to_asm_program(program)	     // in minc_cogen.go
-	return asm
+	"minc/ast"
+	"minc/codegen"
+	"minc/compiler"
+	"minc/diag"
+	"minc/parse"
+)
+
+/* command line options: what to compile with (minc_compile), and where to */
+type cmdOptions struct {
+	compiler.Options        // -O2, -fpass=name, --target=name, -x lang, ... (in minc_compile.go)
+	output           string // -o file ("-": the standard output)
+	emit             string // --emit=kind, -S (asm) or -c (obj); "" to link an executable
+	werror           bool   // -Werror: a program the compiler warns about is wrong
+}
+
+/* what --emit emits:
+     ast  the tree of the program, as parsed (minc_dump)
+     xml  the program in the XML of parser/minc_to_xml.py (minc_toxml)
+     ir   the program after the passes, in C (what code is generated for)
+     asm  assembly (-S)
+     obj  an ELF object (-c) */
+var emitKinds = []string{"ast", "xml", "ir", "asm", "obj"}
+
+/* the warnings of -W<name>, and the passes that give them (minc_passes) */
+var warningPasses = map[string]string{
+	"unreachable-code": "warn-unreachable",
 }
 
-/* read XML file, convert it to assembly string, and write
-   it to a file (file_asm) */
-func file_xml_to_file_asm(file_xml string, file_asm string) {
-	asm := file_xml_to_asm(file_xml)
-	os.WriteFile(file_asm, []byte(asm), 0644)
+/* read a file of the command line ("-": the standard input) */
+func read_file(file string) ([]byte, error) {
+	if file == "-" {
+		src, err := io.ReadAll(os.Stdin)
+		return src, diag.InputError(err)
+	}
+	src, err := os.ReadFile(file)
+	return src, diag.InputError(err) // in minc_diag.go
+}
+
+func write_file(file string, data []byte, perm os.FileMode) error {
+	if file == "-" {
+		_, err := os.Stdout.Write(data)
+		return diag.InputError(err)
+	}
+	return diag.InputError(os.WriteFile(file, data, perm))
+}
+
+/* a writer counting the writes to it (the warnings, for -Werror) */
+type countWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	c.n++
+	return c.w.Write(p)
+}
+
+/* read a program and run compile on it, with the options to compile
+   it with (its name, ...); with -Werror, a warning is an error */
+func with_source(file string, opts *cmdOptions, compile func(src []byte, o compiler.Options) error) error {
+	src, err := read_file(file)
+	if err != nil {
+		return err
+	}
+	o := opts.Options
+	o.Name = file
+	if file == "-" {
+		o.Name = "<stdin>"
+	}
+	warnings := &countWriter{w: o.Warnings}
+	if o.Warnings != nil {
+		o.Warnings = warnings
+	}
+	if err := compile(src, o); err != nil {
+		return err
+	}
+	if opts.werror && warnings.n > 0 {
+		return diag.CompileError([]diag.Diagnostic{{File: o.Name, Msg: "warnings being treated as errors (-Werror)"}})
+	}
+	return nil
+}
+
+/* read a program and compile it (the options say to what) */
+func file_to_result(file string, opts *cmdOptions) (*compiler.Result, error) {
+	var res *compiler.Result
+	err := with_source(file, opts, func(src []byte, o compiler.Options) (err error) {
+		res, err = compiler.Compile(src, o) // in minc_compile.go
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+/* read a program, check it (minc_check), and run the passes of the
+   pipeline on it */
+func file_to_program(file string, opts *cmdOptions) (*ast.Program, error) {
+	var program *ast.Program
+	err := with_source(file, opts, func(src []byte, o compiler.Options) (err error) {
+		program, err = compiler.Program(src, o)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return program, nil
+}
+
+/* what a program compiles to, of a kind of emitKinds */
+func emit(src []byte, o compiler.Options, kind string) ([]byte, error) {
+	switch kind {
+	case "ast", "xml":
+		program, err := compiler.Parse(src, o)
+		if err != nil {
+			return nil, err
+		}
+		if kind == "ast" {
+			return []byte(ast.Dump(program)), nil // in minc_dump.go
+		}
+		return []byte(parse.ToXML(program)), nil // in minc_toxml.go
+	case "ir":
+		program, err := compiler.Program(src, o)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(program.String() + "\n"), nil
+	}
+	o.Object = kind == "obj"
+	res, err := compiler.Compile(src, o)
+	if err != nil {
+		return nil, err
+	}
+	if o.Object {
+		return res.Object, nil
+	}
+	return []byte(res.Asm), nil
+}
+
+/* where what is emitted of file goes without -o: as with gcc, the
+   assembly of dir/f.c to f.s and its object to f.o, in the current
+   directory; the rest, and all of the standard input, to the
+   standard output */
+func output_name(file string, kind string) string {
+	base := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	switch {
+	case file == "-":
+		return "-"
+	case kind == "asm":
+		return base + ".s"
+	case kind == "obj":
+		return base + ".o"
+	}
+	return "-"
+}
+
+/* read a program, compile it to what --emit says, and write it to a
+   file (file_out) */
+func file_to_file(file string, file_out string, opts *cmdOptions) error {
+	if file_out == file && file != "-" {
+		return diag.UsageErrorf("input file %s is the same as the output", file)
+	}
+	var data []byte
+	err := with_source(file, opts, func(src []byte, o compiler.Options) (err error) {
+		data, err = emit(src, o, opts.emit)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return write_file(file_out, data, 0644)
+}
+
+/* compile each file to its own output (-S, -c, --emit); the errors
+   of all of them, if some are wrong */
+func files_to_files(files []string, opts *cmdOptions) error {
+	if opts.output != "" && len(files) > 1 {
+		return diag.UsageErrorf("cannot specify -o with -S, -c or --emit with multiple files")
+	}
+	var errs []error
+	for _, file := range files {
+		if strings.HasSuffix(file, ".o") {
+			fmt.Fprintf(os.Stderr, "minc: warning: %s: linker input file unused because linking not done\n", file)
+			continue
+		}
+		file_out := opts.output
+		if file_out == "" {
+			file_out = output_name(file, opts.emit)
+		}
+		if err := file_to_file(file, file_out, opts); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return diag.Join(errs) // in minc_diag.go
+}
+
+/* read an object from assembly, an object file, or a program to compile */
+func file_to_object(file string, opts *cmdOptions) (*codegen.Object, error) {
+	switch {
+	case strings.HasSuffix(file, ".s"):
+		src, err := read_file(file)
+		if err != nil {
+			return nil, err
+		}
+		obj, err := codegen.Assemble(codegen.ParseAsm(string(src)))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		return obj, nil
+	case strings.HasSuffix(file, ".o"):
+		obj, err := codegen.ReadElfObject(file)
+		return obj, diag.InputError(err)
+	}
+	res, err := file_to_result(file, opts)
+	if err != nil {
+		return nil, err
+	}
+	return codegen.Assemble(res.Code)
+}
+
+/* minc_as only assembles for the default target (what needs it: -c, emu) */
+func check_object_target(opts *cmdOptions, what string) error {
+	if !codegen.CanAssemble(opts.Target) { // in minc_as.go
+		return diag.UsageErrorf("%s is only supported for %s", what, codegen.DefaultTarget)
+	}
+	return nil
+}
+
+/* compile the programs among files, and link them with the objects
+   (minc_ld) into an executable (file_exe) */
+func files_to_file_exe(files []string, file_exe string, opts *cmdOptions) error {
+	if err := check_object_target(opts, "linking"); err != nil {
+		return err
+	}
+	objs := []*codegen.Object{}
+	var errs []error
+	for _, file := range files {
+		obj, err := file_to_object(file, opts)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		objs = append(objs, obj)
+	}
+	if len(errs) > 0 {
+		return diag.Join(errs)
+	}
+	exe, err := codegen.LinkObjects(files, objs)
+	if err != nil {
+		return diag.CompileError([]diag.Diagnostic{{Msg: err.Error()}})
+	}
+	return write_file(file_exe, exe, 0755)
+}
+
+/* -O<n>: -O is -O1, as are -Og; -Os, -Oz, -Ofast and above -O2 are -O2 */
+func parse_level(arg string) (int, error) {
+	switch arg {
+	case "-O", "-Og":
+		return 1, nil
+	case "-Os", "-Oz", "-Ofast":
+		return 2, nil
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(arg, "-O"))
+	if err != nil || n < 0 {
+		return 0, diag.UsageErrorf("bad value in %s", arg)
+	}
+	if n > 2 {
+		n = 2
+	}
+	return n, nil
+}
+
+/* -x c, -x xml, or -x none (by the suffix, .xml or not) */
+func set_language(opts *cmdOptions, lang string) error {
+	switch lang {
+	case "c", "xml":
+		opts.Language = lang
+	case "none":
+		opts.Language = ""
+	default:
+		return diag.UsageErrorf("unknown language '%s' in -x (languages: c, xml, none)", lang)
+	}
+	return nil
+}
+
+/* the names of the warnings of -W<name> */
+func warning_names() []string {
+	names := []string{}
+	for name := range warningPasses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func contains(a []string, s string) bool {
+	for _, x := range a {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+/* split command line arguments into options and file names */
+func parse_args(args []string) (*cmdOptions, []string, error) {
+	opts := &cmdOptions{}
+	opts.InlineLimit = codegen.DefaultInlineLimit
+	opts.Level = 2
+	opts.PassFlags = make(map[string]bool)
+	opts.Warnings = os.Stderr
+	target := codegen.DefaultTarget
+	files := []string{}
+	asm, object, no_warnings := false, false, false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-":
+			files = append(files, arg)
+		case arg == "-S":
+			asm = true
+		case arg == "-c":
+			object = true
+		case arg == "-o" || arg == "-x":
+			if i+1 == len(args) {
+				return nil, nil, diag.UsageErrorf("missing argument to %s", arg)
+			}
+			i++
+			if arg == "-o" {
+				opts.output = args[i]
+			} else if err := set_language(opts, args[i]); err != nil {
+				return nil, nil, err
+			}
+		case strings.HasPrefix(arg, "-o"):
+			opts.output = strings.TrimPrefix(arg, "-o")
+		case strings.HasPrefix(arg, "-x"):
+			if err := set_language(opts, strings.TrimPrefix(arg, "-x")); err != nil {
+				return nil, nil, err
+			}
+		case strings.HasPrefix(arg, "--emit="):
+			opts.emit = strings.TrimPrefix(arg, "--emit=")
+			if !contains(emitKinds, opts.emit) {
+				return nil, nil, diag.UsageErrorf("unknown kind '%s' in %s (kinds: %s)", opts.emit, arg, strings.Join(emitKinds, ", "))
+			}
+		case arg == "-fdump":
+			opts.Dump = os.Stderr
+		case strings.HasPrefix(arg, "-O"):
+			level, err := parse_level(arg)
+			if err != nil {
+				return nil, nil, err
+			}
+			opts.Level = level
+		case arg == "--time-passes":
+			opts.Times = os.Stderr
+		case arg == "-w":
+			no_warnings = true
+		case arg == "-Werror":
+			opts.werror = true
+		case arg == "-Wno-error":
+			opts.werror = false
+		case arg == "-Wall" || arg == "-Wextra":
+			for _, pass := range warningPasses {
+				opts.PassFlags[pass] = true
+			}
+		case strings.HasPrefix(arg, "-Wno-"):
+			/* as with gcc, a warning not known is no error */
+			if pass, ok := warningPasses[strings.TrimPrefix(arg, "-Wno-")]; ok {
+				opts.PassFlags[pass] = false
+			}
+		case strings.HasPrefix(arg, "-W"):
+			pass, ok := warningPasses[strings.TrimPrefix(arg, "-W")]
+			if !ok {
+				return nil, nil, diag.UsageErrorf("unknown warning option %s (warnings: %s)", arg, strings.Join(warning_names(), ", "))
+			}
+			opts.PassFlags[pass] = true
+		case strings.HasPrefix(arg, "-fpass=") || strings.HasPrefix(arg, "-fno-pass="):
+			on := strings.HasPrefix(arg, "-fpass=")
+			name := arg[strings.Index(arg, "=")+1:]
+			if codegen.FindPass(name) == nil {
+				return nil, nil, diag.UsageErrorf("unknown pass '%s' (passes: %s)", name, strings.Join(codegen.PassNames(), ", "))
+			}
+			opts.PassFlags[name] = on
+		case strings.HasPrefix(arg, "--target="):
+			target = strings.TrimPrefix(arg, "--target=")
+		case strings.HasPrefix(arg, "-finline-limit="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "-finline-limit="))
+			if err != nil || n < 0 {
+				return nil, nil, diag.UsageErrorf("bad value in %s", arg)
+			}
+			opts.InlineLimit = n
+		case strings.HasPrefix(arg, "-"):
+			return nil, nil, diag.UsageErrorf("unknown option %s (see --help)", arg)
+		default:
+			files = append(files, arg)
+		}
+	}
+	/* as with gcc, -S wins over -c (and --emit over both) */
+	if opts.emit == "" && asm {
+		opts.emit = "asm"
+	} else if opts.emit == "" && object {
+		opts.emit = "obj"
+	}
+	if no_warnings {
+		opts.Warnings = nil
+	}
+	t, err := codegen.LookupTarget(target)
+	if err != nil {
+		return nil, nil, diag.UsageErrorf("%v (targets: %s)", err, strings.Join(codegen.TargetNames(), ", "))
+	}
+	opts.Target = t
+	pipeline, err := codegen.ResolvePasses(opts.Level, opts.PassFlags)
+	if err != nil {
+		return nil, nil, diag.UsageErrorf("%v", err)
+	}
+	opts.Pipeline = pipeline
+	return opts, files, nil
+}
+
+/* report err, a diagnostic per line, and exit with its status (minc_diag) */
+func fatal(err error) {
+	for _, line := range strings.Split(err.Error(), "\n") {
+		fmt.Fprintf(os.Stderr, "minc: %s\n", line)
+	}
+	os.Exit(int(diag.KindOf(err)))
+}
+
+/* --help (%[1]s: the command, %[2]s: the targets, %[3]s: the warnings) */
+const usage = `usage: %[1]s [options] file...
+compile minC programs (fun.c, or their XML fun.xml) and link them
+with objects (.o) and assembly (.s) into an executable (a.out)
+
+  -S                   compile to assembly (fun.s), without linking
+  -c                   compile to an object (fun.o), without linking
+  --emit=kind          compile to ast, xml, ir (the program after the
+                       passes), asm or obj, without linking
+  -o file              write to file (-: the standard output)
+  -x lang              the files are c or xml (none: by their suffix)
+  -                    read a program from the standard input
+  -O<n>                optimize at level n: 0, 1 or 2 (the default)
+  -fpass=name          run a pass whatever the level
+  -fno-pass=name       do not run it
+  -finline-limit=N     inline functions of up to N nodes
+  -fdump               report what the passes eliminated
+  --time-passes        report the time each stage took
+  --target=name        generate code for a target: %[2]s
+  -W<warning>          warn: %[3]s
+  -Wno-<warning>       do not warn
+  -Wall, -Wextra       give all the warnings
+  -Werror              make the warnings errors
+  -w                   give no warnings
+  --help               print this
+  --version            print the version of minc
+
+  %[1]s fun.xml fun.s is %[1]s -S fun.xml -o fun.s
+
+  %[1]s emu [--seed=N] [--test-no=N] [options] fun.c ...
+                       run the code on the AArch64 emulator
+  %[1]s run [--seed=N] [--test-no=N] [options] fun.c [-- arg ...]
+                       interpret the program
+  %[1]s gen [--seed=N]
+                       generate a random program
+  %[1]s fuzz [--seed=N] [--count=N] [--levels=0,1,2] [options]
+                       check the compiler with random programs
+  %[1]s reduce [--test-no=N] [--cmd=command] [options] fun.c [-- arg ...]
+                       shrink a program the compiler gets wrong
+
+the exit status is 1 if a program is wrong, 2 if the command line
+is, and 3 if a file cannot be read or written.
+`
+
+/* the version: of the module, and the commit it is built from ("+" if modified) */
+func print_version() {
+	version := "(devel)"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		version = info.Main.Version
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" && len(s.Value) >= 12 {
+				version += " " + s.Value[:12]
+			} else if s.Key == "vcs.modified" && s.Value == "true" {
+				version += "+"
+			}
+		}
+	}
+	fmt.Printf("minc %s\n", version)
+	fmt.Printf("target: %s (targets: %s)\n", codegen.DefaultTarget, strings.Join(codegen.TargetNames(), ", "))
 }
 
-/* entry point 
-   ./minc fun.xml fun.s 
-   read an XML file and generate assembly code in fun.s
+/* entry point
+   ./minc [options] fun.c ... [-o prog]
+   compile, and link with objects (.o), into an executable (a.out by default)
+   ./minc -S fun.c, ./minc -c fun.c, ./minc --emit=kind fun.c
+   compile into assembly (fun.s), an object (fun.o), ... (see usage)
+   ./minc fun.xml fun.s
+   read an XML file (or fun.c) and generate assembly code in fun.s
+   ./minc emu [--seed=N] [--test-no=N] [options] fun.c ...
+   run the code on the AArch64 emulator (minc_emu)
+   ./minc run [--seed=N] [--test-no=N] [options] fun.c [-- arg ...]
+   interpret the program (minc_interp)
+   ./minc gen [--seed=N]
+   ./minc fuzz [--seed=N] [--count=N] [--levels=0,1,2] [options]
+   generate random programs, and check the compiler with them (minc_gen)
+   ./minc reduce [--cmd=command] [options] fun.c [-- arg ...]
+   shrink a program the compiler gets wrong (minc_reduce)
+   the exit status is 1 if the program is wrong, 2 if the command
+   line is, and 3 if a file cannot be read or written (minc_diag)
 */
 func main() {
-	file_xml := os.Args[1]
-	file_asm := os.Args[2]
-	file_xml_to_file_asm(file_xml, file_asm)
+	if len(os.Args) > 1 && os.Args[1] == "emu" {
+		emu_main(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "run" {
+		run_main(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "gen" {
+		gen_main(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "fuzz" {
+		fuzz_main(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "reduce" {
+		reduce_main(os.Args[2:])
+		return
+	}
+	for _, arg := range os.Args[1:] {
+		switch arg {
+		case "--help":
+			fmt.Printf(usage, os.Args[0], strings.Join(codegen.TargetNames(), ", "), strings.Join(warning_names(), ", "))
+			return
+		case "--version":
+			print_version()
+			return
+		}
+	}
+	opts, files, err := parse_args(os.Args[1:])
+	if err != nil {
+		fatal(err)
+	}
+	if len(files) == 0 {
+		fatal(diag.UsageErrorf("no input files (see --help)"))
+	}
+	/* the form of old, ./minc fun.xml fun.s (test/Makefile) */
+	if opts.emit == "" && opts.output == "" && len(files) == 2 && strings.HasSuffix(files[1], ".s") &&
+		!strings.HasSuffix(files[0], ".o") && !strings.HasSuffix(files[0], ".s") {
+		opts.emit, opts.output, files = "asm", files[1], files[:1]
+	}
+	if opts.emit != "" {
+		err = files_to_files(files, opts)
+	} else {
+		file_exe := opts.output
+		if file_exe == "" {
+			file_exe = "a.out"
+		}
+		err = files_to_file_exe(files, file_exe, opts)
+	}
+	if err != nil {
+		fatal(err)
+	}
 }
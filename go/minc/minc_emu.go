@@ -0,0 +1,884 @@
+package main
+
+/* minc_emu
+
+   an AArch64 emulator, to run what minc compiles on machines that
+   are not AArch64:
+
+     ./minc emu f20.s          call f as test/main.c does and print
+     ./minc emu f20.o          what it returns
+     ./minc emu f20.c
+     ./minc emu prog           run an executable (minc_ld), exiting
+                               with its status
+
+   it runs machine code: assembly and C are assembled (minc_as) and
+   linked (minc_ld) first, so what is tested is what an AArch64
+   machine would run. the instructions are those minc emits, and a
+   few neighbours (64-bit integer arithmetic, logic and shifts, moves,
+   loads and stores, branches, calls and returns, sdiv and msub);
+   anything else is an undefined instruction, and an access outside
+   the code and the stack a fault.
+
+   the code is decoded once, into an emuInstr for each word, which
+   the machine then executes. the stack is allocated as it is
+   touched, up to 1 GiB (what test/main.c gives the executables gcc
+   makes).
+*/
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"os"
+	"strconv"
+	"strings"
+
+	"minc/codegen"
+	"minc/compiler"
+	"minc/diag"
+)
+
+type emuOp uint8
+
+const (
+	emuUndefined emuOp = iota
+	emuAdd             // rd = rn + operand 2 (adds if setFlags)
+	emuSub
+	emuAnd
+	emuOrr
+	emuEor
+	emuMovz // rd = imm << amount
+	emuMovn
+	emuMovk
+	emuAddr // rd = imm (adr, adrp: the address is known when decoding)
+	emuB    // pc = imm
+	emuBL
+	emuBCond
+	emuCbz
+	emuCbnz
+	emuTbz // bit amount of rd
+	emuTbnz
+	emuBr // pc = rn
+	emuBlr
+	emuRet
+	emuSvc
+	emuLdr // rd, [rn + imm] (mode)
+	emuStr
+	emuLdp // rd, ra, [rn + imm] (mode)
+	emuStp
+	emuUdiv
+	emuSdiv
+	emuLslv
+	emuLsrv
+	emuAsrv
+	emuRorv
+	emuMadd // rd = ra + rn * rm
+	emuMsub // rd = ra - rn * rm
+	emuCsel // rd = cond ? rn : rm (imm: 1 csinc, 2 csinv, 3 csneg)
+)
+
+/* registers: x0 ... x30, then sp and the zero register */
+const (
+	emuSP = 31
+	emuZR = 32
+)
+
+/* what operand 2 of an arithmetic or logical instruction is */
+const (
+	op2Imm   = iota // imm
+	op2Shift        // rm shifted (shift, amount)
+	op2Ext          // rm extended (shift is the option), then shifted left
+)
+
+const (
+	shiftLSL = iota
+	shiftLSR
+	shiftASR
+	shiftROR
+)
+
+/* the addressing mode of a load or store */
+const (
+	modeOffset = iota // [rn, #imm]
+	modePre           // [rn, #imm]!
+	modePost          // [rn], #imm
+)
+
+type emuInstr struct {
+	op             emuOp
+	rd, rn, rm, ra uint8 // ra is also the second register of a pair
+	op2            uint8
+	shift          uint8
+	amount         uint8
+	cond           uint8
+	mode           uint8
+	setFlags       bool
+	invert         bool // operand 2 complemented (bic, orn, eon)
+	imm            int64
+}
+
+func signExtend(v uint64, bits uint) int64 {
+	return int64(v<<(64-bits)) >> (64 - bits)
+}
+
+/* the value of a logical immediate (N:immr:imms) */
+func decodeBitMask(n, immr, imms uint32) (uint64, bool) {
+	length := bits.Len32(n<<6|(^imms&0x3f)) - 1
+	if length < 1 {
+		return 0, false
+	}
+	size := uint(1) << uint(length)
+	levels := uint32(size - 1)
+	s, r := uint(imms&levels), uint(immr&levels)
+	if s == uint(levels) {
+		return 0, false
+	}
+	elem := uint64(1)<<(s+1) - 1
+	if r > 0 {
+		elem = (elem>>r | elem<<(size-r)) & (uint64(1)<<size - 1)
+	}
+	v := uint64(0)
+	for i := uint(0); i < 64; i += size {
+		v |= elem << i
+	}
+	return v, true
+}
+
+/* decode the instruction word at pc */
+func emuDecode(word uint32, pc uint64) emuInstr {
+	in := emuInstr{}
+	rd, rn, rm := uint8(word&31), uint8(word>>5&31), uint8(word>>16&31)
+	/* register 31 is the zero register, except where it is sp */
+	zr := func(r uint8) uint8 {
+		if r == 31 {
+			return emuZR
+		}
+		return r
+	}
+	sf := word>>31 == 1
+	switch {
+	case sf && word&0x1f800000 == 0x11000000: // add/sub (immediate)
+		in.op, in.op2 = emuAdd, op2Imm
+		if word>>30&1 == 1 {
+			in.op = emuSub
+		}
+		in.setFlags = word>>29&1 == 1
+		in.imm = int64(word >> 10 & 0xfff)
+		if word>>22&1 == 1 {
+			in.imm <<= 12
+		}
+		in.rd, in.rn = rd, rn
+		if in.setFlags {
+			in.rd = zr(rd)
+		}
+	case sf && word&0x1f800000 == 0x12000000: // logical (immediate)
+		v, ok := decodeBitMask(word>>22&1, word>>16&63, word>>10&63)
+		if !ok {
+			return in
+		}
+		in.op = []emuOp{emuAnd, emuOrr, emuEor, emuAnd}[word>>29&3]
+		in.setFlags = word>>29&3 == 3
+		in.op2, in.imm = op2Imm, int64(v)
+		in.rd, in.rn = rd, zr(rn)
+		if in.setFlags {
+			in.rd = zr(rd)
+		}
+	case sf && word&0x1f800000 == 0x12800000: // move wide
+		switch word >> 29 & 3 {
+		case 0:
+			in.op = emuMovn
+		case 2:
+			in.op = emuMovz
+		case 3:
+			in.op = emuMovk
+		default:
+			return in
+		}
+		in.rd, in.imm, in.amount = zr(rd), int64(word>>5&0xffff), uint8(word>>21&3*16)
+	case word&0x1f000000 == 0x10000000: // adr, adrp
+		imm := signExtend(uint64(word>>5&0x7ffff)<<2|uint64(word>>29&3), 21)
+		in.op, in.rd = emuAddr, zr(rd)
+		if sf {
+			in.imm = int64(pc&^0xfff) + imm<<12
+		} else {
+			in.imm = int64(pc) + imm
+		}
+	case word&0x7c000000 == 0x14000000: // b, bl
+		in.op = emuB
+		if sf {
+			in.op = emuBL
+		}
+		in.imm = int64(pc) + signExtend(uint64(word&0x3ffffff), 26)*4
+	case word&0xff000010 == 0x54000000: // b.cond
+		in.op, in.cond = emuBCond, uint8(word&15)
+		in.imm = int64(pc) + signExtend(uint64(word>>5&0x7ffff), 19)*4
+	case sf && word&0x7e000000 == 0x34000000: // cbz, cbnz
+		in.op = emuCbz
+		if word>>24&1 == 1 {
+			in.op = emuCbnz
+		}
+		in.rd = zr(rd)
+		in.imm = int64(pc) + signExtend(uint64(word>>5&0x7ffff), 19)*4
+	case word&0x7e000000 == 0x36000000: // tbz, tbnz
+		in.op = emuTbz
+		if word>>24&1 == 1 {
+			in.op = emuTbnz
+		}
+		in.rd, in.amount = zr(rd), uint8(word>>31<<5|word>>19&31)
+		in.imm = int64(pc) + signExtend(uint64(word>>5&0x3fff), 14)*4
+	case word&0xfffffc1f == 0xd61f0000:
+		in.op, in.rn = emuBr, zr(rn)
+	case word&0xfffffc1f == 0xd63f0000:
+		in.op, in.rn = emuBlr, zr(rn)
+	case word&0xfffffc1f == 0xd65f0000:
+		in.op, in.rn = emuRet, zr(rn)
+	case word&0xffe0001f == 0xd4000001:
+		in.op = emuSvc
+	case word&0xff800000 == 0xf9000000: // ldr/str (unsigned offset)
+		in.op = emuStr
+		if word>>22&1 == 1 {
+			in.op = emuLdr
+		}
+		in.rd, in.rn, in.imm, in.mode = zr(rd), rn, int64(word>>10&0xfff)*8, modeOffset
+	case word&0xffa00000 == 0xf8000000: // ldur/stur, ldr/str (pre- and post-index)
+		mode := map[uint32]uint8{0: modeOffset, 1: modePost, 3: modePre}
+		m, ok := mode[word>>10&3]
+		if !ok {
+			return in
+		}
+		in.op = emuStr
+		if word>>22&1 == 1 {
+			in.op = emuLdr
+		}
+		in.rd, in.rn, in.imm, in.mode = zr(rd), rn, signExtend(uint64(word>>12&0x1ff), 9), m
+	case word&0xfe000000 == 0xa8000000: // ldp/stp
+		in.op = emuStp
+		if word>>22&1 == 1 {
+			in.op = emuLdp
+		}
+		in.mode = []uint8{modeOffset, modePost, modeOffset, modePre}[word>>23&3]
+		in.rd, in.ra, in.rn = zr(rd), zr(uint8(word>>10&31)), rn
+		in.imm = signExtend(uint64(word>>15&0x7f), 7) * 8
+	case sf && word&0x1f200000 == 0x0b000000: // add/sub (shifted register)
+		if word>>22&3 == 3 {
+			return in
+		}
+		in.op = emuAdd
+		if word>>30&1 == 1 {
+			in.op = emuSub
+		}
+		in.setFlags = word>>29&1 == 1
+		in.op2, in.shift, in.amount = op2Shift, uint8(word>>22&3), uint8(word>>10&63)
+		in.rd, in.rn, in.rm = zr(rd), zr(rn), zr(rm)
+	case sf && word&0x1fe00000 == 0x0b200000: // add/sub (extended register)
+		if word>>10&7 > 4 {
+			return in
+		}
+		in.op = emuAdd
+		if word>>30&1 == 1 {
+			in.op = emuSub
+		}
+		in.setFlags = word>>29&1 == 1
+		in.op2, in.shift, in.amount = op2Ext, uint8(word>>13&7), uint8(word>>10&7)
+		in.rd, in.rn, in.rm = rd, rn, zr(rm)
+		if in.setFlags {
+			in.rd = zr(rd)
+		}
+	case sf && word&0x1f000000 == 0x0a000000: // logical (shifted register)
+		in.op = []emuOp{emuAnd, emuOrr, emuEor, emuAnd}[word>>29&3]
+		in.setFlags = word>>29&3 == 3
+		in.invert = word>>21&1 == 1
+		in.op2, in.shift, in.amount = op2Shift, uint8(word>>22&3), uint8(word>>10&63)
+		in.rd, in.rn, in.rm = zr(rd), zr(rn), zr(rm)
+	case sf && word&0x7fe00000 == 0x1ac00000: // data-processing (2 source)
+		ops := map[uint32]emuOp{2: emuUdiv, 3: emuSdiv, 8: emuLslv, 9: emuLsrv, 10: emuAsrv, 11: emuRorv}
+		in.op = ops[word>>10&63]
+		in.rd, in.rn, in.rm = zr(rd), zr(rn), zr(rm)
+	case sf && word&0x7fe00000 == 0x1b000000: // madd, msub
+		in.op = emuMadd
+		if word>>15&1 == 1 {
+			in.op = emuMsub
+		}
+		in.rd, in.rn, in.rm, in.ra = zr(rd), zr(rn), zr(rm), zr(uint8(word>>10&31))
+	case sf && word&0x3fe00000 == 0x1a800000 && word>>11&1 == 0: // conditional select
+		in.op, in.cond = emuCsel, uint8(word>>12&15)
+		in.imm = int64(word>>30&1<<1 | word>>10&1)
+		in.rd, in.rn, in.rm = zr(rd), zr(rn), zr(rm)
+	}
+	return in
+}
+
+/* --- memory --- */
+
+const (
+	emuStackTop  = 0x7fff00000000
+	emuStackSize = 1 << 30
+	emuPageBits  = 16
+	emuPageSize  = 1 << emuPageBits
+	/* returning to this address ends a call (nothing is there) */
+	emuHaltAddr = 0x1000
+)
+
+/* the code, read only, and the stack, in pages allocated when touched */
+type emuMemory struct {
+	code     []byte
+	codeAddr uint64
+	pages    map[uint64][]byte
+	lastKey  uint64 // the page last used, which is most often used next
+	lastPage []byte
+}
+
+/* the page of the stack addr is in, or nil if it is not in the stack */
+func (mem *emuMemory) stackPage(addr uint64) []byte {
+	if addr < emuStackTop-emuStackSize || addr >= emuStackTop {
+		return nil
+	}
+	key := addr >> emuPageBits
+	if mem.lastPage != nil && key == mem.lastKey {
+		return mem.lastPage
+	}
+	page, ok := mem.pages[key]
+	if !ok {
+		page = make([]byte, emuPageSize)
+		mem.pages[key] = page
+	}
+	mem.lastKey, mem.lastPage = key, page
+	return page
+}
+
+func (mem *emuMemory) read8(addr uint64) (byte, bool) {
+	if page := mem.stackPage(addr); page != nil {
+		return page[addr&(emuPageSize-1)], true
+	}
+	if addr >= mem.codeAddr && addr-mem.codeAddr < uint64(len(mem.code)) {
+		return mem.code[addr-mem.codeAddr], true
+	}
+	return 0, false
+}
+
+func (mem *emuMemory) read64(addr uint64) (uint64, bool) {
+	if off := addr & (emuPageSize - 1); off <= emuPageSize-8 {
+		if page := mem.stackPage(addr); page != nil {
+			return binary.LittleEndian.Uint64(page[off:]), true
+		}
+	}
+	/* across two pages, or in the code */
+	v := uint64(0)
+	for i := uint64(0); i < 8; i++ {
+		b, ok := mem.read8(addr + i)
+		if !ok {
+			return 0, false
+		}
+		v |= uint64(b) << (8 * i)
+	}
+	return v, true
+}
+
+func (mem *emuMemory) write64(addr uint64, v uint64) bool {
+	if off := addr & (emuPageSize - 1); off <= emuPageSize-8 {
+		if page := mem.stackPage(addr); page != nil {
+			binary.LittleEndian.PutUint64(page[off:], v)
+			return true
+		}
+	}
+	for i := uint64(0); i < 8; i++ {
+		page := mem.stackPage(addr + i)
+		if page == nil {
+			return false
+		}
+		page[(addr+i)&(emuPageSize-1)] = byte(v >> (8 * i))
+	}
+	return true
+}
+
+/* --- the machine --- */
+
+type Machine struct {
+	x          [33]uint64 // x0 ... x30, sp, and the zero register (always 0)
+	pc         uint64
+	n, z, c, v bool
+	mem        *emuMemory
+	code       []emuInstr // the decoded mem.code
+	steps      uint64     // instructions executed
+	maxSteps   uint64     // stop after so many (0: no limit)
+	exited     bool       // by the exit system call
+	status     int64
+}
+
+/* an error executing, at the instruction it happened at */
+type emuFault struct {
+	pc    uint64
+	msg   string
+	limit bool // stopped after maxSteps instructions
+}
+
+func (e *emuFault) Error() string {
+	return fmt.Sprintf("emulator: %s at pc 0x%x", e.msg, e.pc)
+}
+
+func (m *Machine) fault(format string, args ...interface{}) {
+	panic(&emuFault{pc: m.pc, msg: fmt.Sprintf(format, args...)})
+}
+
+/* a machine with code at addr and an empty stack */
+func newMachine(code []byte, addr uint64) *Machine {
+	m := &Machine{mem: &emuMemory{code: code, codeAddr: addr, pages: make(map[uint64][]byte)}}
+	m.code = make([]emuInstr, len(code)/4)
+	for i := range m.code {
+		pc := addr + uint64(4*i)
+		m.code[i] = emuDecode(binary.LittleEndian.Uint32(code[4*i:]), pc)
+	}
+	m.x[emuSP] = emuStackTop
+	return m
+}
+
+/* the value of operand 2 */
+func (m *Machine) operand2(in *emuInstr) uint64 {
+	var v uint64
+	switch in.op2 {
+	case op2Imm:
+		v = uint64(in.imm)
+	case op2Shift:
+		v = m.x[in.rm]
+		switch in.shift {
+		case shiftLSL:
+			v <<= in.amount
+		case shiftLSR:
+			v >>= in.amount
+		case shiftASR:
+			v = uint64(int64(v) >> in.amount)
+		case shiftROR:
+			v = bits.RotateLeft64(v, -int(in.amount))
+		}
+	case op2Ext:
+		v = m.x[in.rm]
+		/* uxtb, uxth, uxtw, uxtx, sxtb, sxth, sxtw, sxtx */
+		size := uint(8) << (in.shift & 3)
+		if size < 64 {
+			if in.shift >= 4 {
+				v = uint64(signExtend(v, size))
+			} else {
+				v &= uint64(1)<<size - 1
+			}
+		}
+		v <<= in.amount
+	}
+	if in.invert {
+		v = ^v
+	}
+	return v
+}
+
+/* a + b + carry, setting the flags if asked */
+func (m *Machine) addWithCarry(a, b, carry uint64, setFlags bool) uint64 {
+	r, c := bits.Add64(a, b, carry)
+	if setFlags {
+		m.n, m.z, m.c = int64(r) < 0, r == 0, c == 1
+		m.v = ((a^r)&(b^r))>>63 == 1
+	}
+	return r
+}
+
+func (m *Machine) logicFlags(r uint64) {
+	m.n, m.z, m.c, m.v = int64(r) < 0, r == 0, false, false
+}
+
+/* does condition cond (eq, ne, ...) hold? */
+func (m *Machine) condHolds(cond uint8) bool {
+	var r bool
+	switch cond >> 1 {
+	case 0:
+		r = m.z
+	case 1:
+		r = m.c
+	case 2:
+		r = m.n
+	case 3:
+		r = m.v
+	case 4:
+		r = m.c && !m.z
+	case 5:
+		r = m.n == m.v
+	case 6:
+		r = m.n == m.v && !m.z
+	case 7:
+		return true
+	}
+	if cond&1 == 1 {
+		r = !r
+	}
+	return r
+}
+
+func (m *Machine) load(addr uint64) uint64 {
+	v, ok := m.mem.read64(addr)
+	if !ok {
+		m.accessFault("load from", addr)
+	}
+	return v
+}
+
+func (m *Machine) store(addr uint64, v uint64) {
+	if !m.mem.write64(addr, v) {
+		m.accessFault("store to", addr)
+	}
+}
+
+func (m *Machine) accessFault(access string, addr uint64) {
+	/* just below the stack: the stack is full */
+	if bottom := uint64(emuStackTop - emuStackSize); addr < bottom && addr >= bottom-emuPageSize {
+		m.fault("stack overflow (%s 0x%x)", access, addr)
+	}
+	m.fault("%s 0x%x", access, addr)
+}
+
+/* execute until the pc reaches emuHaltAddr or the program exits */
+func (m *Machine) run() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			f, ok := r.(*emuFault)
+			if !ok {
+				panic(r)
+			}
+			err = f
+		}
+	}()
+	for m.pc != emuHaltAddr && !m.exited {
+		i := (m.pc - m.mem.codeAddr) / 4
+		if m.pc%4 != 0 || m.pc < m.mem.codeAddr || i >= uint64(len(m.code)) {
+			m.fault("jump outside the code")
+		}
+		if m.maxSteps > 0 && m.steps >= m.maxSteps {
+			panic(&emuFault{m.pc, fmt.Sprintf("stopped after %d instructions", m.steps), true})
+		}
+		m.steps++
+		m.step(&m.code[i])
+		m.x[emuZR] = 0
+	}
+	return nil
+}
+
+func (m *Machine) step(in *emuInstr) {
+	next := m.pc + 4
+	x := &m.x
+	switch in.op {
+	case emuAdd:
+		x[in.rd] = m.addWithCarry(x[in.rn], m.operand2(in), 0, in.setFlags)
+	case emuSub:
+		x[in.rd] = m.addWithCarry(x[in.rn], ^m.operand2(in), 1, in.setFlags)
+	case emuAnd, emuOrr, emuEor:
+		a, b := x[in.rn], m.operand2(in)
+		r := a & b
+		if in.op == emuOrr {
+			r = a | b
+		} else if in.op == emuEor {
+			r = a ^ b
+		}
+		if in.setFlags {
+			m.logicFlags(r)
+		}
+		x[in.rd] = r
+	case emuMovz:
+		x[in.rd] = uint64(in.imm) << in.amount
+	case emuMovn:
+		x[in.rd] = ^(uint64(in.imm) << in.amount)
+	case emuMovk:
+		x[in.rd] = x[in.rd]&^(0xffff<<in.amount) | uint64(in.imm)<<in.amount
+	case emuAddr:
+		x[in.rd] = uint64(in.imm)
+	case emuB:
+		next = uint64(in.imm)
+	case emuBL:
+		x[30] = next
+		next = uint64(in.imm)
+	case emuBCond:
+		if m.condHolds(in.cond) {
+			next = uint64(in.imm)
+		}
+	case emuCbz, emuCbnz:
+		if (x[in.rd] == 0) == (in.op == emuCbz) {
+			next = uint64(in.imm)
+		}
+	case emuTbz, emuTbnz:
+		if (x[in.rd]>>in.amount&1 == 0) == (in.op == emuTbz) {
+			next = uint64(in.imm)
+		}
+	case emuBr, emuRet:
+		next = x[in.rn]
+	case emuBlr:
+		next = x[in.rn]
+		x[30] = m.pc + 4
+	case emuSvc:
+		/* exit and exit_group, the only system calls */
+		if x[8] != 93 && x[8] != 94 {
+			m.fault("system call %d not supported", x[8])
+		}
+		m.exited, m.status = true, int64(x[0])
+	case emuLdr, emuStr, emuLdp, emuStp:
+		base := x[in.rn]
+		addr := base
+		if in.mode != modePost {
+			addr += uint64(in.imm)
+		}
+		switch in.op {
+		case emuLdr:
+			x[in.rd] = m.load(addr)
+		case emuStr:
+			m.store(addr, x[in.rd])
+		case emuLdp:
+			x[in.rd], x[in.ra] = m.load(addr), m.load(addr+8)
+		case emuStp:
+			m.store(addr, x[in.rd])
+			m.store(addr+8, x[in.ra])
+		}
+		if in.mode != modeOffset {
+			x[in.rn] = base + uint64(in.imm)
+		}
+	case emuUdiv:
+		if x[in.rm] == 0 {
+			x[in.rd] = 0
+		} else {
+			x[in.rd] = x[in.rn] / x[in.rm]
+		}
+	case emuSdiv:
+		/* AArch64 divides by 0 giving 0, and MinInt64 / -1 giving MinInt64 */
+		a, b := int64(x[in.rn]), int64(x[in.rm])
+		switch {
+		case b == 0:
+			x[in.rd] = 0
+		case a == math.MinInt64 && b == -1:
+			x[in.rd] = uint64(a)
+		default:
+			x[in.rd] = uint64(a / b)
+		}
+	case emuLslv:
+		x[in.rd] = x[in.rn] << (x[in.rm] & 63)
+	case emuLsrv:
+		x[in.rd] = x[in.rn] >> (x[in.rm] & 63)
+	case emuAsrv:
+		x[in.rd] = uint64(int64(x[in.rn]) >> (x[in.rm] & 63))
+	case emuRorv:
+		x[in.rd] = bits.RotateLeft64(x[in.rn], -int(x[in.rm]&63))
+	case emuMadd:
+		x[in.rd] = x[in.ra] + x[in.rn]*x[in.rm]
+	case emuMsub:
+		x[in.rd] = x[in.ra] - x[in.rn]*x[in.rm]
+	case emuCsel:
+		if m.condHolds(in.cond) {
+			x[in.rd] = x[in.rn]
+			break
+		}
+		switch in.imm {
+		case 0:
+			x[in.rd] = x[in.rm]
+		case 1:
+			x[in.rd] = x[in.rm] + 1
+		case 2:
+			x[in.rd] = ^x[in.rm]
+		case 3:
+			x[in.rd] = -x[in.rm]
+		}
+	default:
+		word, _ := m.mem.read64(m.pc)
+		m.fault("undefined instruction 0x%08x", uint32(word))
+	}
+	m.pc = next
+}
+
+/*
+call the function at addr with args (the first eight in x0 ... x7,
+the others on the stack), returning x0
+*/
+func (m *Machine) call(addr uint64, args []int64) (int64, error) {
+	const nregs = 8
+	sp := uint64(emuStackTop)
+	if len(args) > nregs {
+		/* sp stays a multiple of 16 */
+		sp -= uint64((len(args)-nregs)*8+15) &^ 15
+	}
+	for i, a := range args {
+		if i < nregs {
+			m.x[i] = uint64(a)
+		} else if !m.mem.write64(sp+uint64(8*(i-nregs)), uint64(a)) {
+			return 0, &emuFault{pc: addr, msg: "no room for the arguments"}
+		}
+	}
+	m.x[emuSP] = sp
+	m.x[30] = emuHaltAddr
+	m.pc = addr
+	if err := m.run(); err != nil {
+		return 0, err
+	}
+	if m.exited {
+		return 0, &emuFault{pc: m.pc, msg: "exit called"}
+	}
+	return int64(m.x[0]), nil
+}
+
+/* link objects (read from files) and call function name with args */
+func run_objects(files []string, objs []*codegen.Object, name string, args []int64, maxSteps uint64) (int64, error) {
+	img, err := codegen.LinkImage(files, objs, codegen.LdBase)
+	if err != nil {
+		return 0, err
+	}
+	addr, ok := img.Globals[name]
+	if !ok {
+		return 0, fmt.Errorf("no function %s to call", name)
+	}
+	m := newMachine(img.Text, img.Addr)
+	m.maxSteps = maxSteps
+	return m.call(addr, args)
+}
+
+/* compile minC source (read from file) and call f with args on the emulator */
+func emu_source(src string, file string, opts *codegen.Options, args []int64, maxSteps uint64) (int64, error) {
+	res, err := compiler.Compile([]byte(src), compiler.Options{Options: *opts, Name: file}) // in minc_compile.go
+	if err != nil {
+		return 0, err
+	}
+	obj, err := codegen.Assemble(res.Code)
+	if err != nil {
+		return 0, err
+	}
+	return run_objects([]string{file}, []*codegen.Object{obj}, "f", args, maxSteps)
+}
+
+/* did a run stop because it took more steps than it was allowed? */
+func is_step_limit(err error) bool {
+	switch e := err.(type) {
+	case *emuFault:
+		return e.limit
+	case *interpError:
+		return e.limit
+	}
+	return false
+}
+
+/* --- test/main.c --- */
+
+/*
+the arguments test/main.c passes to f: nrand48 from the 48-bit
+state seed (its low 48 bits), 31-bit values
+*/
+func test_args(seed int64, n int) []int64 {
+	const mask = 1<<48 - 1
+	x := uint64(seed) & mask
+	args := make([]int64, n)
+	for i := range args {
+		x = (0x5deece66d*x + 0xb) & mask
+		args[i] = int64(x >> 17)
+	}
+	return args
+}
+
+/* the seed test/main.c uses for test number test_no */
+func test_seed(test_no int) int64 {
+	return 12345 + int64(test_no)
+}
+
+/* --- minc emu --- */
+
+/* a machine running an executable (minc_ld) from its entry point */
+func emu_executable(file string) (*Machine, error) {
+	f, err := elf.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if f.Class != elf.ELFCLASS64 || f.Machine != elf.EM_AARCH64 || f.Type != elf.ET_EXEC {
+		return nil, fmt.Errorf("%s: not an AArch64 executable", file)
+	}
+	var m *Machine
+	for _, p := range f.Progs {
+		if p.Type != elf.PT_LOAD {
+			continue
+		}
+		if p.Flags&elf.PF_X == 0 || m != nil {
+			return nil, fmt.Errorf("%s: only a single code segment is supported", file)
+		}
+		code := make([]byte, p.Filesz)
+		if _, err := p.ReadAt(code, 0); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		m = newMachine(code, p.Vaddr)
+	}
+	if m == nil {
+		return nil, fmt.Errorf("%s: no code", file)
+	}
+	/* argc = 0, and empty argv, envp and auxv */
+	m.x[emuSP] = emuStackTop - 64
+	m.pc = f.Entry
+	return m, nil
+}
+
+/*
+./minc emu [--seed=N] [--test-no=N] [--max-steps=N] [options] file ...
+run compiled code (see above). the seed of the arguments is that of
+test/main.c for test --test-no (0 by default) unless given.
+the other options are those of compiling (-O2, ...)
+*/
+func emu_main(args []string) {
+	test_no, seed, seed_set := 0, int64(0), false
+	max_steps := uint64(0)
+	var rest []string
+	for _, arg := range args {
+		var err error
+		switch {
+		case strings.HasPrefix(arg, "--seed="):
+			seed, err = strconv.ParseInt(strings.TrimPrefix(arg, "--seed="), 10, 64)
+			seed_set = true
+		case strings.HasPrefix(arg, "--test-no="):
+			test_no, err = strconv.Atoi(strings.TrimPrefix(arg, "--test-no="))
+		case strings.HasPrefix(arg, "--max-steps="):
+			max_steps, err = strconv.ParseUint(strings.TrimPrefix(arg, "--max-steps="), 10, 64)
+		default:
+			rest = append(rest, arg)
+		}
+		if err != nil {
+			fatal(diag.UsageErrorf("bad value in %s", arg))
+		}
+	}
+	if !seed_set {
+		seed = test_seed(test_no)
+	}
+	opts, files, err := parse_args(rest)
+	if err != nil {
+		fatal(err)
+	}
+	if len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "usage: %s emu [--seed=N] [--test-no=N] [--max-steps=N] [options] file ...\n", os.Args[0])
+		os.Exit(int(diag.Usage))
+	}
+
+	if len(files) == 1 && !strings.HasSuffix(files[0], ".s") && !strings.HasSuffix(files[0], ".o") &&
+		!strings.HasSuffix(files[0], ".c") && !strings.HasSuffix(files[0], ".xml") {
+		m, err := emu_executable(files[0])
+		if err != nil {
+			fatal(diag.InputError(err))
+		}
+		m.maxSteps = max_steps
+		if err := m.run(); err != nil {
+			fatal(err)
+		}
+		os.Exit(int(m.status & 0xff))
+	}
+
+	if err := check_object_target(opts, "emu"); err != nil {
+		fatal(err)
+	}
+	var objs []*codegen.Object
+	for _, file := range files {
+		obj, err := file_to_object(file, opts) // in minc.go
+		if err != nil {
+			fatal(err)
+		}
+		objs = append(objs, obj)
+	}
+	y, err := run_objects(files, objs, "f", test_args(seed, 12), max_steps)
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Printf("%d\n", y)
+}
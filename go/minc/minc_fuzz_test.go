@@ -0,0 +1,75 @@
+package main
+
+/* minc_fuzz_test
+
+   fuzz targets for the front ends, which go test runs on their
+   seeds (test/src, parser/ex.xml) and go test -fuzz on anything:
+
+     go test -fuzz=FuzzCToAst        the source parser (minc_cparse)
+     go test -fuzz=FuzzStrToAst      the XML parser (minc_parse)
+
+   a front end may reject what it is given, with an error, but must
+   not panic. a program the source parser accepts must also print
+   (String), and print as XML (ToXML), as a program it parses the
+   same.
+*/
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"minc/parse"
+)
+
+func FuzzCToAst(f *testing.F) {
+	files, _ := filepath.Glob(filepath.Join(*testSrc, "f*.c"))
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(src))
+	}
+	f.Add("long f(long x) { return -9223372036854775807 - 1; }")
+	f.Fuzz(func(t *testing.T, src string) {
+		program, err := parse.C(src, "fuzz.c")
+		if err != nil {
+			return
+		}
+		printed := program.String()
+		again, err := parse.C(printed, "printed.c")
+		if err != nil {
+			t.Fatalf("the program printed does not parse: %v\n%s", err, printed)
+		}
+		if s := again.String(); s != printed {
+			t.Fatalf("the program printed parses as another:\n%s\nprints as\n%s", printed, s)
+		}
+		xml := parse.ToXML(program)
+		again, err = parse.XML(xml, "printed.xml")
+		if err != nil {
+			t.Fatalf("the XML of the program does not parse: %v\n%s", err, xml)
+		}
+		if s := again.String(); s != printed {
+			t.Fatalf("the XML of the program parses as another:\n%s\nprints as\n%s", printed, s)
+		}
+	})
+}
+
+func FuzzStrToAst(f *testing.F) {
+	root := filepath.Dir(filepath.Dir(*testSrc))
+	ex, err := os.ReadFile(filepath.Join(root, "parser", "ex.xml"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(string(ex))
+	f.Add("<program/>")
+	f.Add("<program><fun_def><name>f</name><params/><return_type><primitive_type>long</primitive_type></return_type>" +
+		"<body><return><un_op><op>-</op><arg><int_literal>1</int_literal></arg></un_op></return></body></fun_def></program>")
+	f.Fuzz(func(t *testing.T, s string) {
+		program, err := parse.XML(s, "fuzz.xml")
+		if err == nil && program == nil {
+			t.Fatalf("no program, and no error")
+		}
+	})
+}
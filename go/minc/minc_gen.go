@@ -0,0 +1,755 @@
+package main
+
+/* minc_gen
+
+   a generator of random minC programs, to test the compiler on more
+   combinations of operators and statements than test/src has (in
+   the manner of Csmith):
+
+     ./minc gen [--seed=N]           print a program
+     ./minc fuzz [--seed=N] [--count=N] [--levels=0,1,2] [options]
+                                     compile programs and check them
+
+   a program is functions g0, g1, ... and f, the function test/main.c
+   calls; a function calls only those before it, so nothing recurses.
+   every loop counts to a small bound with a counter of its own that
+   nothing else assigns, and every variable is assigned before it is
+   read: the programs end.
+
+   expressions are mostly a few operators deep; some (genDeepPercent)
+   are up to genMaxDeepExpr deep along one operand, more than a code
+   generator has registers or slots for, and call arguments are
+   sometimes calls themselves.
+
+   the programs are also well defined C. an operation that might
+   overflow or divide by zero (+, -, *, /, %, <<, >> and unary -)
+   is written as is only when the ranges of its operands (which the
+   generator follows, as intervals) show it cannot; otherwise it is a
+   call of one of the safe_ functions every program starts with,
+   which check first and return their first operand instead. the
+   generator also follows which expressions are int in C (1 + 2,
+   a < b) rather than long, so that gcc can be the reference too.
+
+   minc fuzz runs each program with the interpreter (minc_interp)
+   and compiled at each level on the emulator (minc_emu), with the
+   arguments test/main.c gives test --seed; a program whose results
+   differ, or that fails to compile, is reduced (minc_reduce) and
+   printed.
+*/
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+
+	"minc/ast"
+	"minc/codegen"
+	"minc/diag"
+	"minc/parse"
+)
+
+/* the functions that keep the other operations well defined */
+const genSafeFuns = `
+long safe_add(long a, long b) {
+  if (b > 0 && a > 9223372036854775807 - b) return a;
+  if (b < 0 && a < -9223372036854775807 - 1 - b) return a;
+  return a + b;
+}
+long safe_sub(long a, long b) {
+  if (b < 0 && a > 9223372036854775807 + b) return a;
+  if (b > 0 && a < -9223372036854775807 - 1 + b) return a;
+  return a - b;
+}
+long safe_mul(long a, long b) {
+  if (a > 0 && b > 0 && a > 9223372036854775807 / b) return a;
+  if (a > 0 && b < 0 && b < (-9223372036854775807 - 1) / a) return a;
+  if (a < 0 && b > 0 && a < (-9223372036854775807 - 1) / b) return a;
+  if (a < 0 && b < 0 && a < 9223372036854775807 / b) return a;
+  return a * b;
+}
+long safe_div(long a, long b) {
+  if (b == 0 || (a == -9223372036854775807 - 1 && b == -1)) return a;
+  return a / b;
+}
+long safe_mod(long a, long b) {
+  if (b == 0 || (a == -9223372036854775807 - 1 && b == -1)) return a;
+  return a % b;
+}
+long safe_shl(long a, long b) {
+  if (a < 0 || b < 0 || b > 63 || a > 9223372036854775807 >> b) return a;
+  return a << b;
+}
+long safe_shr(long a, long b) {
+  if (b < 0 || b > 63) return a;
+  return a >> b;
+}
+`
+
+/* the safe_ function of each operator */
+var genSafeOps = map[string]string{
+	"+": "safe_add", "-": "safe_sub", "*": "safe_mul", "/": "safe_div",
+	"%": "safe_mod", "<<": "safe_shl", ">>": "safe_shr",
+}
+
+/*
+the values an expression may have: lo ... hi, and whether it is an
+int in C, where it is long in minC (1 << 40 is undefined in C)
+*/
+type genRange struct {
+	lo, hi int64
+	cint   bool
+}
+
+var genAnyValue = genRange{math.MinInt64, math.MaxInt64, false}
+
+func (r genRange) nonNegative() bool {
+	return r.lo >= 0
+}
+
+/* a function generated: callable by those after it */
+type genFun struct {
+	name   string
+	params int
+}
+
+/* a variable in scope; a loop counter is assigned by its loop only */
+type genVar struct {
+	name    string
+	counter bool
+}
+
+type generator struct {
+	rnd       *rand.Rand
+	funs      []*genFun
+	vars      []genVar
+	nvars     int // variables named so far, for fresh names
+	loopDepth int
+	stmtDepth int
+}
+
+const (
+	genMaxExprDepth = 4
+	genMaxDeepExpr  = 24 // how deep a deep expression may be
+	genDeepPercent  = 10 // of the expressions of statements
+	genMaxParams    = 9  // of g0, g1, ... (the ninth is passed on the stack)
+	genMaxStmtDepth = 3
+	genMaxLoopDepth = 2
+	genMaxLoopCount = 8
+)
+
+func (g *generator) chance(percent int) bool {
+	return g.rnd.Intn(100) < percent
+}
+
+func (g *generator) freshName(prefix string) string {
+	g.nvars++
+	return fmt.Sprintf("%s%d", prefix, g.nvars)
+}
+
+/* --- expressions --- */
+
+/* a literal, often one of those at the edges of what code generators handle */
+func (g *generator) literal() int64 {
+	switch g.rnd.Intn(6) {
+	case 0:
+		return []int64{0, 1, 2, -1, 3, 7, 8, 255, 256, 4095, 4096, 65535, 65536}[g.rnd.Intn(13)]
+	case 1:
+		k := g.rnd.Intn(63)
+		return int64(1)<<uint(k) - int64(g.rnd.Intn(2))
+	case 2:
+		return -int64(g.rnd.Intn(1 << 20))
+	case 3:
+		return g.rnd.Int63() >> uint(g.rnd.Intn(63))
+	case 4:
+		return []int64{math.MaxInt64, math.MinInt64 + 1, 1 << 32, -(1 << 32), 0x12345678}[g.rnd.Intn(5)]
+	}
+	return int64(g.rnd.Intn(100))
+}
+
+func genLiteral(v int64) (ast.Expr, genRange) {
+	cint := v > math.MinInt32 && v <= math.MaxInt32
+	if v < 0 {
+		/* -v is how the parser reads it back */
+		return &ast.ExprOp{Op: "-", Args: []ast.Expr{&ast.ExprIntLiteral{Val: -v}}}, genRange{v, v, cint}
+	}
+	return &ast.ExprIntLiteral{Val: v}, genRange{v, v, cint}
+}
+
+/* does the result overflow an int in C? */
+func (r genRange) overflowsInt() bool {
+	return r.cint && (r.lo < math.MinInt32 || r.hi > math.MaxInt32)
+}
+
+func paren(e ast.Expr) ast.Expr {
+	switch e.(type) {
+	case *ast.ExprIntLiteral, *ast.ExprId, *ast.ExprCall, *ast.ExprParen:
+		return e
+	}
+	return &ast.ExprParen{SubExpr: e}
+}
+
+/* the variables that may be assigned */
+func (g *generator) assignable() []string {
+	names := []string{}
+	for _, v := range g.vars {
+		if !v.counter {
+			names = append(names, v.name)
+		}
+	}
+	return names
+}
+
+/* a random expression, and the values it may have */
+func (g *generator) expr(depth int) (ast.Expr, genRange) {
+	if depth >= genMaxExprDepth || g.chance(25) {
+		if len(g.vars) > 0 && g.chance(70) {
+			return &ast.ExprId{Name: g.vars[g.rnd.Intn(len(g.vars))].name}, genAnyValue
+		}
+		return genLiteral(g.literal())
+	}
+	switch n := g.rnd.Intn(20); {
+	case n < 2 && len(g.funs) > 0:
+		return g.call(depth), genAnyValue
+	case n < 5:
+		return g.unary(depth)
+	}
+	return g.binary(depth)
+}
+
+/* a call of a function generated before; an argument may be a call too */
+func (g *generator) call(depth int) ast.Expr {
+	fun := g.funs[g.rnd.Intn(len(g.funs))]
+	args := []ast.Expr{}
+	for i := 0; i < fun.params; i++ {
+		var arg ast.Expr
+		if depth < genMaxExprDepth && g.chance(20) {
+			arg = g.call(depth + 1)
+		} else {
+			arg, _ = g.expr(depth + 1)
+		}
+		args = append(args, arg)
+	}
+	return &ast.ExprCall{Fun: &ast.ExprId{Name: fun.name}, Args: args}
+}
+
+/* the expression of a statement: mostly as expr, sometimes deep */
+func (g *generator) stmtExpr() (ast.Expr, genRange) {
+	if g.chance(genDeepPercent) {
+		return g.deep(genMaxExprDepth + g.rnd.Intn(genMaxDeepExpr-genMaxExprDepth+1))
+	}
+	return g.expr(0)
+}
+
+/*
+an expression n binary operators deep along one of their operands,
+the other operands shallow. the deep one is mostly the right one,
+which is computed while the left one waits pushed
+*/
+func (g *generator) deep(n int) (ast.Expr, genRange) {
+	if n == 0 {
+		return g.expr(genMaxExprDepth - 1)
+	}
+	op := genBinaryOps[g.rnd.Intn(len(genBinaryOps))]
+	x, rx := g.expr(genMaxExprDepth - 1)
+	y, ry := g.deep(n - 1)
+	if g.chance(20) {
+		x, rx, y, ry = y, ry, x, rx
+	}
+	return g.binaryOp(op, x, rx, y, ry)
+}
+
+func (g *generator) unary(depth int) (ast.Expr, genRange) {
+	e, r := g.expr(depth + 1)
+	switch g.rnd.Intn(4) {
+	case 0:
+		return &ast.ExprOp{Op: "!", Args: []ast.Expr{paren(e)}}, genRange{0, 1, true}
+	case 1:
+		return &ast.ExprOp{Op: "~", Args: []ast.Expr{paren(e)}}, genRange{^r.hi, ^r.lo, r.cint}
+	case 2:
+		neg := genRange{-r.hi, -r.lo, r.cint}
+		if r.lo == math.MinInt64 || neg.overflowsInt() {
+			return g.safeCall("-", &ast.ExprIntLiteral{Val: 0}, e), genAnyValue
+		}
+		return &ast.ExprOp{Op: "-", Args: []ast.Expr{paren(e)}}, neg
+	}
+	return &ast.ExprOp{Op: "+", Args: []ast.Expr{paren(e)}}, r
+}
+
+func (g *generator) safeCall(op string, x, y ast.Expr) ast.Expr {
+	return &ast.ExprCall{Fun: &ast.ExprId{Name: genSafeOps[op]}, Args: []ast.Expr{x, y}}
+}
+
+var genBinaryOps = []string{
+	"+", "-", "*", "/", "%", "<<", ">>", "&", "|", "^",
+	"==", "!=", "<", "<=", ">", ">=", "&&", "||",
+}
+
+func (g *generator) binary(depth int) (ast.Expr, genRange) {
+	op := genBinaryOps[g.rnd.Intn(len(genBinaryOps))]
+	x, rx := g.expr(depth + 1)
+	var y ast.Expr
+	var ry genRange
+	switch op {
+	case "/", "%", "<<", ">>":
+		/* mostly by a constant, which the range then shows to be fine */
+		if g.chance(60) {
+			if op == "<<" || op == ">>" {
+				y, ry = genLiteral(int64(g.rnd.Intn(64)))
+			} else {
+				y, ry = genLiteral(g.literal())
+			}
+			break
+		}
+		fallthrough
+	default:
+		y, ry = g.expr(depth + 1)
+	}
+	return g.binaryOp(op, x, rx, y, ry)
+}
+
+/* x op y, or a safe_ call if x op y might be undefined */
+func (g *generator) binaryOp(op string, x ast.Expr, rx genRange, y ast.Expr, ry genRange) (ast.Expr, genRange) {
+	r, ok := binaryRange(op, rx, ry)
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=", "&&", "||":
+		r.cint = true
+	case "<<", ">>":
+		/* an int shifted by 32 or more is undefined too */
+		r.cint = rx.cint
+		ok = ok && !(rx.cint && ry.lo > 31)
+	default:
+		r.cint = rx.cint && ry.cint
+	}
+	if !ok || (genSafeOps[op] != "" && r.overflowsInt()) {
+		return g.safeCall(op, x, y), genAnyValue
+	}
+	return &ast.ExprOp{Op: op, Args: []ast.Expr{paren(x), paren(y)}}, r
+}
+
+/* the sum, if it does not overflow */
+func addRange(a, b int64) (int64, bool) {
+	sum := a + b
+	return sum, (a < 0) != (b < 0) || (sum < 0) == (a < 0)
+}
+
+/* the product, if it does not overflow */
+func mulRange(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	p := a * b
+	if p/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return 0, false
+	}
+	return p, true
+}
+
+/* the smallest 2^k - 1 not below v (v >= 0) */
+func bitsRange(v int64) int64 {
+	return int64(uint64(1)<<uint(bits.Len64(uint64(v))) - 1)
+}
+
+/*
+the values x op y may have when x and y have the values of rx and
+ry; false when the operation might be undefined (overflow, division
+by zero, ...). whether it is an int is up to the caller
+*/
+func binaryRange(op string, rx, ry genRange) (genRange, bool) {
+	switch op {
+	case "+", "-":
+		if op == "-" {
+			if ry.lo == math.MinInt64 {
+				return genRange{}, false
+			}
+			ry = genRange{-ry.hi, -ry.lo, false}
+		}
+		lo, ok1 := addRange(rx.lo, ry.lo)
+		hi, ok2 := addRange(rx.hi, ry.hi)
+		return genRange{lo, hi, false}, ok1 && ok2
+	case "*":
+		r := genRange{math.MaxInt64, math.MinInt64, false}
+		for _, a := range []int64{rx.lo, rx.hi} {
+			for _, b := range []int64{ry.lo, ry.hi} {
+				p, ok := mulRange(a, b)
+				if !ok {
+					return genRange{}, false
+				}
+				if p < r.lo {
+					r.lo = p
+				}
+				if p > r.hi {
+					r.hi = p
+				}
+			}
+		}
+		return r, true
+	case "/", "%":
+		/* by a constant other than 0 and -1 */
+		if ry.lo != ry.hi || ry.lo == 0 || ry.lo == -1 {
+			return genRange{}, false
+		}
+		d := ry.lo
+		if op == "/" {
+			a, b := rx.lo/d, rx.hi/d
+			if a > b {
+				a, b = b, a
+			}
+			return genRange{a, b, false}, true
+		}
+		if d < 0 {
+			d = -d
+		}
+		lo, hi := -(d - 1), d-1
+		if rx.lo >= 0 {
+			lo = 0
+		}
+		if rx.hi <= 0 {
+			hi = 0
+		}
+		return genRange{lo, hi, false}, true
+	case "<<":
+		/* a value that is not negative by a constant, not overflowing */
+		if ry.lo != ry.hi || ry.lo < 0 || ry.lo > 63 || !rx.nonNegative() || rx.hi > math.MaxInt64>>uint(ry.lo) {
+			return genRange{}, false
+		}
+		return genRange{rx.lo << uint(ry.lo), rx.hi << uint(ry.lo), false}, true
+	case ">>":
+		if ry.lo != ry.hi || ry.lo < 0 || ry.lo > 63 {
+			return genRange{}, false
+		}
+		return genRange{rx.lo >> uint(ry.lo), rx.hi >> uint(ry.lo), false}, true
+	case "&":
+		switch {
+		case rx.nonNegative() && ry.nonNegative():
+			return genRange{0, min64(rx.hi, ry.hi), false}, true
+		case rx.nonNegative():
+			return genRange{0, rx.hi, false}, true
+		case ry.nonNegative():
+			return genRange{0, ry.hi, false}, true
+		}
+		return genAnyValue, true
+	case "|", "^":
+		if rx.nonNegative() && ry.nonNegative() {
+			return genRange{0, bitsRange(max64(rx.hi, ry.hi)), false}, true
+		}
+		return genAnyValue, true
+	}
+	/* comparisons, && and || */
+	return genRange{0, 1, false}, true
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+/* --- statements --- */
+
+/* a block of statements, with variables of its own */
+func (g *generator) block(n int) *ast.StmtCompound {
+	scope := len(g.vars)
+	g.stmtDepth++
+	blk := &ast.StmtCompound{Decls: []*ast.Decl{}, Stmts: []ast.Stmt{}}
+	for i := g.rnd.Intn(3); i > 0; i-- {
+		name := g.freshName("v")
+		blk.Decls = append(blk.Decls, &ast.Decl{VarType: &ast.TypePrimitive{Name: "long"}, Name: name})
+		/* assigned before anything reads it */
+		e, _ := g.stmtExpr()
+		blk.Stmts = append(blk.Stmts, &ast.StmtExpr{Expr: &ast.ExprOp{Op: "=", Args: []ast.Expr{&ast.ExprId{Name: name}, e}}})
+		g.vars = append(g.vars, genVar{name: name})
+	}
+	for i := 0; i < n; i++ {
+		blk.Stmts = append(blk.Stmts, g.stmt(blk)...)
+	}
+	g.stmtDepth--
+	g.vars = g.vars[:scope]
+	return blk
+}
+
+/* a random statement, or a few, in blk (which declares their counters) */
+func (g *generator) stmt(blk *ast.StmtCompound) []ast.Stmt {
+	n := g.rnd.Intn(100)
+	nested := g.stmtDepth < genMaxStmtDepth
+	switch {
+	case n < 30 || !nested && n < 70:
+		if names := g.assignable(); len(names) > 0 {
+			e, _ := g.stmtExpr()
+			return []ast.Stmt{&ast.StmtExpr{Expr: &ast.ExprOp{Op: "=", Args: []ast.Expr{&ast.ExprId{Name: names[g.rnd.Intn(len(names))]}, e}}}}
+		}
+		fallthrough
+	case n < 35 || !nested:
+		/* long x = e; */
+		name := g.freshName("v")
+		e, _ := g.stmtExpr()
+		g.vars = append(g.vars, genVar{name: name})
+		return []ast.Stmt{&ast.StmtDeclInit{Decl: &ast.Decl{VarType: &ast.TypePrimitive{Name: "long"}, Name: name}, Init: e}}
+	case n < 55:
+		cond, _ := g.stmtExpr()
+		s := &ast.StmtIf{Cond: cond, ThenStmt: g.block(1 + g.rnd.Intn(3)), ElseStmt: nil}
+		if g.chance(50) {
+			s.ElseStmt = g.block(1 + g.rnd.Intn(3))
+		}
+		return []ast.Stmt{s}
+	case n < 70 && g.loopDepth < genMaxLoopDepth:
+		return g.loop(blk)
+	case n < 78 && g.loopDepth > 0:
+		cond, _ := g.stmtExpr()
+		jump := ast.Stmt(&ast.StmtBreak{})
+		if g.chance(50) {
+			jump = &ast.StmtContinue{}
+		}
+		return []ast.Stmt{&ast.StmtIf{Cond: cond, ThenStmt: jump, ElseStmt: nil}}
+	case n < 83:
+		e, _ := g.stmtExpr()
+		cond, _ := g.stmtExpr()
+		return []ast.Stmt{&ast.StmtIf{Cond: cond, ThenStmt: &ast.StmtReturn{Expr: e}, ElseStmt: nil}}
+	case n < 88 && len(g.funs) > 0:
+		return []ast.Stmt{&ast.StmtExpr{Expr: g.call(0)}}
+	case n < 95:
+		return []ast.Stmt{g.block(1 + g.rnd.Intn(3))}
+	}
+	return []ast.Stmt{&ast.StmtEmpty{}}
+}
+
+/*
+for (i = 0; i < n; i = i + 1) { ... }
+or i = 0; while (i < n) { i = i + 1; ... }
+*/
+func (g *generator) loop(blk *ast.StmtCompound) []ast.Stmt {
+	i := g.freshName("i")
+	blk.Decls = append(blk.Decls, &ast.Decl{VarType: &ast.TypePrimitive{Name: "long"}, Name: i})
+	count := &ast.ExprIntLiteral{Val: int64(1 + g.rnd.Intn(genMaxLoopCount))}
+	zero := &ast.StmtExpr{Expr: &ast.ExprOp{Op: "=", Args: []ast.Expr{&ast.ExprId{Name: i}, &ast.ExprIntLiteral{Val: 0}}}}
+	cond := &ast.ExprOp{Op: "<", Args: []ast.Expr{&ast.ExprId{Name: i}, count}}
+	incr := &ast.StmtExpr{Expr: &ast.ExprOp{Op: "=", Args: []ast.Expr{&ast.ExprId{Name: i}, &ast.ExprOp{Op: "+", Args: []ast.Expr{&ast.ExprId{Name: i}, &ast.ExprIntLiteral{Val: 1}}}}}}
+
+	g.vars = append(g.vars, genVar{name: i, counter: true})
+	g.loopDepth++
+	body := g.block(1 + g.rnd.Intn(4))
+	g.loopDepth--
+	g.vars = g.vars[:len(g.vars)-1]
+	if g.chance(50) {
+		return []ast.Stmt{&ast.StmtFor{Init: zero, Cond: cond, Post: incr, Body: body}}
+	}
+	/* counting first, so that continue does not skip it */
+	body.Stmts = append([]ast.Stmt{incr}, body.Stmts...)
+	return []ast.Stmt{zero, &ast.StmtWhile{Cond: cond, Body: body}}
+}
+
+/* a function of params parameters (prefix0, prefix1, ...) */
+func (g *generator) function(name string, prefix string, params int) *ast.DefFun {
+	g.vars = g.vars[:0]
+	decls := []*ast.Decl{}
+	for i := 0; i < params; i++ {
+		p := fmt.Sprintf("%s%d", prefix, i)
+		decls = append(decls, &ast.Decl{VarType: &ast.TypePrimitive{Name: "long"}, Name: p})
+		g.vars = append(g.vars, genVar{name: p})
+	}
+	body := g.block(2 + g.rnd.Intn(5))
+	e, _ := g.stmtExpr()
+	body.Stmts = append(body.Stmts, &ast.StmtReturn{Expr: e})
+	return &ast.DefFun{Name: name, Params: decls, ReturnType: &ast.TypePrimitive{Name: "long"}, Body: body}
+}
+
+/* the program of a seed: the safe_ functions, g0, g1, ..., then f */
+func gen_program(seed int64) *ast.Program {
+	program, err := parse.C(genSafeFuns, "safe")
+	if err != nil {
+		panic(err)
+	}
+	g := &generator{rnd: rand.New(rand.NewSource(seed))}
+	for i := g.rnd.Intn(4); i > 0; i-- {
+		name := fmt.Sprintf("g%d", len(g.funs))
+		fun := g.function(name, "p", g.rnd.Intn(genMaxParams+1))
+		program.Defs = append(program.Defs, fun)
+		g.funs = append(g.funs, &genFun{name, len(fun.Params)})
+	}
+	program.Defs = append(program.Defs, g.function("f", "a", 1+g.rnd.Intn(12)))
+	return program
+}
+
+/* --- minc gen, minc fuzz --- */
+
+/* --name=value options of gen and fuzz; the others are returned */
+func gen_flags(args []string, ints map[string]*int64, strs map[string]*string) []string {
+	rest := []string{}
+	for _, arg := range args {
+		name, val, found := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+		if p, ok := ints[name]; ok && found && strings.HasPrefix(arg, "--") {
+			v, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				fatal(diag.UsageErrorf("bad value in %s", arg))
+			}
+			*p = v
+		} else if p, ok := strs[name]; ok && found && strings.HasPrefix(arg, "--") {
+			*p = val
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+	return rest
+}
+
+/*
+./minc gen [--seed=N]
+print the program of a seed
+*/
+func gen_main(args []string) {
+	seed := int64(1)
+	rest := gen_flags(args, map[string]*int64{"seed": &seed}, nil)
+	if len(rest) > 0 {
+		fmt.Fprintf(os.Stderr, "usage: %s gen [--seed=N]\n", os.Args[0])
+		os.Exit(int(diag.Usage))
+	}
+	fmt.Println(gen_program(seed).String())
+}
+
+/* the steps a run of a generated program may take */
+const fuzzMaxSteps = 10000000
+
+/*
+what is wrong with program compiled with opts ("" if nothing):
+its result differs from the interpreter's, or it does not compile.
+programs the interpreter rejects, or runs too long, are not wrong
+*/
+func fuzz_check(program *ast.Program, opts *codegen.Options, args []int64) (what string) {
+	/* the compiler crashing is wrong too */
+	defer func() {
+		if r := recover(); r != nil {
+			what = fmt.Sprintf("minc panics: %v", r)
+		}
+	}()
+	src := program.String()
+	parsed, err := parse.C(src, "fuzz.c")
+	if err != nil {
+		return fmt.Sprintf("does not parse back: %v", err)
+	}
+	want, err := interpret(parsed, "f", args, fuzzMaxSteps)
+	if err != nil {
+		return ""
+	}
+	got, err := emu_source(src, "fuzz.c", opts, args, 10*fuzzMaxSteps)
+	switch {
+	case is_step_limit(err):
+		return ""
+	case err != nil:
+		return err.Error()
+	case got != want:
+		return fmt.Sprintf("f returned %d, want %d", got, want)
+	}
+	return ""
+}
+
+/*
+do two failures fuzz_check reports look like the same bug? so that
+reducing a wrong result does not end in, say, a call of a function
+removed, which fails to link. where the emulator stops may move
+*/
+func fuzzSameFailure(what, orig string) bool {
+	const wrong = "f returned "
+	if strings.HasPrefix(orig, wrong) {
+		return strings.HasPrefix(what, wrong)
+	}
+	what, _, _ = strings.Cut(what, " at pc ")
+	orig, _, _ = strings.Cut(orig, " at pc ")
+	return what == orig
+}
+
+/* opts at each of levels ("0,1,2") */
+func fuzz_level_options(opts *codegen.Options, levels string) []*codegen.Options {
+	var level_opts []*codegen.Options
+	for _, l := range strings.Split(levels, ",") {
+		o := *opts
+		level, err := strconv.Atoi(l)
+		if err == nil && (level < 0 || level > 2) {
+			err = fmt.Errorf("no level %d", level)
+		}
+		if err == nil {
+			o.Level = level
+			o.Pipeline, err = codegen.ResolvePasses(level, o.PassFlags)
+		}
+		if err != nil {
+			fatal(diag.UsageErrorf("bad --levels: %v", err))
+		}
+		level_opts = append(level_opts, &o)
+	}
+	return level_opts
+}
+
+/*
+./minc fuzz [--seed=N] [--count=N] [--levels=0,1,2] [options]
+check the programs of seeds N, N+1, ... (count of them), compiled at
+each level with the options; exits with 1 if any was wrong
+*/
+func fuzz_main(args []string) {
+	seed, count, levels := int64(1), int64(100), "0,1,2"
+	rest := gen_flags(args, map[string]*int64{"seed": &seed, "count": &count}, map[string]*string{"levels": &levels})
+	/* the programs are full of if (1) return ...; */
+	opts, files, err := parse_args(append([]string{"-fno-pass=warn-unreachable"}, rest...))
+	if err != nil {
+		fatal(err)
+	}
+	if len(files) > 0 {
+		fmt.Fprintf(os.Stderr, "usage: %s fuzz [--seed=N] [--count=N] [--levels=0,1,2] [options]\n", os.Args[0])
+		os.Exit(int(diag.Usage))
+	}
+	if err := check_object_target(opts, "fuzz"); err != nil {
+		fatal(err)
+	}
+	level_opts := fuzz_level_options(&opts.Options.Options, levels)
+
+	failed, skipped := 0, 0
+	for s := seed; s < seed+count; s++ {
+		fun_args := test_args(s, 12)
+		/* the programs are meant to run, and not for long */
+		_, err := interpret(gen_program(s), "f", fun_args, fuzzMaxSteps)
+		if is_step_limit(err) {
+			skipped++
+			continue
+		} else if err != nil {
+			failed++
+			fmt.Printf("seed %d: the interpreter rejects it: %v\n", s, err)
+			continue
+		}
+		for _, o := range level_opts {
+			program := gen_program(s)
+			what := fuzz_check(program, o, fun_args)
+			if what == "" {
+				continue
+			}
+			failed++
+			fmt.Printf("seed %d, -O%d: %s\n", s, o.Level, what)
+			reduced := reduce_program(program, func(p *ast.Program) bool {
+				return fuzzSameFailure(fuzz_check(p, o, fun_args), what)
+			})
+			fmt.Printf("reduced (minc run prog.c -- %s):\n%s\n\n", argsText(fun_args), reduced.String())
+			break
+		}
+	}
+	fmt.Printf("%d programs, %d wrong, %d running too long\n", count, failed, skipped)
+	if failed > 0 {
+		os.Exit(int(diag.Compile))
+	}
+}
+
+/* the arguments as minc run takes them */
+func argsText(args []int64) string {
+	s := make([]string, len(args))
+	for i, a := range args {
+		s[i] = strconv.FormatInt(a, 10)
+	}
+	return strings.Join(s, " ")
+}
@@ -0,0 +1,452 @@
+package main
+
+/* minc_interp
+
+   an interpreter of the AST, the reference the code generator can
+   be tested against without any AArch64 machine or toolchain:
+
+     ./minc run f20.c                call f as test/main.c does
+     ./minc run f20.c -- 1 2 3       call f(1, 2, 3)
+
+   it gives C long its meaning on a 64-bit two's complement machine
+   (arithmetic wraps around, / and % truncate toward zero, >> is
+   arithmetic) and stops, with an error, where C leaves the
+   behavior undefined and the compiled code would just do something:
+
+     division (or %) by zero, and LONG_MIN / -1
+     shifting by a negative amount or by 64 or more
+     reading a variable before anything is assigned to it
+     calling a function that is not defined, or with too few arguments
+
+   blocks have their own variables as in C (a declaration hides
+   that of an enclosing block or parameter). a function that ends
+   without return returns 0.
+*/
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"minc/ast"
+	"minc/diag"
+)
+
+/* a run time error, panicked by the interpreter and recovered by interpret */
+type interpError struct {
+	fun   string
+	msg   string
+	limit bool // stopped after maxSteps steps
+}
+
+func (e *interpError) Error() string {
+	return fmt.Sprintf("%s: %s", e.fun, e.msg)
+}
+
+/* a variable, and whether anything has been assigned to it */
+type interpVar struct {
+	val  int64
+	init bool
+}
+
+/* a function being executed: its blocks' variables, innermost last */
+type interpFrame struct {
+	fun    *ast.DefFun
+	scopes []map[string]*interpVar
+	ret    int64
+}
+
+/* how a statement ends: normally, or by jumping */
+type interpFlow int
+
+const (
+	flowNext interpFlow = iota
+	flowBreak
+	flowContinue
+	flowReturn
+)
+
+type Interp struct {
+	funs     map[string]*ast.DefFun
+	addrs    map[string]int64 // what a function is as a value (g != 0): not 0
+	frame    *interpFrame
+	steps    uint64 // statements and expressions evaluated
+	maxSteps uint64 // stop after so many (0: no limit)
+}
+
+func newInterp(program *ast.Program) *Interp {
+	ip := &Interp{funs: make(map[string]*ast.DefFun), addrs: make(map[string]int64)}
+	for i, def := range program.Defs {
+		if fun, ok := def.(*ast.DefFun); ok {
+			ip.funs[fun.Name] = fun
+			ip.addrs[fun.Name] = int64(i+1) * 16
+		}
+	}
+	return ip
+}
+
+func (ip *Interp) errorf(format string, args ...interface{}) {
+	name := ""
+	if ip.frame != nil {
+		name = ip.frame.fun.Name
+	}
+	panic(&interpError{fun: name, msg: fmt.Sprintf(format, args...)})
+}
+
+func (ip *Interp) step() {
+	ip.steps++
+	if ip.maxSteps > 0 && ip.steps > ip.maxSteps {
+		panic(&interpError{ip.frame.fun.Name, fmt.Sprintf("stopped after %d steps", ip.maxSteps), true})
+	}
+}
+
+/* the variable a name refers to in the current function, or nil */
+func (ip *Interp) lookup(name string) *interpVar {
+	scopes := ip.frame.scopes
+	for i := len(scopes) - 1; i >= 0; i-- {
+		if v, ok := scopes[i][name]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+func (ip *Interp) declare(name string) *interpVar {
+	v := &interpVar{}
+	ip.frame.scopes[len(ip.frame.scopes)-1][name] = v
+	return v
+}
+
+func (ip *Interp) enterScope() {
+	ip.frame.scopes = append(ip.frame.scopes, make(map[string]*interpVar))
+}
+
+func (ip *Interp) leaveScope() {
+	ip.frame.scopes = ip.frame.scopes[:len(ip.frame.scopes)-1]
+}
+
+/* call function name with args (extra arguments are ignored, as in C) */
+func (ip *Interp) call(name string, args []int64) int64 {
+	fun, ok := ip.funs[name]
+	if !ok {
+		ip.errorf("call to undefined function %s", name)
+	}
+	if len(args) < len(fun.Params) {
+		ip.errorf("%s takes %d arguments, called with %d", name, len(fun.Params), len(args))
+	}
+	caller := ip.frame
+	ip.frame = &interpFrame{fun: fun}
+	ip.enterScope()
+	for i, param := range fun.Params {
+		*ip.declare(param.Name) = interpVar{args[i], true}
+	}
+	if ip.execStmt(fun.Body) != flowReturn {
+		ip.frame.ret = 0
+	}
+	ret := ip.frame.ret
+	ip.frame = caller
+	return ret
+}
+
+func (ip *Interp) execStmt(stmt ast.Stmt) interpFlow {
+	ip.step()
+	switch s := stmt.(type) {
+	case *ast.StmtEmpty:
+		return flowNext
+	case *ast.StmtContinue:
+		return flowContinue
+	case *ast.StmtBreak:
+		return flowBreak
+	case *ast.StmtReturn:
+		ip.frame.ret = ip.evalExpr(s.Expr)
+		return flowReturn
+	case *ast.StmtExpr:
+		ip.evalExpr(s.Expr)
+		return flowNext
+	case *ast.StmtDeclInit:
+		val := ip.evalExpr(s.Init)
+		*ip.declare(s.Decl.Name) = interpVar{val, true}
+		return flowNext
+	case *ast.StmtCompound:
+		ip.enterScope()
+		for _, decl := range s.Decls {
+			ip.declare(decl.Name)
+		}
+		flow := flowNext
+		for _, sub := range s.Stmts {
+			if flow = ip.execStmt(sub); flow != flowNext {
+				break
+			}
+		}
+		ip.leaveScope()
+		return flow
+	case *ast.StmtIf:
+		if ip.evalExpr(s.Cond) != 0 {
+			return ip.execStmt(s.ThenStmt)
+		}
+		if s.ElseStmt != nil {
+			return ip.execStmt(s.ElseStmt)
+		}
+		return flowNext
+	case *ast.StmtWhile:
+		for ip.evalExpr(s.Cond) != 0 {
+			if flow := ip.execStmt(s.Body); flow == flowBreak {
+				break
+			} else if flow == flowReturn {
+				return flow
+			}
+		}
+		return flowNext
+	case *ast.StmtFor:
+		/* what init declares is the loop's */
+		ip.enterScope()
+		ip.execStmt(s.Init)
+		flow := flowNext
+		for s.Cond == nil || ip.evalExpr(s.Cond) != 0 {
+			if flow = ip.execStmt(s.Body); flow == flowBreak || flow == flowReturn {
+				break
+			}
+			ip.execStmt(s.Post)
+		}
+		ip.leaveScope()
+		if flow == flowReturn {
+			return flow
+		}
+		return flowNext
+	}
+	ip.errorf("unknown statement %T", stmt)
+	return flowNext
+}
+
+func (ip *Interp) evalExpr(expr ast.Expr) int64 {
+	ip.step()
+	switch e := expr.(type) {
+	case *ast.ExprIntLiteral:
+		return e.Val
+	case *ast.ExprId:
+		v := ip.lookup(e.Name)
+		if v == nil {
+			if addr, ok := ip.addrs[e.Name]; ok {
+				return addr
+			}
+			ip.errorf("undeclared variable %s", e.Name)
+		}
+		if !v.init {
+			ip.errorf("%s is used uninitialized", e.Name)
+		}
+		return v.val
+	case *ast.ExprParen:
+		return ip.evalExpr(e.SubExpr)
+	case *ast.ExprCall:
+		fun, ok := ast.StripParen(e.Fun).(*ast.ExprId)
+		if !ok {
+			ip.errorf("call of %s, not a function", e.Fun.ExprString())
+		}
+		args := make([]int64, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = ip.evalExpr(arg)
+		}
+		return ip.call(fun.Name, args)
+	case *ast.ExprOp:
+		return ip.evalOp(e)
+	}
+	ip.errorf("unknown expression %T", expr)
+	return 0
+}
+
+/* 1 if b, 0 otherwise: what C's comparisons and ! give */
+func truth(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+/*
+the operators, computed here rather than with the constant folder
+(minc_fold), so that a mistake there shows as a difference between
+the two
+*/
+func (ip *Interp) evalOp(e *ast.ExprOp) int64 {
+	if len(e.Args) == 1 {
+		x := ip.evalExpr(e.Args[0])
+		switch e.Op {
+		case "-":
+			return -x
+		case "+":
+			return x
+		case "!":
+			return truth(x == 0)
+		case "~":
+			return ^x
+		}
+		ip.errorf("unknown operator %s", e.Op)
+	}
+	if len(e.Args) != 2 {
+		ip.errorf("operator %s with %d operands", e.Op, len(e.Args))
+	}
+	switch e.Op {
+	case "=":
+		id, ok := ast.StripParen(e.Args[0]).(*ast.ExprId)
+		if !ok {
+			ip.errorf("assignment to %s, not a variable", e.Args[0].ExprString())
+		}
+		val := ip.evalExpr(e.Args[1])
+		v := ip.lookup(id.Name)
+		if v == nil {
+			ip.errorf("assignment to undeclared variable %s", id.Name)
+		}
+		*v = interpVar{val, true}
+		return val
+	case "&&":
+		if ip.evalExpr(e.Args[0]) == 0 {
+			return 0
+		}
+		return truth(ip.evalExpr(e.Args[1]) != 0)
+	case "||":
+		if ip.evalExpr(e.Args[0]) != 0 {
+			return 1
+		}
+		return truth(ip.evalExpr(e.Args[1]) != 0)
+	}
+	x := ip.evalExpr(e.Args[0])
+	y := ip.evalExpr(e.Args[1])
+	switch e.Op {
+	case "+":
+		return x + y
+	case "-":
+		return x - y
+	case "*":
+		return x * y
+	case "/", "%":
+		if y == 0 {
+			ip.errorf("division by zero (%d %s 0)", x, e.Op)
+		}
+		if y == -1 && x == math.MinInt64 {
+			ip.errorf("overflow in division (%d %s -1)", x, e.Op)
+		}
+		/* Go's / and % truncate toward zero, as C's do */
+		if e.Op == "/" {
+			return x / y
+		}
+		return x % y
+	case "<<", ">>":
+		if y < 0 || y > 63 {
+			ip.errorf("shift by %d (%d %s %d)", y, x, e.Op, y)
+		}
+		/* >> of a signed value is arithmetic in Go */
+		if e.Op == "<<" {
+			return x << uint(y)
+		}
+		return x >> uint(y)
+	case "&":
+		return x & y
+	case "|":
+		return x | y
+	case "^":
+		return x ^ y
+	case "==":
+		return truth(x == y)
+	case "!=":
+		return truth(x != y)
+	case "<":
+		return truth(x < y)
+	case "<=":
+		return truth(x <= y)
+	case ">":
+		return truth(x > y)
+	case ">=":
+		return truth(x >= y)
+	}
+	ip.errorf("unknown operator %s", e.Op)
+	return 0
+}
+
+/* call function name of program with args */
+func interpret(program *ast.Program, name string, args []int64, maxSteps uint64) (ret int64, err error) {
+	ip := newInterp(program)
+	ip.maxSteps = maxSteps
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*interpError)
+			if !ok {
+				panic(r)
+			}
+			ret, err = 0, e
+		}
+	}()
+	return ip.call(name, args), nil
+}
+
+/*
+./minc run [--seed=N] [--test-no=N] [--max-steps=N] [options] file [-- arg ...]
+interpret f of a program (.c or .xml) and print what it returns.
+f is called with the arguments given, or else those of test/main.c
+(see minc emu). no pass runs unless asked for (-O1, -fpass=name, ...)
+*/
+func run_main(args []string) {
+	fun_args, args_given := []int64{}, false
+	for i, arg := range args {
+		if arg != "--" {
+			continue
+		}
+		args_given = true
+		for _, a := range args[i+1:] {
+			v, err := strconv.ParseInt(a, 0, 64)
+			if err != nil {
+				fatal(diag.UsageErrorf("bad argument %s", a))
+			}
+			fun_args = append(fun_args, v)
+		}
+		args = args[:i]
+		break
+	}
+	test_no, seed, seed_set := 0, int64(0), false
+	max_steps := uint64(0)
+	rest := []string{"-O0"}
+	for _, arg := range args {
+		var err error
+		switch {
+		case strings.HasPrefix(arg, "--seed="):
+			seed, err = strconv.ParseInt(strings.TrimPrefix(arg, "--seed="), 10, 64)
+			seed_set = true
+		case strings.HasPrefix(arg, "--test-no="):
+			test_no, err = strconv.Atoi(strings.TrimPrefix(arg, "--test-no="))
+		case strings.HasPrefix(arg, "--max-steps="):
+			max_steps, err = strconv.ParseUint(strings.TrimPrefix(arg, "--max-steps="), 10, 64)
+		default:
+			rest = append(rest, arg)
+		}
+		if err != nil {
+			fatal(diag.UsageErrorf("bad value in %s", arg))
+		}
+	}
+	opts, files, err := parse_args(rest)
+	if err != nil {
+		fatal(err)
+	}
+	if len(files) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s run [--seed=N] [--test-no=N] [--max-steps=N] [options] file [-- arg ...]\n", os.Args[0])
+		os.Exit(int(diag.Usage))
+	}
+	if !args_given {
+		if !seed_set {
+			seed = test_seed(test_no) // in minc_emu.go
+		}
+		fun_args = test_args(seed, 12)
+	}
+	program, err := file_to_program(files[0], opts) // in minc.go
+	if err != nil {
+		fatal(err)
+	}
+	/* the tests recurse a million calls deep */
+	debug.SetMaxStack(1 << 34)
+	y, err := interpret(program, "f", fun_args, max_steps)
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Printf("%d\n", y)
+}
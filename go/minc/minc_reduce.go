@@ -0,0 +1,564 @@
+package main
+
+/* minc_reduce
+
+   shrinking a program that shows a bug (a miscompiled one, say)
+   into a smaller one that still shows it, so that what is wrong is
+   easy to see:
+
+     ./minc reduce f.c -- 1 2 3      while f(1, 2, 3) compiled goes
+                                     wrong as it does now (a result
+                                     other than minc run's, a crash)
+     ./minc reduce --cmd=./t.sh f.c  while ./t.sh FILE exits with 0
+
+   whether a program still shows the bug is up to a predicate
+   (interesting), which the reducer calls on every smaller program
+   it tries. it tries, over and over until none of them does,
+
+     removing each function but f
+     removing each statement of each block (but the return ending a
+     function), or else replacing it with one in it (if (c) s with
+     s, a loop with its body, { s } with s)
+     inlining each call of a function that calls nothing (as the
+     inline pass does, see minc_inline)
+     replacing each expression with 0, 1 or an expression in it
+     removing each declaration
+
+   keeping every change after which the program is still
+   interesting. each change leaves the program smaller, or with a
+   call fewer, so this ends.
+*/
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"minc/ast"
+	"minc/codegen"
+	"minc/diag"
+	"minc/parse"
+)
+
+/* the functions of program */
+func reduceFuns(program *ast.Program) []*ast.DefFun {
+	funs := []*ast.DefFun{}
+	for _, def := range program.Defs {
+		if fun, ok := def.(*ast.DefFun); ok {
+			funs = append(funs, fun)
+		}
+	}
+	return funs
+}
+
+/* the blocks of a statement, innermost last */
+func compoundsOfStmt(stmt ast.Stmt, out []*ast.StmtCompound) []*ast.StmtCompound {
+	switch s := stmt.(type) {
+	case *ast.StmtCompound:
+		out = append(out, s)
+		for _, sub := range s.Stmts {
+			out = compoundsOfStmt(sub, out)
+		}
+	case *ast.StmtIf:
+		out = compoundsOfStmt(s.ThenStmt, out)
+		if s.ElseStmt != nil {
+			out = compoundsOfStmt(s.ElseStmt, out)
+		}
+	case *ast.StmtWhile:
+		out = compoundsOfStmt(s.Body, out)
+	case *ast.StmtFor:
+		out = compoundsOfStmt(s.Body, out)
+	}
+	return out
+}
+
+/*
+apply reduce to each block of each function; true if it changed any.
+a block it changed may have taken others out of the program, so the
+blocks are found again after each
+*/
+func reduceBlocks(program *ast.Program, reduce func(fun *ast.DefFun, blk *ast.StmtCompound) bool) bool {
+	changed := false
+	for _, fun := range reduceFuns(program) {
+		for i := 0; ; i++ {
+			blks := compoundsOfStmt(fun.Body, nil)
+			if i >= len(blks) {
+				break
+			}
+			if reduce(fun, blks[i]) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+/* try removing each of the definitions but f; true if any could be */
+func reduceDefs(program *ast.Program, interesting func(*ast.Program) bool) bool {
+	changed := false
+	for i := len(program.Defs) - 1; i >= 0; i-- {
+		if fun, ok := program.Defs[i].(*ast.DefFun); ok && fun.Name == "f" {
+			continue
+		}
+		defs := program.Defs
+		program.Defs = append(append([]ast.Def{}, defs[:i]...), defs[i+1:]...)
+		if interesting(program) {
+			changed = true
+		} else {
+			program.Defs = defs
+		}
+	}
+	return changed
+}
+
+/* --- statements --- */
+
+/* the statements stmt might be replaced with: those in it */
+func stmtCandidates(stmt ast.Stmt) []ast.Stmt {
+	switch s := stmt.(type) {
+	case *ast.StmtIf:
+		if s.ElseStmt != nil {
+			return []ast.Stmt{s.ThenStmt, s.ElseStmt}
+		}
+		return []ast.Stmt{s.ThenStmt}
+	case *ast.StmtWhile:
+		return []ast.Stmt{s.Body}
+	case *ast.StmtFor:
+		return []ast.Stmt{s.Body}
+	case *ast.StmtCompound:
+		if len(s.Decls) == 0 && len(s.Stmts) == 1 {
+			return s.Stmts
+		}
+	}
+	return nil
+}
+
+/*
+try removing each statement of blk, last first, or else replacing it
+with one in it; true if any could be. the return a function ends
+with stays: without it what the function returns is undefined, a bug
+of the program rather than the compiler
+*/
+func reduceStmts(program *ast.Program, fun *ast.DefFun, blk *ast.StmtCompound, interesting func(*ast.Program) bool) bool {
+	changed := false
+	for i := len(blk.Stmts) - 1; i >= 0; i-- {
+		if _, ok := blk.Stmts[i].(*ast.StmtReturn); ok && blk == fun.Body && i == len(blk.Stmts)-1 {
+			continue
+		}
+		stmts := blk.Stmts
+		blk.Stmts = append(append([]ast.Stmt{}, stmts[:i]...), stmts[i+1:]...)
+		if interesting(program) {
+			changed = true
+			continue
+		}
+		blk.Stmts = stmts
+		orig := stmts[i]
+		for _, c := range stmtCandidates(orig) {
+			blk.Stmts[i] = c
+			if interesting(program) {
+				changed = true
+				break
+			}
+			blk.Stmts[i] = orig
+		}
+	}
+	return changed
+}
+
+/* try removing each declaration of blk; true if any could be */
+func reduceDecls(program *ast.Program, blk *ast.StmtCompound, interesting func(*ast.Program) bool) bool {
+	changed := false
+	for i := len(blk.Decls) - 1; i >= 0; i-- {
+		decls := blk.Decls
+		blk.Decls = append(append([]*ast.Decl{}, decls[:i]...), decls[i+1:]...)
+		if interesting(program) {
+			changed = true
+		} else {
+			blk.Decls = decls
+		}
+	}
+	return changed
+}
+
+/* --- expressions --- */
+
+/* where an expression is: *p = e replaces it in the program */
+type reduceSlot struct {
+	p       *ast.Expr
+	operand bool // of an operator: what replaces it may need parentheses
+}
+
+/* the places of expr (at *p) and the expressions in it, outermost first */
+func exprSlots(p *ast.Expr, operand bool, out []reduceSlot) []reduceSlot {
+	out = append(out, reduceSlot{p, operand})
+	switch e := (*p).(type) {
+	case *ast.ExprOp:
+		for i := range e.Args {
+			/* what is assigned to stays a variable */
+			if e.Op == "=" && i == 0 {
+				continue
+			}
+			out = exprSlots(&e.Args[i], true, out)
+		}
+	case *ast.ExprParen:
+		out = exprSlots(&e.SubExpr, false, out)
+	case *ast.ExprCall:
+		for i := range e.Args {
+			out = exprSlots(&e.Args[i], false, out)
+		}
+	}
+	return out
+}
+
+/* the places of the expressions in stmt */
+func stmtExprSlots(stmt ast.Stmt, out []reduceSlot) []reduceSlot {
+	switch s := stmt.(type) {
+	case *ast.StmtReturn:
+		out = exprSlots(&s.Expr, false, out)
+	case *ast.StmtExpr:
+		out = exprSlots(&s.Expr, false, out)
+	case *ast.StmtDeclInit:
+		out = exprSlots(&s.Init, false, out)
+	case *ast.StmtCompound:
+		for _, sub := range s.Stmts {
+			out = stmtExprSlots(sub, out)
+		}
+	case *ast.StmtIf:
+		out = exprSlots(&s.Cond, false, out)
+		out = stmtExprSlots(s.ThenStmt, out)
+		if s.ElseStmt != nil {
+			out = stmtExprSlots(s.ElseStmt, out)
+		}
+	case *ast.StmtWhile:
+		out = exprSlots(&s.Cond, false, out)
+		out = stmtExprSlots(s.Body, out)
+	case *ast.StmtFor:
+		out = stmtExprSlots(s.Init, out)
+		if s.Cond != nil {
+			out = exprSlots(&s.Cond, false, out)
+		}
+		out = stmtExprSlots(s.Post, out)
+		out = stmtExprSlots(s.Body, out)
+	}
+	return out
+}
+
+func programExprSlots(program *ast.Program) []reduceSlot {
+	var out []reduceSlot
+	for _, fun := range reduceFuns(program) {
+		out = stmtExprSlots(fun.Body, out)
+	}
+	return out
+}
+
+/* how big an expression is; what replaces one must be smaller */
+func exprCost(expr ast.Expr) int {
+	switch e := expr.(type) {
+	case *ast.ExprIntLiteral:
+		switch e.Val {
+		case 0:
+			return 1
+		case 1:
+			return 2
+		}
+	case *ast.ExprParen:
+		return 1 + exprCost(e.SubExpr)
+	case *ast.ExprOp:
+		c := 3
+		for _, arg := range e.Args {
+			c += exprCost(arg)
+		}
+		return c
+	case *ast.ExprCall:
+		c := 3
+		for _, arg := range e.Args {
+			c += exprCost(arg)
+		}
+		return c
+	}
+	return 3
+}
+
+/* the expressions expr might be replaced with: 0, 1 and those in it */
+func exprCandidates(expr ast.Expr) []ast.Expr {
+	cands := []ast.Expr{&ast.ExprIntLiteral{Val: 0}, &ast.ExprIntLiteral{Val: 1}}
+	switch e := expr.(type) {
+	case *ast.ExprOp:
+		cands = append(cands, e.Args...)
+	case *ast.ExprParen:
+		cands = append(cands, e.SubExpr)
+	case *ast.ExprCall:
+		cands = append(cands, e.Args...)
+	}
+	return cands
+}
+
+/* try replacing each expression with a smaller one; true if any could be */
+func reduceExprs(program *ast.Program, interesting func(*ast.Program) bool) bool {
+	changed := false
+	slots := programExprSlots(program)
+	for i := 0; i < len(slots); i++ {
+		s := slots[i]
+		orig := *s.p
+		for _, c := range exprCandidates(orig) {
+			if s.operand {
+				c = paren(c) // in minc_gen.go
+			}
+			if exprCost(c) >= exprCost(orig) {
+				continue
+			}
+			*s.p = c
+			if interesting(program) {
+				break
+			}
+			*s.p = orig
+		}
+		if *s.p != orig {
+			/* the same places up to i, then those in what replaced it */
+			changed = true
+			slots = programExprSlots(program)
+		}
+	}
+	return changed
+}
+
+/* --- inlining --- */
+
+/* a copy of expr, sharing nothing with it */
+func cloneExpr(expr ast.Expr) ast.Expr {
+	switch e := expr.(type) {
+	case *ast.ExprIntLiteral:
+		return &ast.ExprIntLiteral{Val: e.Val}
+	case *ast.ExprId:
+		return &ast.ExprId{Name: e.Name}
+	case *ast.ExprOp:
+		args := make([]ast.Expr, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = cloneExpr(arg)
+		}
+		return &ast.ExprOp{Op: e.Op, Args: args}
+	case *ast.ExprParen:
+		return &ast.ExprParen{SubExpr: cloneExpr(e.SubExpr)}
+	case *ast.ExprCall:
+		args := make([]ast.Expr, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = cloneExpr(arg)
+		}
+		return &ast.ExprCall{Fun: cloneExpr(e.Fun), Args: args}
+	}
+	return expr
+}
+
+func cloneDecls(decls []*ast.Decl) []*ast.Decl {
+	out := []*ast.Decl{}
+	for _, decl := range decls {
+		out = append(out, &ast.Decl{VarType: decl.VarType, Name: decl.Name})
+	}
+	return out
+}
+
+/* a copy of stmt, sharing nothing with it */
+func cloneStmt(stmt ast.Stmt) ast.Stmt {
+	switch s := stmt.(type) {
+	case *ast.StmtReturn:
+		if s.Expr == nil {
+			return &ast.StmtReturn{Expr: nil}
+		}
+		return &ast.StmtReturn{Expr: cloneExpr(s.Expr)}
+	case *ast.StmtExpr:
+		return &ast.StmtExpr{Expr: cloneExpr(s.Expr)}
+	case *ast.StmtDeclInit:
+		return &ast.StmtDeclInit{Decl: &ast.Decl{VarType: s.Decl.VarType, Name: s.Decl.Name}, Init: cloneExpr(s.Init)}
+	case *ast.StmtCompound:
+		stmts := []ast.Stmt{}
+		for _, sub := range s.Stmts {
+			stmts = append(stmts, cloneStmt(sub))
+		}
+		return &ast.StmtCompound{Decls: cloneDecls(s.Decls), Stmts: stmts}
+	case *ast.StmtIf:
+		var else_stmt ast.Stmt
+		if s.ElseStmt != nil {
+			else_stmt = cloneStmt(s.ElseStmt)
+		}
+		return &ast.StmtIf{Cond: cloneExpr(s.Cond), ThenStmt: cloneStmt(s.ThenStmt), ElseStmt: else_stmt}
+	case *ast.StmtWhile:
+		return &ast.StmtWhile{Cond: cloneExpr(s.Cond), Body: cloneStmt(s.Body)}
+	case *ast.StmtFor:
+		var cond ast.Expr
+		if s.Cond != nil {
+			cond = cloneExpr(s.Cond)
+		}
+		return &ast.StmtFor{Init: cloneStmt(s.Init), Cond: cond, Post: cloneStmt(s.Post), Body: cloneStmt(s.Body)}
+	case *ast.StmtEmpty:
+		return &ast.StmtEmpty{}
+	case *ast.StmtBreak:
+		return &ast.StmtBreak{}
+	case *ast.StmtContinue:
+		return &ast.StmtContinue{}
+	}
+	return stmt
+}
+
+/* a copy of program, sharing nothing with it */
+func cloneProgram(program *ast.Program) *ast.Program {
+	defs := []ast.Def{}
+	for _, def := range program.Defs {
+		if fun, ok := def.(*ast.DefFun); ok {
+			def = &ast.DefFun{Name: fun.Name, Params: cloneDecls(fun.Params), ReturnType: fun.ReturnType, Body: cloneStmt(fun.Body)}
+		}
+		defs = append(defs, def)
+	}
+	return &ast.Program{Defs: defs}
+}
+
+/*
+try inlining each call it can (codegen.InlineSites, those of functions
+that call nothing, so that inlining leaves a call fewer); true if any
+could be
+*/
+func reduceInline(program *ast.Program, interesting func(*ast.Program) bool) bool {
+	changed := false
+	for i := 0; i < len(codegen.InlineSites(program)); {
+		/* inlined in a copy, whose sites are those of program */
+		try := cloneProgram(program)
+		codegen.InlineSites(try)[i].Inline() // in minc_inline.go
+		if interesting(try) {
+			/* call i is gone: the next is now i */
+			program.Defs = try.Defs
+			changed = true
+		} else {
+			i++
+		}
+	}
+	return changed
+}
+
+/*
+reduce program (in place) while interesting(program) holds, which
+it must to begin with; returns program
+*/
+func reduce_program(program *ast.Program, interesting func(*ast.Program) bool) *ast.Program {
+	for changed := true; changed; {
+		changed = reduceDefs(program, interesting)
+		if reduceBlocks(program, func(fun *ast.DefFun, blk *ast.StmtCompound) bool {
+			return reduceStmts(program, fun, blk, interesting)
+		}) {
+			changed = true
+		}
+		if reduceInline(program, interesting) {
+			changed = true
+		}
+		if reduceExprs(program, interesting) {
+			changed = true
+		}
+		if reduceBlocks(program, func(fun *ast.DefFun, blk *ast.StmtCompound) bool {
+			return reduceDecls(program, blk, interesting)
+		}) {
+			changed = true
+		}
+	}
+	return program
+}
+
+/* --- minc reduce --- */
+
+/* does command FILE exit with 0, FILE (in dir) holding program? */
+func cmdInteresting(command string, dir string, program *ast.Program) bool {
+	file := filepath.Join(dir, "reduce.c")
+	if err := write_file(file, []byte(program.String()), 0644); err != nil {
+		fatal(err)
+	}
+	return exec.Command("sh", "-c", command+` "$1"`, "sh", file).Run() == nil
+}
+
+/*
+./minc reduce [--seed=N] [--test-no=N] [--levels=0,1,2] [--cmd=command] [options] file [-- arg ...]
+reduce a program and print it. it is interesting while f compiled at
+the first level it goes wrong at (with the options, on the emulator)
+goes wrong the same way (see minc fuzz), f getting the arguments
+given, or else those of test/main.c; or, with --cmd, while command
+FILE exits with 0
+*/
+func reduce_main(args []string) {
+	fun_args, args_given := []int64{}, false
+	for i, arg := range args {
+		if arg != "--" {
+			continue
+		}
+		args_given = true
+		for _, a := range args[i+1:] {
+			v, err := strconv.ParseInt(a, 0, 64)
+			if err != nil {
+				fatal(diag.UsageErrorf("bad argument %s", a))
+			}
+			fun_args = append(fun_args, v)
+		}
+		args = args[:i]
+		break
+	}
+	test_no, seed := int64(0), ""
+	levels, command := "0,1,2", ""
+	rest := gen_flags(args, map[string]*int64{"test-no": &test_no},
+		map[string]*string{"seed": &seed, "levels": &levels, "cmd": &command})
+	opts, files, err := parse_args(append([]string{"-fno-pass=warn-unreachable"}, rest...))
+	if err != nil {
+		fatal(err)
+	}
+	if len(files) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s reduce [--seed=N] [--test-no=N] [--levels=0,1,2] [--cmd=command] [options] file [-- arg ...]\n", os.Args[0])
+		os.Exit(int(diag.Usage))
+	}
+	if !args_given {
+		s := test_seed(int(test_no)) // in minc_emu.go
+		if seed != "" {
+			v, err := strconv.ParseInt(seed, 10, 64)
+			if err != nil {
+				fatal(diag.UsageErrorf("bad value in --seed=%s", seed))
+			}
+			s = v
+		}
+		fun_args = test_args(s, 12)
+	}
+	program, err := parse.File(files[0])
+	if err != nil {
+		fatal(err)
+	}
+
+	var interesting func(*ast.Program) bool
+	if command != "" {
+		dir, err := os.MkdirTemp("", "minc-reduce")
+		if err != nil {
+			fatal(diag.InputError(err))
+		}
+		defer os.RemoveAll(dir)
+		interesting = func(p *ast.Program) bool { return cmdInteresting(command, dir, p) }
+		if !interesting(program) {
+			fmt.Fprintf(os.Stderr, "minc: %s %s does not exit with 0 to begin with\n", command, files[0])
+			os.RemoveAll(dir)
+			os.Exit(1)
+		}
+	} else {
+		if err := check_object_target(opts, "reduce"); err != nil {
+			fatal(err)
+		}
+		for _, o := range fuzz_level_options(&opts.Options.Options, levels) { // in minc_gen.go
+			o := o
+			what := fuzz_check(program, o, fun_args)
+			if what == "" {
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "minc: -O%d: %s\n", o.Level, what)
+			interesting = func(p *ast.Program) bool {
+				return fuzzSameFailure(fuzz_check(p, o, fun_args), what)
+			}
+			break
+		}
+		if interesting == nil {
+			fmt.Fprintf(os.Stderr, "minc: nothing goes wrong with %s to begin with\n", files[0])
+			os.Exit(1)
+		}
+	}
+	fmt.Print(reduce_program(program, interesting).String())
+}
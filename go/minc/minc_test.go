@@ -0,0 +1,403 @@
+package main
+
+/* minc_test
+
+   the tests of test/src, which test/Makefile runs with python, gcc
+   and an AArch64 machine, run by go test with nothing but Go:
+
+     go test                         every test, at -O0, -O1 and -O2
+     go test -run 'Src/f20$'         one of them
+     go test -minc.gcc               expect what gcc makes of them
+                                     (rather than minc run)
+     go test -minc.steps=0           also those that run long
+     go test -run RV64Qemu -minc.riscv-run='qemu-riscv64 -L dir'
+                                     RISC-V, with the sysroot in dir
+     go test -run Golden -minc.update
+                                     rewrite test/golden after a change
+                                     meant to change the output
+
+   each src/f*.c is parsed (minc_cparse), compiled, assembled
+   (minc_as), linked (minc_ld) and run on the emulator (minc_emu),
+   f getting the arguments test/main.c would give it. what f
+   returns must be what the interpreter (minc_interp) says it
+   should, or with -minc.gcc what f compiled by gcc returns.
+
+   the tests run in parallel; a test that is not minC (the parser
+   rejects it) or that runs more than -minc.steps steps (or
+   -minc.timeout with gcc) is skipped. TestGolden compares the
+   assembly of a few of them with test/golden, for a target they
+   cannot run on. TestX86 and TestRV64Qemu run them all compiled
+   for x86-64 and RISC-V, where there is gcc (and for RISC-V a
+   cross compiler and qemu), against what gcc makes of them.
+   TestArgs checks how the command line is taken (minc.go), and
+   TestCheck what minc_check rejects.
+*/
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"minc/check"
+	"minc/codegen"
+	"minc/compiler"
+	"minc/parse"
+)
+
+var (
+	testSrc     = flag.String("minc.src", "../../test/src", "the directory of the tests (f*.c)")
+	testGcc     = flag.Bool("minc.gcc", false, "compare with gcc rather than the interpreter")
+	testSteps   = flag.Uint64("minc.steps", 100000000, "skip a test running more steps than this (0: no limit)")
+	testTimeout = flag.Duration("minc.timeout", 10*time.Second, "skip a test gcc's executable runs longer than this")
+	testUpdate  = flag.Bool("minc.update", false, "rewrite the golden files rather than compare with them")
+	/* as riscv_cc and riscv_run in test/Makefile */
+	testRiscvCC  = flag.String("minc.riscv-cc", "riscv64-linux-gnu-gcc", "the C compiler for RISC-V (TestRV64Qemu)")
+	testRiscvRun = flag.String("minc.riscv-run", "qemu-riscv64 -L /usr/riscv64-linux-gnu", "how to run RISC-V executables (TestRV64Qemu)")
+)
+
+/* the -O levels each test is compiled at */
+var testLevels = []int{0, 1, 2}
+
+/* a command (its first word) in the PATH, or skip the test */
+func lookPath(t *testing.T, command string) string {
+	path, err := exec.LookPath(strings.Fields(command)[0])
+	if err != nil {
+		t.Skipf("%s not found", strings.Fields(command)[0])
+	}
+	return path
+}
+
+/* test/main.c linked by cc with the files defining f */
+func ccBuild(t *testing.T, cc string, test_no int, files ...string) string {
+	exe := filepath.Join(t.TempDir(), "f.exe")
+	main_c := filepath.Join(filepath.Dir(*testSrc), "main.c")
+	args := append(strings.Fields(cc)[1:], "-w", "-O0", "-DTEST_NO="+strconv.Itoa(test_no), "-o", exe, main_c)
+	if out, err := exec.Command(lookPath(t, cc), append(args, files...)...).CombinedOutput(); err != nil {
+		t.Fatalf("%s: %v\n%s", cc, err, out)
+	}
+	return exe
+}
+
+/* what an executable of test/main.c prints, run by run ("": directly) */
+func ccRun(t *testing.T, run string, exe string) int64 {
+	ctx, cancel := context.WithTimeout(context.Background(), *testTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, exe)
+	if run != "" {
+		cmd = exec.CommandContext(ctx, lookPath(t, run), append(strings.Fields(run)[1:], exe)...)
+	}
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		t.Skipf("%s ran more than %v", exe, *testTimeout)
+	}
+	if err != nil {
+		t.Fatalf("%s: %v", exe, err)
+	}
+	y, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		t.Fatalf("%s printed %q", exe, out)
+	}
+	return y
+}
+
+/* test/main.c and f compiled by gcc, and what it prints */
+func gccResult(t *testing.T, file string, test_no int) int64 {
+	return ccRun(t, "", ccBuild(t, "gcc", test_no, file))
+}
+
+func TestSrc(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join(*testSrc, "f*.c"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no tests in %s", *testSrc)
+	}
+	/* f100 recurses a million calls deep in the interpreter */
+	debug.SetMaxStack(1 << 34)
+	for _, file := range files {
+		file := file
+		name := strings.TrimSuffix(filepath.Base(file), ".c")
+		test_no, err := strconv.Atoi(strings.TrimPrefix(name, "f"))
+		if err != nil {
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			srcb, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			src := string(srcb)
+			program, err := parse.C(src, file)
+			if err != nil {
+				t.Skipf("not minC: %v", err)
+			}
+			args := test_args(test_seed(test_no), 12)
+
+			var want int64
+			if *testGcc {
+				want = gccResult(t, file, test_no)
+			} else if want, err = interpret(program, "f", args, *testSteps); is_step_limit(err) {
+				t.Skipf("interpreter: %v", err)
+			} else if err != nil {
+				t.Fatalf("interpreter: %v", err)
+			}
+
+			for _, level := range testLevels {
+				opts, err := codegen.DefaultOptions(level)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := emu_source(src, file, opts, args, *testSteps)
+				switch {
+				case is_step_limit(err):
+					t.Skipf("-O%d: %v", level, err)
+				case err != nil:
+					t.Errorf("-O%d: %v", level, err)
+				case got != want:
+					t.Errorf("-O%d: f returned %d, want %d\n\t(%s)", level, got, want, "minc run "+file+" -- "+argsText(args))
+				}
+			}
+		})
+	}
+}
+
+/*
+the tests compiled for target, linked with test/main.c by cc and run
+by run, must print what f compiled by gcc (for this machine) does
+*/
+func testTarget(t *testing.T, target_name string, cc string, run string) {
+	lookPath(t, "gcc")
+	lookPath(t, cc)
+	if run != "" {
+		lookPath(t, run)
+	}
+	target, err := codegen.LookupTarget(target_name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join(*testSrc, "f*.c"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no tests in %s", *testSrc)
+	}
+	for _, file := range files {
+		file := file
+		name := strings.TrimSuffix(filepath.Base(file), ".c")
+		test_no, err := strconv.Atoi(strings.TrimPrefix(name, "f"))
+		if err != nil {
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			src, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := parse.C(string(src), file); err != nil {
+				t.Skipf("not minC: %v", err)
+			}
+			want := gccResult(t, file, test_no)
+			for _, level := range []int{0, 2} {
+				opts, err := codegen.DefaultOptions(level)
+				if err != nil {
+					t.Fatal(err)
+				}
+				opts.Target = target
+				res, err := compiler.Compile(src, compiler.Options{Options: *opts, Name: file})
+				if err != nil {
+					t.Errorf("-O%d: %v", level, err)
+					continue
+				}
+				asm := filepath.Join(t.TempDir(), name+".s")
+				if err := os.WriteFile(asm, []byte(res.Asm), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				if got := ccRun(t, run, ccBuild(t, cc, test_no, asm)); got != want {
+					t.Errorf("-O%d: f returned %d, want %d (gcc)", level, got, want)
+				}
+			}
+		})
+	}
+}
+
+/* on an x86-64 Linux machine with gcc */
+func TestX86(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("not an x86-64 Linux machine")
+	}
+	testTarget(t, "x86_64-linux", "gcc", "")
+}
+
+/* with a RISC-V cross compiler and qemu (-minc.riscv-cc, -minc.riscv-run) */
+func TestRV64Qemu(t *testing.T) {
+	testTarget(t, "riscv64-linux", *testRiscvCC, *testRiscvRun)
+}
+
+/* the tests of test/golden (golden_nos in test/Makefile), compiled as minc does by default */
+var goldenTarget, goldenTests = "arm64-apple-darwin", []string{"f20", "f50", "f100", "f102"}
+
+func TestGolden(t *testing.T) {
+	dir := filepath.Join(filepath.Dir(*testSrc), "golden", goldenTarget)
+	target, err := codegen.LookupTarget(goldenTarget)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range goldenTests {
+		src, err := os.ReadFile(filepath.Join(*testSrc, name+".c"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts, err := codegen.DefaultOptions(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts.Target = target
+		res, err := compiler.Compile(src, compiler.Options{Options: *opts, Name: name + ".c"})
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		golden := filepath.Join(dir, name+".s")
+		if *testUpdate {
+			if err := os.WriteFile(golden, []byte(res.Asm), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Asm != string(want) {
+			got, want := strings.Split(res.Asm, "\n"), strings.Split(string(want), "\n")
+			for i := range got {
+				if i >= len(want) || got[i] != want[i] {
+					t.Errorf("%s: differs from %s from line %d: %q", name, golden, i+1, got[i])
+					break
+				}
+			}
+			if len(got) < len(want) {
+				t.Errorf("%s: shorter than %s", name, golden)
+			}
+		}
+	}
+}
+
+/* the command line, as gcc takes it where it can */
+func TestArgs(t *testing.T) {
+	tests := []struct {
+		args   string
+		emit   string
+		output string
+		level  int
+		files  int
+	}{
+		{"f.c", "", "", 2, 1},
+		{"-S -c f.c", "asm", "", 2, 1},
+		{"-c --emit=ir f.c", "ir", "", 2, 1},
+		{"-O f.c -o f", "", "f", 1, 1},
+		{"-O3 -c f.c -of.o", "obj", "f.o", 2, 1},
+		{"-Os -x xml - g.c", "", "", 2, 2},
+		{"-O0 -w -Wall -Werror -Wno-foo", "", "", 0, 0},
+	}
+	for _, tt := range tests {
+		opts, files, err := parse_args(strings.Fields(tt.args))
+		if err != nil {
+			t.Errorf("%s: %v", tt.args, err)
+			continue
+		}
+		if opts.emit != tt.emit || opts.output != tt.output || opts.Level != tt.level || len(files) != tt.files {
+			t.Errorf("%s: emit %q, output %q, -O%d, %d files", tt.args, opts.emit, opts.output, opts.Level, len(files))
+		}
+	}
+	for _, args := range []string{"-o", "-x", "-x c++ f.c", "--emit=exe f.c", "-Wfoo f.c", "-Ox f.c", "--target=vax f.c"} {
+		if _, _, err := parse_args(strings.Fields(args)); err == nil {
+			t.Errorf("%s: no error", args)
+		}
+	}
+	for _, tt := range []struct{ file, kind, want string }{
+		{"dir/f.c", "asm", "f.s"}, {"f.xml", "obj", "f.o"}, {"f.c", "ast", "-"}, {"-", "obj", "-"},
+	} {
+		if got := output_name(tt.file, tt.kind); got != tt.want {
+			t.Errorf("output_name(%s, %s) = %s, want %s", tt.file, tt.kind, got, tt.want)
+		}
+	}
+}
+
+/* the interpreter's operators, with the values C gives them (not minc_fold's) */
+func TestInterpOperators(t *testing.T) {
+	tests := []struct {
+		expr string
+		want int64
+		err  string // the run time error expected instead
+	}{
+		{"x + 9223372036854775807", -9223372036854775806, ""}, // x = 3
+		{"x - 7 * 2", -11, ""},
+		{"-(x * 3074457345618258603)", 9223372036854775807, ""},
+		{"(0 - 7) / 2", -3, ""},
+		{"(0 - 7) % 2", -1, ""},
+		{"7 % (0 - 2)", 1, ""},
+		{"(0 - 8) >> 1", -4, ""},
+		{"x << 62", -4611686018427387904, ""},
+		{"!x + !0 + ~x", -3, ""},
+		{"(x & 6) + (x | 4) * 10 + (x ^ 1) * 100", 272, ""},
+		{"(x < 3) + (x <= 3) * 2 + (x > 3) * 4 + (x >= 3) * 8 + (x == 3) * 16 + (x != 3) * 32", 26, ""},
+		{"0 && x / 0", 0, ""},
+		{"5 || x / 0", 1, ""},
+		{"2 && 3", 1, ""},
+		{"x / (x - 3)", 0, "division by zero"},
+		{"x % (x - 3)", 0, "division by zero"},
+		{"(0 - 9223372036854775807 - 1) / (0 - 1)", 0, "overflow in division"},
+		{"1 << 64", 0, "shift by 64"},
+		{"1 >> (x - 4)", 0, "shift by -1"},
+	}
+	for _, tt := range tests {
+		program, err := parse.C("long f(long x) { return "+tt.expr+"; }", "f.c")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.expr, err)
+		}
+		got, err := interpret(program, "f", []int64{3}, 0)
+		switch {
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: %d, %v, want the error %s", tt.expr, got, err, tt.err)
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.expr, err)
+		case tt.err == "" && got != tt.want:
+			t.Errorf("%s: %d, want %d", tt.expr, got, tt.want)
+		}
+	}
+}
+
+/* what check rejects, and what it lets through */
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string // the diagnostic expected ("": none)
+	}{
+		{"long f(long a) { long y; y = 1; { long y; y = 2; } return y; }", "y is declared again in an inner block"},
+		{"long f(long a) { { long a; a = 2; } return a; }", "a is declared again in an inner block"},
+		{"long f(long a) { long y; long y; return a; }", "y is declared twice"},
+		{"long f(long a) { { long i; i = 1; } { long i; i = a; } return a; }", ""},
+		{"long f(long a) { { long y; y = 1; } return y; }", "undeclared variable y"},
+		{"long f(long a) { break; return a; }", "break is not in a loop"},
+	}
+	for _, tt := range tests {
+		program, err := parse.C(tt.src, "f.c")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.src, err)
+		}
+		err = check.Program(program, "f.c")
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: %v", tt.src, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: %v, want %s", tt.src, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,437 @@
+/* package parse: minC programs, as source (.c) or XML, into ASTs */
+package parse
+
+/* minc_cparse
+
+   parsing minC source (.c) directly into the AST, without going
+   through parser/minc_to_xml.py and XML (minc_parse):
+
+            C (minc_cparse)
+   .c file -----------------> AST
+
+   the grammar is that of parser/minc_grammar.y, with the operators
+   the tests use beyond it (&& || & | ^ << >>), weakest first:
+
+     =                   (right to left)
+     ||
+     &&
+     |
+     ^
+     &
+     == !=
+     < <= > >=
+     << >>
+     + -
+     * / %
+     + - ! ~             (unary)
+
+   a syntax error is reported with the file and line it is at.
+*/
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"minc/ast"
+	"minc/diag"
+)
+
+type cTokenKind int
+
+const (
+	cTokNum   cTokenKind = iota // 123
+	cTokId                      // x, long, while, ...
+	cTokPunct                   // ( ) { } ; , and the operators
+	cTokEOF
+)
+
+type cToken struct {
+	kind cTokenKind
+	text string
+	line int
+}
+
+/* the operators of two characters */
+var cPunct2 = []string{"==", "!=", "<=", ">=", "&&", "||", "<<", ">>"}
+
+const cPunct1 = "(){};,=+-*/%<>!~&|^"
+
+/* a syntax error, panicked by the parser and recovered by C */
+type cSyntaxError struct {
+	file string
+	line int
+	msg  string
+}
+
+func (e *cSyntaxError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.file, e.line, e.msg)
+}
+
+type cParser struct {
+	file string
+	toks []cToken
+	pos  int
+}
+
+func (p *cParser) errorf(line int, format string, args ...interface{}) {
+	panic(&cSyntaxError{p.file, line, fmt.Sprintf(format, args...)})
+}
+
+func isCIdStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isCDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+/* split the source into tokens, dropping spaces and comments */
+func (p *cParser) lex(src string) {
+	line := 1
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				p.errorf(line, "unterminated comment")
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += 2 + end + 2
+		case isCDigit(c):
+			j := i
+			for j < len(src) && (isCDigit(src[j]) || isCIdStart(src[j])) {
+				j++
+			}
+			p.toks = append(p.toks, cToken{cTokNum, src[i:j], line})
+			i = j
+		case isCIdStart(c):
+			j := i
+			for j < len(src) && (isCIdStart(src[j]) || isCDigit(src[j])) {
+				j++
+			}
+			p.toks = append(p.toks, cToken{cTokId, src[i:j], line})
+			i = j
+		default:
+			n := 0
+			for _, op := range cPunct2 {
+				if strings.HasPrefix(src[i:], op) {
+					n = 2
+				}
+			}
+			if n == 0 && strings.IndexByte(cPunct1, c) >= 0 {
+				n = 1
+			}
+			if n == 0 {
+				p.errorf(line, "unexpected character '%c'", c)
+			}
+			p.toks = append(p.toks, cToken{cTokPunct, src[i : i+n], line})
+			i += n
+		}
+	}
+	p.toks = append(p.toks, cToken{cTokEOF, "", line})
+}
+
+func (p *cParser) peek() cToken {
+	return p.toks[p.pos]
+}
+
+/* the token n after the next one */
+func (p *cParser) peekAt(n int) cToken {
+	if p.pos+n < len(p.toks) {
+		return p.toks[p.pos+n]
+	}
+	return p.toks[len(p.toks)-1]
+}
+
+func (p *cParser) next() cToken {
+	t := p.toks[p.pos]
+	if t.kind != cTokEOF {
+		p.pos++
+	}
+	return t
+}
+
+/* is the next token s (an operator or a keyword)? */
+func (p *cParser) at(s string) bool {
+	t := p.peek()
+	return t.kind != cTokEOF && t.kind != cTokNum && t.text == s
+}
+
+/* skip the next token if it is s */
+func (p *cParser) accept(s string) bool {
+	if p.at(s) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *cParser) describe(t cToken) string {
+	if t.kind == cTokEOF {
+		return "end of file"
+	}
+	return "'" + t.text + "'"
+}
+
+func (p *cParser) expect(s string) {
+	if !p.accept(s) {
+		t := p.peek()
+		p.errorf(t.line, "expected '%s', found %s", s, p.describe(t))
+	}
+}
+
+var cKeywords = map[string]bool{
+	"long": true, "return": true, "if": true, "else": true,
+	"while": true, "for": true, "break": true, "continue": true,
+}
+
+func (p *cParser) identifier() string {
+	t := p.next()
+	if t.kind != cTokId || cKeywords[t.text] {
+		p.errorf(t.line, "expected an identifier, found %s", p.describe(t))
+	}
+	return t.text
+}
+
+/* long (the only type) */
+func (p *cParser) typeExpr() ast.TypeExpr {
+	p.expect("long")
+	return &ast.TypePrimitive{Name: "long"}
+}
+
+/* program = fun_definition* */
+func (p *cParser) program() *ast.Program {
+	defs := []ast.Def{}
+	for p.peek().kind != cTokEOF {
+		defs = append(defs, p.funDefinition())
+	}
+	return &ast.Program{Defs: defs}
+}
+
+/* fun_definition = long f(long x, ...) compound_stmt */
+func (p *cParser) funDefinition() ast.Def {
+	return_type := p.typeExpr()
+	name := p.identifier()
+	p.expect("(")
+	params := []*ast.Decl{}
+	if !p.accept(")") {
+		for {
+			param_type := p.typeExpr()
+			params = append(params, &ast.Decl{VarType: param_type, Name: p.identifier()})
+			if p.accept(")") {
+				break
+			}
+			p.expect(",")
+		}
+	}
+	body := p.compoundStmt()
+	return &ast.DefFun{Name: name, Params: params, ReturnType: return_type, Body: body}
+}
+
+/* compound_stmt = { var_decl* stmt* }; long x = e; is a statement */
+func (p *cParser) compoundStmt() ast.Stmt {
+	p.expect("{")
+	decls := []*ast.Decl{}
+	for p.at("long") && p.peekAt(2).text == ";" {
+		var_type := p.typeExpr()
+		decls = append(decls, &ast.Decl{VarType: var_type, Name: p.identifier()})
+		p.expect(";")
+	}
+	stmts := []ast.Stmt{}
+	for !p.accept("}") {
+		if p.peek().kind == cTokEOF {
+			p.errorf(p.peek().line, "expected '}', found end of file")
+		}
+		stmts = append(stmts, p.stmt())
+	}
+	return &ast.StmtCompound{Decls: decls, Stmts: stmts}
+}
+
+func (p *cParser) stmt() ast.Stmt {
+	switch {
+	case p.accept(";"):
+		return &ast.StmtEmpty{}
+	case p.accept("continue"):
+		p.expect(";")
+		return &ast.StmtContinue{}
+	case p.accept("break"):
+		p.expect(";")
+		return &ast.StmtBreak{}
+	case p.accept("return"):
+		e := p.expr()
+		p.expect(";")
+		return &ast.StmtReturn{Expr: e}
+	case p.at("{"):
+		return p.compoundStmt()
+	case p.accept("if"):
+		cond := p.parenExpr()
+		then_stmt := p.stmt()
+		var else_stmt ast.Stmt
+		if p.accept("else") {
+			else_stmt = p.stmt()
+		}
+		return &ast.StmtIf{Cond: cond, ThenStmt: then_stmt, ElseStmt: else_stmt}
+	case p.accept("while"):
+		cond := p.parenExpr()
+		return &ast.StmtWhile{Cond: cond, Body: p.stmt()}
+	case p.accept("for"):
+		return p.forStmt()
+	case p.at("long"):
+		/* long x = e; */
+		var_type := p.typeExpr()
+		decl := &ast.Decl{VarType: var_type, Name: p.identifier()}
+		p.expect("=")
+		init := p.expr()
+		p.expect(";")
+		return &ast.StmtDeclInit{Decl: decl, Init: init}
+	}
+	e := p.expr()
+	p.expect(";")
+	return &ast.StmtExpr{Expr: e}
+}
+
+/* ( expr ) */
+func (p *cParser) parenExpr() ast.Expr {
+	p.expect("(")
+	e := p.expr()
+	p.expect(")")
+	return e
+}
+
+/* for (init; cond; post) body, each of init, cond and post may be empty */
+func (p *cParser) forStmt() ast.Stmt {
+	p.expect("(")
+	var init, post ast.Stmt = &ast.StmtEmpty{}, &ast.StmtEmpty{}
+	var cond ast.Expr
+	if !p.at(";") {
+		init = &ast.StmtExpr{Expr: p.expr()}
+	}
+	p.expect(";")
+	if !p.at(";") {
+		cond = p.expr()
+	}
+	p.expect(";")
+	if !p.at(")") {
+		post = &ast.StmtExpr{Expr: p.expr()}
+	}
+	p.expect(")")
+	return &ast.StmtFor{Init: init, Cond: cond, Post: post, Body: p.stmt()}
+}
+
+/* the binary operators by precedence, weakest first (= aside) */
+var cBinaryLevels = [][]string{
+	{"||"}, {"&&"}, {"|"}, {"^"}, {"&"},
+	{"==", "!="}, {"<=", ">=", "<", ">"}, {"<<", ">>"},
+	{"+", "-"}, {"*", "/", "%"},
+}
+
+/* expr = binary = expr | binary */
+func (p *cParser) expr() ast.Expr {
+	left := p.binary(0)
+	if p.accept("=") {
+		return &ast.ExprOp{Op: "=", Args: []ast.Expr{left, p.expr()}}
+	}
+	return left
+}
+
+/* the operators of level and stronger, left to right */
+func (p *cParser) binary(level int) ast.Expr {
+	if level == len(cBinaryLevels) {
+		return p.unary()
+	}
+	left := p.binary(level + 1)
+	for {
+		op := ""
+		for _, o := range cBinaryLevels[level] {
+			if p.peek().kind == cTokPunct && p.peek().text == o {
+				op = o
+			}
+		}
+		if op == "" {
+			return left
+		}
+		p.next()
+		left = &ast.ExprOp{Op: op, Args: []ast.Expr{left, p.binary(level + 1)}}
+	}
+}
+
+func (p *cParser) unary() ast.Expr {
+	t := p.next()
+	switch {
+	case t.kind == cTokNum:
+		val, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			p.errorf(t.line, "bad integer literal %s", t.text)
+		}
+		return &ast.ExprIntLiteral{Val: val}
+	case t.kind == cTokId && !cKeywords[t.text]:
+		if !p.accept("(") {
+			return &ast.ExprId{Name: t.text}
+		}
+		args := []ast.Expr{}
+		if !p.accept(")") {
+			for {
+				args = append(args, p.expr())
+				if p.accept(")") {
+					break
+				}
+				p.expect(",")
+			}
+		}
+		return &ast.ExprCall{Fun: &ast.ExprId{Name: t.text}, Args: args}
+	case t.kind == cTokPunct && t.text == "(":
+		e := p.expr()
+		p.expect(")")
+		return &ast.ExprParen{SubExpr: e}
+	case t.kind == cTokPunct && (t.text == "+" || t.text == "-" || t.text == "!" || t.text == "~"):
+		return &ast.ExprOp{Op: t.text, Args: []ast.Expr{p.unary()}}
+	}
+	p.errorf(t.line, "expected an expression, found %s", p.describe(t))
+	return nil
+}
+
+/* minC source -> abstract syntax tree (file is for the diagnostics) */
+func C(src string, file string) (program *ast.Program, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*cSyntaxError)
+			if !ok {
+				panic(r)
+			}
+			program, err = nil, diag.CompileError([]diag.Diagnostic{{File: e.file, Line: e.line, Msg: e.msg}})
+		}
+	}()
+	p := &cParser{file: file}
+	p.lex(src)
+	return p.program(), nil
+}
+
+/* a program, in minC source or (if name ends in .xml) its XML */
+func Source(src []byte, name string) (*ast.Program, error) {
+	if strings.HasSuffix(name, ".xml") {
+		return XML(string(src), name) // in minc_parse.go
+	}
+	return C(string(src), name)
+}
+
+/* read a program: minC source (.c) or its XML */
+func File(file string) (*ast.Program, error) {
+	contentb, err := os.ReadFile(file)
+	if err != nil {
+		return nil, diag.InputError(err) // in minc_diag.go
+	}
+	return Source(contentb, file)
+}
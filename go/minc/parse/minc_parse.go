@@ -1,4 +1,4 @@
-package main
+package parse
 
 /* minc_parse
 
@@ -25,22 +25,26 @@ package main
    dom_to_ast_{type,expr,stmt,...} : convert an XML tree representing
    type, expression, statement, etc. into the respective AST.
 
-   str_to_ast : convert a string representing a program
-   into AST, by first calling str_to_dom and then dom_to_ast_program
+   XML : convert a string representing a program
+   into AST, by first calling str_to_dom and then dom_to_ast_program.
+   malformed XML, or a tree that is not one of a program, is an error
+   (a diagnostic, in minc_diag), not a crash
 
-   file_xml_to_ast : read an XML file (get a string) and
-   convert it into AST. this is the function called from the main
-   function
+   File (minc_cparse) : read a file (get a string) and
+   convert it into AST, by XML if it is XML (.xml). this is
+   what the compiler (minc_compile) reads a program with
+
+   ToXML (minc_toxml) : the other way round, AST to XML
 
 */
 
 import (
-	"fmt"
-	"log"
-	"os"
 	"strconv"
 
 	"github.com/subchen/go-xmldom"
+
+	"minc/ast"
+	"minc/diag"
 )
 
 /* --- things related to string -> dom --- */
@@ -50,18 +54,15 @@ string -> dom
 
 	<foo>bar</foo> -> Element("foo", [Text("bar")])
 */
-func str_to_dom(s string) *xmldom.Node {
-	var node *xmldom.Node = xmldom.Must(xmldom.ParseXML(s)).Root
-	return node
-}
-
-/* xml in file -> dom */
-func file_xml_to_dom(file_xml string) *xmldom.Node {
-	contentb, err := os.ReadFile(file_xml)
+func str_to_dom(s string) (*xmldom.Node, error) {
+	doc, err := xmldom.ParseXML(s)
 	if err != nil {
-		log.Fatal(err)
+		return nil, &xmlError{err.Error()}
+	}
+	if doc.Root == nil {
+		return nil, &xmlError{"no element"}
 	}
-	return str_to_dom(string(contentb))
+	return doc.Root, nil
 }
 
 /* --- parser (XML DOM -> Abstract Syntax Tree) ---
@@ -76,9 +77,22 @@ func file_xml_to_dom(file_xml string) *xmldom.Node {
 
 */
 
-/* panic when the input XML DOM tree does not have the right structure */
+/* malformed XML, or a tree not of the right structure */
+type xmlError struct {
+	msg string
+}
+
+func (e *xmlError) Error() string {
+	return "invalid XML: " + e.msg
+}
+
+/* panic (recovered by XML) when the input XML DOM tree does not have the right structure */
 func invalid_xml(elem *xmldom.Node) {
-	panic(fmt.Sprintf("%s", elem.XML()))
+	xml := elem.XML()
+	if len(xml) > 200 {
+		xml = xml[:200] + " ..."
+	}
+	panic(&xmlError{xml})
 }
 
 // check if this is a node like <tag/>
@@ -172,19 +186,19 @@ dom tree for type expression -> ast for type expression
 	the framework is designed so it is easy to extend it with
 	a new type)
 */
-func dom_to_ast_type(elem *xmldom.Node) TypeExpr {
+func dom_to_ast_type(elem *xmldom.Node) ast.TypeExpr {
 	// <primitive_type>long</primitive_type>
 	switch elem.Name {
 	case "primitive_type":
 		name := check_get_text(elem)
-		return &TypePrimitive{name}
+		return &ast.TypePrimitive{Name: name}
 	}
 	invalid_xml(elem)
 	return nil
 }
 
 /* dom tree for expression -> ast for expression */
-func dom_to_ast_expr(elem *xmldom.Node) Expr {
+func dom_to_ast_expr(elem *xmldom.Node) ast.Expr {
 	switch elem.Name {
 	case "int_literal":
 		{ // <int_literal>123</int_literal>
@@ -193,39 +207,45 @@ func dom_to_ast_expr(elem *xmldom.Node) Expr {
 			if err != nil {
 				invalid_xml(elem)
 			}
-			return &ExprIntLiteral{val}
+			return &ast.ExprIntLiteral{Val: val}
 		}
 	case "var":
 		{ // <var>x</var>
 			name := check_get_text(elem)
-			return &ExprId{name}
+			return &ast.ExprId{Name: name}
 		}
 	case "un_op":
 		{ // <un_op><op>-</op><arg>expr</arg></un_op>
 			op_elem, arg_elem := check_get_children_2(elem, "op", "arg")
 			op := check_get_text(op_elem)
+			if !ast.UnaryOps[op] {
+				invalid_xml(op_elem)
+			}
 			arg := dom_to_ast_expr(check_get_child_1(arg_elem))
-			return &ExprOp{op, []Expr{arg}}
+			return &ast.ExprOp{Op: op, Args: []ast.Expr{arg}}
 		}
 	case "bin_op":
 		{ // <bin_op><op>+</op><left>expr</left><right>expr</right></bin_op>
 			op_elem, left_elem, right_elem := check_get_children_3(elem, "op", "left", "right")
 			op := check_get_text(op_elem)
+			if !ast.BinaryOps[op] {
+				invalid_xml(op_elem)
+			}
 			left := dom_to_ast_expr(check_get_child_1(left_elem))
 			right := dom_to_ast_expr(check_get_child_1(right_elem))
-			return &ExprOp{op, []Expr{left, right}}
+			return &ast.ExprOp{Op: op, Args: []ast.Expr{left, right}}
 		}
 	case "call":
 		{ // <call><fun>expr</fun><args>expr expr expr ...</args></call>
 			fun_elem, args_elem := check_get_children_2(elem, "fun", "args")
 			fun := dom_to_ast_expr(check_get_child_1(fun_elem))
-			args := map_array(dom_to_ast_expr, args_elem.Children)
-			return &ExprCall{fun, args}
+			args := ast.MapArray(dom_to_ast_expr, args_elem.Children)
+			return &ast.ExprCall{Fun: fun, Args: args}
 		}
 	case "paren":
 		{ // <paren>expr</paren>
 			expr := dom_to_ast_expr(check_get_child_1(elem))
-			return &ExprParen{expr}
+			return &ast.ExprParen{SubExpr: expr}
 		}
 	}
 	invalid_xml(elem)
@@ -233,53 +253,53 @@ func dom_to_ast_expr(elem *xmldom.Node) Expr {
 }
 
 /* dom tree for variable declaration -> ast for variable declaration */
-func dom_to_ast_decl(elem *xmldom.Node) *Decl {
+func dom_to_ast_decl(elem *xmldom.Node) *ast.Decl {
 	// <decl><type>TYPE</type><name>X</name></decl>
 	switch elem.Name {
 	case "decl":
 		var_type_elem, var_name_elem := check_get_children_2(elem, "type", "name")
 		var_type := dom_to_ast_type(check_get_child_1(var_type_elem))
 		var_name := check_get_text(var_name_elem)
-		return &Decl{var_type, var_name}
+		return &ast.Decl{VarType: var_type, Name: var_name}
 	}
 	invalid_xml(elem)
 	return nil
 }
 
 /* dom tree for statement -> ast for statement */
-func dom_to_ast_stmt(elem *xmldom.Node) Stmt {
+func dom_to_ast_stmt(elem *xmldom.Node) ast.Stmt {
 	switch elem.Name {
 	case "empty":
 		{ // <empty></empty>
 			check_singleton(elem)
-			return &StmtEmpty{}
+			return &ast.StmtEmpty{}
 		}
 	case "continue":
 		{ // <continue></continue>
 			check_singleton(elem)
-			return &StmtContinue{}
+			return &ast.StmtContinue{}
 		}
 	case "break":
 		{ // <break></break>
 			check_singleton(elem)
-			return &StmtBreak{}
+			return &ast.StmtBreak{}
 		}
 	case "return":
 		{ // <return>expr</return>
 			return_expr := dom_to_ast_expr(check_get_child_1(elem))
-			return &StmtReturn{return_expr}
+			return &ast.StmtReturn{Expr: return_expr}
 		}
 	case "expr_stmt":
 		{ // <expr_stmt>expr</expr_stmt>
 			expr := dom_to_ast_expr(check_get_child_1(elem))
-			return &StmtExpr{expr}
+			return &ast.StmtExpr{Expr: expr}
 		}
 	case "compound":
 		{ // <compound><decls>...</decls><stmts>...</stmts></compound>
 			decls_elem, stmts_elem := check_get_children_2(elem, "decls", "stmts")
-			decls := map_array(dom_to_ast_decl, decls_elem.Children)
-			stmts := map_array(dom_to_ast_stmt, stmts_elem.Children)
-			return &StmtCompound{decls, stmts}
+			decls := ast.MapArray(dom_to_ast_decl, decls_elem.Children)
+			stmts := ast.MapArray(dom_to_ast_stmt, stmts_elem.Children)
+			return &ast.StmtCompound{Decls: decls, Stmts: stmts}
 		}
 	case "if":
 		{ // <if><cond>expr</cond><then>stmt</then><else>stmt</else></if>
@@ -289,12 +309,12 @@ func dom_to_ast_stmt(elem *xmldom.Node) Stmt {
 				cond := dom_to_ast_expr(check_get_child_1(cond_elem))
 				then_stmt := dom_to_ast_stmt(check_get_child_1(then_elem))
 				else_stmt := dom_to_ast_stmt(check_get_child_1(else_elem))
-				return &StmtIf{cond, then_stmt, else_stmt}
+				return &ast.StmtIf{Cond: cond, ThenStmt: then_stmt, ElseStmt: else_stmt}
 			} else if len(children) == 2 {
 				cond_elem, then_elem := check_get_children_2(elem, "cond", "then")
 				cond := dom_to_ast_expr(check_get_child_1(cond_elem))
 				then_stmt := dom_to_ast_stmt(check_get_child_1(then_elem))
-				return &StmtIf{cond, then_stmt, nil}
+				return &ast.StmtIf{Cond: cond, ThenStmt: then_stmt, ElseStmt: nil}
 			}
 			invalid_xml(elem)
 		}
@@ -303,24 +323,27 @@ func dom_to_ast_stmt(elem *xmldom.Node) Stmt {
 			cond_elem, body_elem := check_get_children_2(elem, "cond", "body")
 			cond := dom_to_ast_expr(check_get_child_1(cond_elem))
 			body := dom_to_ast_stmt(check_get_child_1(body_elem))
-			return &StmtWhile{cond, body}
+			return &ast.StmtWhile{Cond: cond, Body: body}
 		}
 	case "for":
 		{ // <for><init>…</init><cond>…</cond><post>…</post><body>…</body></for>
 			initElem, condElem, postElem, bodyElem :=
 				check_get_children_4(elem, "init", "cond", "post", "body")
 			init := dom_to_ast_stmt(check_get_child_1(initElem))
-			cond := dom_to_ast_expr(check_get_child_1(condElem))
+			var cond ast.Expr // <cond/>: for (;;)
+			if len(condElem.Children) > 0 {
+				cond = dom_to_ast_expr(check_get_child_1(condElem))
+			}
 			post := dom_to_ast_stmt(check_get_child_1(postElem))
 			body := dom_to_ast_stmt(check_get_child_1(bodyElem))
-			return &StmtFor{init, cond, post, body}
+			return &ast.StmtFor{Init: init, Cond: cond, Post: post, Body: body}
 		}
 	case "decl_init":
 		{ // <decl_init>...</decl_init>
 			declElem, initElem := check_get_children_2(elem, "decl", "init")
 			d := dom_to_ast_decl(declElem) // そのまま渡す
 			initExpr := dom_to_ast_expr(check_get_child_1(initElem))
-			return &StmtDeclInit{d, initExpr}
+			return &ast.StmtDeclInit{Decl: d, Init: initExpr}
 		}
 	}
 	invalid_xml(elem)
@@ -328,7 +351,7 @@ func dom_to_ast_stmt(elem *xmldom.Node) Stmt {
 }
 
 /* dom tree for a function parameter -> ast for parameter */
-func dom_to_ast_param(elem *xmldom.Node) *Decl {
+func dom_to_ast_param(elem *xmldom.Node) *ast.Decl {
 	// <param><type>type_expr</type><name>x</name></param>
 	switch elem.Name {
 	case "param":
@@ -336,7 +359,7 @@ func dom_to_ast_param(elem *xmldom.Node) *Decl {
 			param_type_elem, param_name_elem := check_get_children_2(elem, "type", "name")
 			param_type := dom_to_ast_type(check_get_child_1(param_type_elem))
 			param_name := check_get_text(param_name_elem)
-			return &Decl{param_type, param_name}
+			return &ast.Decl{VarType: param_type, Name: param_name}
 		}
 	}
 	invalid_xml(elem)
@@ -348,7 +371,7 @@ dom tree for a toplevel definition -> ast for a toplevel definition
 
 	(note: only possible toplevel definition in minc is a function definition)
 */
-func dom_to_ast_def(elem *xmldom.Node) Def {
+func dom_to_ast_def(elem *xmldom.Node) ast.Def {
 	switch elem.Name {
 	case "fun_def":
 		/* <fun_def>
@@ -359,41 +382,41 @@ func dom_to_ast_def(elem *xmldom.Node) Def {
 		  </fun_def> */
 		name_elem, params_elem, return_type_elem, body_elem := check_get_children_4(elem, "name", "params", "return_type", "body")
 		name := check_get_text(name_elem)
-		params := map_array(dom_to_ast_param, params_elem.Children)
+		params := ast.MapArray(dom_to_ast_param, params_elem.Children)
 		return_type := dom_to_ast_type(check_get_child_1(return_type_elem))
 		body := dom_to_ast_stmt(check_get_child_1(body_elem))
-		return &DefFun{name, params, return_type, body}
+		return &ast.DefFun{Name: name, Params: params, ReturnType: return_type, Body: body}
 	}
 	invalid_xml(elem)
 	return nil
 }
 
 /* dom tree for a program -> ast for a program */
-func dom_to_ast_program(elem *xmldom.Node) *Program {
+func dom_to_ast_program(elem *xmldom.Node) *ast.Program {
 	//* <program>DEF ...</program>
 	switch elem.Name {
 	case "program":
-		defs := map_array(dom_to_ast_def, elem.Children)
-		return &Program{defs}
+		defs := ast.MapArray(dom_to_ast_def, elem.Children)
+		return &ast.Program{Defs: defs}
 	}
 	invalid_xml(elem)
 	return nil
 }
 
-/* XML string -> abstract syntax tree */
-func str_to_ast(s string) *Program {
-	dom := str_to_dom(s)
-	if dom == nil {
-		return nil
-	}
-	return dom_to_ast_program(dom)
-}
-
-/* XML file -> abstract syntax tree */
-func file_xml_to_ast(file_xml string) *Program {
-	contentb, err := os.ReadFile(file_xml)
+/* XML string -> abstract syntax tree (file is for the diagnostics) */
+func XML(s string, file string) (program *ast.Program, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*xmlError)
+			if !ok {
+				panic(r)
+			}
+			program, err = nil, diag.CompileError([]diag.Diagnostic{{File: file, Msg: e.Error()}})
+		}
+	}()
+	dom, err := str_to_dom(s)
 	if err != nil {
-		log.Fatal(err)
+		return nil, diag.CompileError([]diag.Diagnostic{{File: file, Msg: err.Error()}})
 	}
-	return str_to_ast(string(contentb))
+	return dom_to_ast_program(dom), nil
 }
@@ -0,0 +1,157 @@
+package parse
+
+/* minc_toxml
+
+   the other way round from minc_parse: the AST of a program to the
+   XML parser/minc_to_xml.py makes of its source, printed as it
+   prints it (minc --emit=xml):
+
+            xml_of_ast_{type,expr,stmt,...}
+   AST ---------------------------------------> xml (DOM) tree
+
+            to_str
+       ---------------------------------------> XML
+
+   so that XML, parsed (XML), gives the same AST back.
+*/
+
+import (
+	"fmt"
+	"strings"
+
+	"minc/ast"
+)
+
+/* an element, or a text (tag "") */
+type xmlNode struct {
+	tag      string
+	text     string
+	children []*xmlNode
+}
+
+func node(tag string, children ...*xmlNode) *xmlNode {
+	return &xmlNode{tag: tag, children: children}
+}
+
+/* <tag>text</tag> */
+func textNode(tag string, text string) *xmlNode {
+	return node(tag, &xmlNode{text: text})
+}
+
+var xmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+/*
+as python's toprettyxml(indent=" "): an element of a text alone on
+one line, an empty one as <tag/>, others their children one space
+further in
+*/
+func (n *xmlNode) to_str(sb *strings.Builder, depth int, attrs string) {
+	indent := strings.Repeat(" ", depth)
+	switch {
+	case len(n.children) == 0:
+		fmt.Fprintf(sb, "%s<%s%s/>\n", indent, n.tag, attrs)
+	case len(n.children) == 1 && n.children[0].tag == "":
+		fmt.Fprintf(sb, "%s<%s%s>%s</%s>\n", indent, n.tag, attrs, xmlEscaper.Replace(n.children[0].text), n.tag)
+	default:
+		fmt.Fprintf(sb, "%s<%s%s>\n", indent, n.tag, attrs)
+		for _, c := range n.children {
+			c.to_str(sb, depth+1, "")
+		}
+		fmt.Fprintf(sb, "%s</%s>\n", indent, n.tag)
+	}
+}
+
+/* long -> <primitive_type>long</primitive_type> */
+func xml_of_ast_type(t ast.TypeExpr) *xmlNode {
+	return textNode("primitive_type", t.TypeString())
+}
+
+/* x + y -> <bin_op><op>+</op><left>...</left><right>...</right></bin_op>, ... */
+func xml_of_ast_expr(expr ast.Expr) *xmlNode {
+	switch e := expr.(type) {
+	case *ast.ExprIntLiteral:
+		return textNode("int_literal", fmt.Sprintf("%d", e.Val))
+	case *ast.ExprId:
+		return textNode("var", e.Name)
+	case *ast.ExprOp:
+		if len(e.Args) == 1 {
+			return node("un_op", textNode("op", e.Op), node("arg", xml_of_ast_expr(e.Args[0])))
+		}
+		return node("bin_op", textNode("op", e.Op),
+			node("left", xml_of_ast_expr(e.Args[0])),
+			node("right", xml_of_ast_expr(e.Args[1])))
+	case *ast.ExprCall:
+		return node("call", node("fun", xml_of_ast_expr(e.Fun)),
+			node("args", ast.MapArray(xml_of_ast_expr, e.Args)...))
+	case *ast.ExprParen:
+		return node("paren", xml_of_ast_expr(e.SubExpr))
+	}
+	panic(fmt.Sprintf("xml_of_ast_expr: %T", expr))
+}
+
+/* long x; -> <decl><type>...</type><name>x</name></decl> */
+func xml_of_ast_decl(tag string, decl *ast.Decl) *xmlNode {
+	return node(tag, node("type", xml_of_ast_type(decl.VarType)), textNode("name", decl.Name))
+}
+
+/* the children of an element holding an expression, if there is one */
+func optExpr(expr ast.Expr) []*xmlNode {
+	if expr == nil {
+		return nil
+	}
+	return []*xmlNode{xml_of_ast_expr(expr)}
+}
+
+/* return x; -> <return><var>x</var></return>, ... */
+func xml_of_ast_stmt(stmt ast.Stmt) *xmlNode {
+	switch s := stmt.(type) {
+	case *ast.StmtEmpty:
+		return node("empty")
+	case *ast.StmtContinue:
+		return node("continue")
+	case *ast.StmtBreak:
+		return node("break")
+	case *ast.StmtReturn:
+		return node("return", xml_of_ast_expr(s.Expr))
+	case *ast.StmtExpr:
+		return node("expr_stmt", xml_of_ast_expr(s.Expr))
+	case *ast.StmtCompound:
+		decls := ast.MapArray(func(d *ast.Decl) *xmlNode { return xml_of_ast_decl("decl", d) }, s.Decls)
+		return node("compound", node("decls", decls...), node("stmts", ast.MapArray(xml_of_ast_stmt, s.Stmts)...))
+	case *ast.StmtIf:
+		children := []*xmlNode{node("cond", xml_of_ast_expr(s.Cond)), node("then", xml_of_ast_stmt(s.ThenStmt))}
+		if s.ElseStmt != nil {
+			children = append(children, node("else", xml_of_ast_stmt(s.ElseStmt)))
+		}
+		return node("if", children...)
+	case *ast.StmtWhile:
+		return node("while", node("cond", xml_of_ast_expr(s.Cond)), node("body", xml_of_ast_stmt(s.Body)))
+	case *ast.StmtFor:
+		return node("for", node("init", xml_of_ast_stmt(s.Init)),
+			node("cond", optExpr(s.Cond)...),
+			node("post", xml_of_ast_stmt(s.Post)),
+			node("body", xml_of_ast_stmt(s.Body)))
+	case *ast.StmtDeclInit:
+		return node("decl_init", xml_of_ast_decl("decl", s.Decl), node("init", xml_of_ast_expr(s.Init)))
+	}
+	panic(fmt.Sprintf("xml_of_ast_stmt: %T", stmt))
+}
+
+/* long f(long x) { ... } -> <fun_def><name>f</name><params>...</params>...</fun_def> */
+func xml_of_ast_def(def ast.Def) *xmlNode {
+	switch d := def.(type) {
+	case *ast.DefFun:
+		params := ast.MapArray(func(p *ast.Decl) *xmlNode { return xml_of_ast_decl("param", p) }, d.Params)
+		return node("fun_def", textNode("name", d.Name), node("params", params...),
+			node("return_type", xml_of_ast_type(d.ReturnType)),
+			node("body", xml_of_ast_stmt(d.Body)))
+	}
+	panic(fmt.Sprintf("xml_of_ast_def: %T", def))
+}
+
+/* abstract syntax tree -> XML string (what XML parses back) */
+func ToXML(program *ast.Program) string {
+	sb := &strings.Builder{}
+	node("program", ast.MapArray(xml_of_ast_def, program.Defs)...).to_str(sb, 0, ` xmlns="https://program.com"`)
+	return sb.String()
+}
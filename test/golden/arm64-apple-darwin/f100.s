@@ -0,0 +1,132 @@
+.section __TEXT,__text,regular,pure_instructions
+.p2align 2
+.globl _count_down
+_count_down:
+  stp x29, x30, [sp, #-16]!
+  mov x29, sp
+  sub sp, sp, #64
+  str x0, [sp, #0]
+  str x1, [sp, #8]
+L.tail.1:
+  ldr x0, [x29, #-64]
+  cbnz x0, L.else.2
+  ldr x0, [x29, #-56]
+  add sp, sp, #64
+  ldp x29, x30, [sp], #16
+  ret
+  b L.end.2
+L.else.2:
+L.end.2:
+  ldr x0, [x29, #-64]
+  str x0, [sp, #16]
+  mov x1, #1
+  sub x0, x0, x1
+  str x0, [sp, #16]
+  ldr x0, [x29, #-56]
+  str x0, [sp, #32]
+  ldr x0, [x29, #-64]
+  str x0, [sp, #48]
+  mov x1, #7
+  sdiv x2, x0, x1
+  msub x1, x2, x1, x0
+  ldr x0, [sp, #32]
+  add x0, x0, x1
+  str x0, [sp, #32]
+  mov x1, x0
+  ldr x0, [sp, #16]
+  str x0, [sp, #0]
+  str x1, [sp, #8]
+  b L.tail.1
+.globl _bounce
+_bounce:
+  stp x29, x30, [sp, #-16]!
+  mov x29, sp
+  sub sp, sp, #48
+  str x0, [sp, #0]
+  str x1, [sp, #8]
+L.tail.3:
+  ldr x0, [x29, #-48]
+  cmp x0, #0
+  b.gt L.else.4
+  ldr x0, [x29, #-40]
+  add sp, sp, #48
+  ldp x29, x30, [sp], #16
+  ret
+  b L.end.4
+L.else.4:
+L.end.4:
+  ldr x0, [x29, #-48]
+  str x0, [sp, #16]
+  ldr x0, [x29, #-40]
+  str x0, [sp, #32]
+  mov x1, #3
+  mul x0, x0, x1
+  str x0, [sp, #32]
+  movz x0, #0x4243, lsl #0
+  movk x0, #0xf, lsl #16
+  mov x1, x0
+  ldr x0, [sp, #32]
+  sdiv x2, x0, x1
+  msub x0, x2, x1, x0
+  str x0, [sp, #32]
+  mov x1, x0
+  ldr x0, [sp, #16]
+  add sp, sp, #48
+  ldp x29, x30, [sp], #16
+  b _count_down
+.globl _f
+_f:
+  stp x29, x30, [sp, #-16]!
+  mov x29, sp
+  sub sp, sp, #80
+  str x0, [sp, #0]
+  str x1, [sp, #8]
+L.tail.5:
+  movz x0, #0x4240, lsl #0
+  movk x0, #0xf, lsl #16
+  str x0, [sp, #48]
+  ldr x0, [x29, #-80]
+  str x0, [sp, #64]
+  mov x1, #1000
+  sdiv x2, x0, x1
+  msub x1, x2, x1, x0
+  ldr x0, [sp, #48]
+  add x0, x0, x1
+  str x0, [sp, #16]
+  ldr x0, [x29, #-72]
+  str x0, [sp, #48]
+  mov x1, #100
+  sdiv x2, x0, x1
+  msub x0, x2, x1, x0
+  str x0, [sp, #24]
+  ldr x0, [sp, #16]
+  cmp x0, #0
+  b.gt L.else.6
+  ldr x0, [sp, #24]
+  str x0, [sp, #32]
+  b L.end.6
+L.else.6:
+  ldr x0, [sp, #16]
+  str x0, [sp, #48]
+  ldr x0, [sp, #24]
+  str x0, [sp, #64]
+  mov x1, #3
+  mul x0, x0, x1
+  str x0, [sp, #64]
+  movz x0, #0x4243, lsl #0
+  movk x0, #0xf, lsl #16
+  mov x1, x0
+  ldr x0, [sp, #64]
+  sdiv x2, x0, x1
+  msub x0, x2, x1, x0
+  str x0, [sp, #64]
+  mov x1, x0
+  ldr x0, [sp, #48]
+  bl _count_down
+  str x0, [sp, #32]
+L.end.6:
+  ldr x0, [sp, #32]
+  add sp, sp, #80
+  ldp x29, x30, [sp], #16
+  ret
+.subsections_via_symbols
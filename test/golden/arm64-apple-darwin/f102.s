@@ -0,0 +1,57 @@
+.section __TEXT,__text,regular,pure_instructions
+.p2align 2
+.globl _g
+_g:
+  stp x29, x30, [sp, #-16]!
+  mov x29, sp
+  sub sp, sp, #32
+  str x0, [sp, #0]
+L.tail.1:
+  ldr x0, [x29, #-32]
+  str x0, [sp, #16]
+  mov x1, #3
+  mul x0, x0, x1
+  str x0, [sp, #16]
+  mov x1, #1
+  add x0, x0, x1
+  add sp, sp, #32
+  ldp x29, x30, [sp], #16
+  ret
+.globl _f
+_f:
+  stp x29, x30, [sp, #-16]!
+  mov x29, sp
+  sub sp, sp, #48
+  str x0, [sp, #0]
+  str x1, [sp, #8]
+L.tail.2:
+  adrp x0, _g@PAGE
+  add x0, x0, _g@PAGEOFF
+  cbz x0, L.else.3
+  ldr x0, [x29, #-48]
+  str x0, [sp, #32]
+  mov x1, #1000
+  sdiv x2, x0, x1
+  msub x0, x2, x1, x0
+  str x0, [sp, #16]
+  str x0, [sp, #32]
+  mov x1, #3
+  mul x0, x0, x1
+  str x0, [sp, #32]
+  mov x1, #1
+  add x0, x0, x1
+  str x0, [sp, #24]
+  str x0, [sp, #32]
+  ldr x1, [x29, #-40]
+  add x0, x0, x1
+  add sp, sp, #48
+  ldp x29, x30, [sp], #16
+  ret
+  b L.end.3
+L.else.3:
+L.end.3:
+  mov x0, #0
+  add sp, sp, #48
+  ldp x29, x30, [sp], #16
+  ret
+.subsections_via_symbols
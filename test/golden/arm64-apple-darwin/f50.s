@@ -0,0 +1,35 @@
+.section __TEXT,__text,regular,pure_instructions
+.p2align 2
+.globl _f21
+_f21:
+  stp x29, x30, [sp, #-16]!
+  mov x29, sp
+  sub sp, sp, #96
+  str x0, [sp, #0]
+  str x1, [sp, #8]
+  str x2, [sp, #16]
+  str x3, [sp, #24]
+  str x4, [sp, #32]
+  str x5, [sp, #40]
+  str x6, [sp, #48]
+  str x7, [sp, #56]
+L.tail.1:
+  ldr x0, [x29, #-72]
+  add sp, sp, #96
+  ldp x29, x30, [sp], #16
+  ret
+.globl _f
+_f:
+  stp x29, x30, [sp, #-16]!
+  mov x29, sp
+  sub sp, sp, #32
+L.tail.2:
+  mov x0, #400
+  str x0, [sp, #0]
+  str x0, [sp, #16]
+  mov x1, #10
+  add x0, x0, x1
+  add sp, sp, #32
+  ldp x29, x30, [sp], #16
+  ret
+.subsections_via_symbols
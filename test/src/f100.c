@@ -0,0 +1,14 @@
+long count_down(long n, long acc) {
+  if (n == 0) return acc;
+  return count_down(n - 1, acc + n % 7);
+}
+
+long bounce(long n, long acc) {
+  if (n <= 0) return acc;
+  return count_down(n, acc * 3 % 1000003);
+}
+
+long f(long x, long y) {
+  // 末尾呼び出しの最適化のテスト: 100万段の再帰
+  return bounce(1000000 + x % 1000, y % 100);
+}
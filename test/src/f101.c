@@ -0,0 +1,209 @@
+long f(long x, long y) {
+  long v0; long v1; long v2; long v3; long v4; long v5; long v6; long v7;
+  long v8; long v9; long v10; long v11; long v12; long v13; long v14; long v15;
+  long v16; long v17; long v18; long v19; long v20; long v21; long v22; long v23;
+  long v24; long v25; long v26; long v27; long v28; long v29; long v30; long v31;
+  long v32; long v33; long v34; long v35; long v36; long v37; long v38; long v39;
+  long v40; long v41; long v42; long v43; long v44; long v45; long v46; long v47;
+  long v48; long v49; long v50; long v51; long v52; long v53; long v54; long v55;
+  long v56; long v57; long v58; long v59; long v60; long v61; long v62; long v63;
+  long v64; long v65; long v66; long v67; long v68; long v69; long v70; long v71;
+  long v72; long v73; long v74; long v75; long v76; long v77; long v78; long v79;
+  long v80; long v81; long v82; long v83; long v84; long v85; long v86; long v87;
+  long v88; long v89; long v90; long v91; long v92; long v93; long v94; long v95;
+  long v96; long v97; long v98; long v99; long v100; long v101; long v102; long v103;
+  long v104; long v105; long v106; long v107; long v108; long v109; long v110; long v111;
+  long v112; long v113; long v114; long v115; long v116; long v117; long v118; long v119;
+  long v120; long v121; long v122; long v123; long v124; long v125; long v126; long v127;
+  long v128; long v129; long v130; long v131; long v132; long v133; long v134; long v135;
+  long v136; long v137; long v138; long v139; long v140; long v141; long v142; long v143;
+  long v144; long v145; long v146; long v147; long v148; long v149; long v150; long v151;
+  long v152; long v153; long v154; long v155; long v156; long v157; long v158; long v159;
+  long v160; long v161; long v162; long v163; long v164; long v165; long v166; long v167;
+  long v168; long v169; long v170; long v171; long v172; long v173; long v174; long v175;
+  long v176; long v177; long v178; long v179; long v180; long v181; long v182; long v183;
+  long v184; long v185; long v186; long v187; long v188; long v189; long v190; long v191;
+  long v192; long v193; long v194; long v195; long v196; long v197; long v198; long v199;
+  long v200; long v201; long v202; long v203; long v204; long v205; long v206; long v207;
+  long v208; long v209; long v210; long v211; long v212; long v213; long v214; long v215;
+  long v216; long v217; long v218; long v219; long v220; long v221; long v222; long v223;
+  long v224; long v225; long v226; long v227; long v228; long v229; long v230; long v231;
+  long v232; long v233; long v234; long v235; long v236; long v237; long v238; long v239;
+  long v240; long v241; long v242; long v243; long v244; long v245; long v246; long v247;
+  long v248; long v249; long v250; long v251; long v252; long v253; long v254; long v255;
+  long v256; long v257; long v258; long v259; long v260; long v261; long v262; long v263;
+  long v264; long v265; long v266; long v267; long v268; long v269; long v270; long v271;
+  long v272; long v273; long v274; long v275; long v276; long v277; long v278; long v279;
+  long v280; long v281; long v282; long v283; long v284; long v285; long v286; long v287;
+  long v288; long v289; long v290; long v291; long v292; long v293; long v294; long v295;
+  long v296; long v297; long v298; long v299; long v300; long v301; long v302; long v303;
+  long v304; long v305; long v306; long v307; long v308; long v309; long v310; long v311;
+  long v312; long v313; long v314; long v315; long v316; long v317; long v318; long v319;
+  long v320; long v321; long v322; long v323; long v324; long v325; long v326; long v327;
+  long v328; long v329; long v330; long v331; long v332; long v333; long v334; long v335;
+  long v336; long v337; long v338; long v339; long v340; long v341; long v342; long v343;
+  long v344; long v345; long v346; long v347; long v348; long v349; long v350; long v351;
+  long v352; long v353; long v354; long v355; long v356; long v357; long v358; long v359;
+  long v360; long v361; long v362; long v363; long v364; long v365; long v366; long v367;
+  long v368; long v369; long v370; long v371; long v372; long v373; long v374; long v375;
+  long v376; long v377; long v378; long v379; long v380; long v381; long v382; long v383;
+  long v384; long v385; long v386; long v387; long v388; long v389; long v390; long v391;
+  long v392; long v393; long v394; long v395; long v396; long v397; long v398; long v399;
+  long v400; long v401; long v402; long v403; long v404; long v405; long v406; long v407;
+  long v408; long v409; long v410; long v411; long v412; long v413; long v414; long v415;
+  long v416; long v417; long v418; long v419; long v420; long v421; long v422; long v423;
+  long v424; long v425; long v426; long v427; long v428; long v429; long v430; long v431;
+  long v432; long v433; long v434; long v435; long v436; long v437; long v438; long v439;
+  long v440; long v441; long v442; long v443; long v444; long v445; long v446; long v447;
+  long v448; long v449; long v450; long v451; long v452; long v453; long v454; long v455;
+  long v456; long v457; long v458; long v459; long v460; long v461; long v462; long v463;
+  long v464; long v465; long v466; long v467; long v468; long v469; long v470; long v471;
+  long v472; long v473; long v474; long v475; long v476; long v477; long v478; long v479;
+  long v480; long v481; long v482; long v483; long v484; long v485; long v486; long v487;
+  long v488; long v489; long v490; long v491; long v492; long v493; long v494; long v495;
+  long v496; long v497; long v498; long v499; long v500; long v501; long v502; long v503;
+  long v504; long v505; long v506; long v507; long v508; long v509; long v510; long v511;
+  long v512; long v513; long v514; long v515; long v516; long v517; long v518; long v519;
+  long s;
+  long t;
+  v0 = x;
+  v1 = v0 - (y + 1); v2 = v1 - (y + 2); v3 = v2 - (y + 3); v4 = v3 - (y + 4);
+  v5 = v4 - (y + 5); v6 = v5 - (y + 6); v7 = v6 - (y + 7); v8 = v7 - (y + 8);
+  v9 = v8 - (y + 9); v10 = v9 - (y + 10); v11 = v10 - (y + 11); v12 = v11 - (y + 12);
+  v13 = v12 - (y + 13); v14 = v13 - (y + 14); v15 = v14 - (y + 15); v16 = v15 - (y + 16);
+  v17 = v16 - (y + 17); v18 = v17 - (y + 18); v19 = v18 - (y + 19); v20 = v19 - (y + 20);
+  v21 = v20 - (y + 21); v22 = v21 - (y + 22); v23 = v22 - (y + 23); v24 = v23 - (y + 24);
+  v25 = v24 - (y + 25); v26 = v25 - (y + 26); v27 = v26 - (y + 27); v28 = v27 - (y + 28);
+  v29 = v28 - (y + 29); v30 = v29 - (y + 30); v31 = v30 - (y + 31); v32 = v31 - (y + 32);
+  v33 = v32 - (y + 33); v34 = v33 - (y + 34); v35 = v34 - (y + 35); v36 = v35 - (y + 36);
+  v37 = v36 - (y + 37); v38 = v37 - (y + 38); v39 = v38 - (y + 39); v40 = v39 - (y + 40);
+  v41 = v40 - (y + 41); v42 = v41 - (y + 42); v43 = v42 - (y + 43); v44 = v43 - (y + 44);
+  v45 = v44 - (y + 45); v46 = v45 - (y + 46); v47 = v46 - (y + 47); v48 = v47 - (y + 48);
+  v49 = v48 - (y + 49); v50 = v49 - (y + 50); v51 = v50 - (y + 51); v52 = v51 - (y + 52);
+  v53 = v52 - (y + 53); v54 = v53 - (y + 54); v55 = v54 - (y + 55); v56 = v55 - (y + 56);
+  v57 = v56 - (y + 57); v58 = v57 - (y + 58); v59 = v58 - (y + 59); v60 = v59 - (y + 60);
+  v61 = v60 - (y + 61); v62 = v61 - (y + 62); v63 = v62 - (y + 63); v64 = v63 - (y + 64);
+  v65 = v64 - (y + 65); v66 = v65 - (y + 66); v67 = v66 - (y + 67); v68 = v67 - (y + 68);
+  v69 = v68 - (y + 69); v70 = v69 - (y + 70); v71 = v70 - (y + 71); v72 = v71 - (y + 72);
+  v73 = v72 - (y + 73); v74 = v73 - (y + 74); v75 = v74 - (y + 75); v76 = v75 - (y + 76);
+  v77 = v76 - (y + 77); v78 = v77 - (y + 78); v79 = v78 - (y + 79); v80 = v79 - (y + 80);
+  v81 = v80 - (y + 81); v82 = v81 - (y + 82); v83 = v82 - (y + 83); v84 = v83 - (y + 84);
+  v85 = v84 - (y + 85); v86 = v85 - (y + 86); v87 = v86 - (y + 87); v88 = v87 - (y + 88);
+  v89 = v88 - (y + 89); v90 = v89 - (y + 90); v91 = v90 - (y + 91); v92 = v91 - (y + 92);
+  v93 = v92 - (y + 93); v94 = v93 - (y + 94); v95 = v94 - (y + 95); v96 = v95 - (y + 96);
+  v97 = v96 - (y + 97); v98 = v97 - (y + 98); v99 = v98 - (y + 99); v100 = v99 - (y + 100);
+  v101 = v100 - (y + 101); v102 = v101 - (y + 102); v103 = v102 - (y + 103); v104 = v103 - (y + 104);
+  v105 = v104 - (y + 105); v106 = v105 - (y + 106); v107 = v106 - (y + 107); v108 = v107 - (y + 108);
+  v109 = v108 - (y + 109); v110 = v109 - (y + 110); v111 = v110 - (y + 111); v112 = v111 - (y + 112);
+  v113 = v112 - (y + 113); v114 = v113 - (y + 114); v115 = v114 - (y + 115); v116 = v115 - (y + 116);
+  v117 = v116 - (y + 117); v118 = v117 - (y + 118); v119 = v118 - (y + 119); v120 = v119 - (y + 120);
+  v121 = v120 - (y + 121); v122 = v121 - (y + 122); v123 = v122 - (y + 123); v124 = v123 - (y + 124);
+  v125 = v124 - (y + 125); v126 = v125 - (y + 126); v127 = v126 - (y + 127); v128 = v127 - (y + 128);
+  v129 = v128 - (y + 129); v130 = v129 - (y + 130); v131 = v130 - (y + 131); v132 = v131 - (y + 132);
+  v133 = v132 - (y + 133); v134 = v133 - (y + 134); v135 = v134 - (y + 135); v136 = v135 - (y + 136);
+  v137 = v136 - (y + 137); v138 = v137 - (y + 138); v139 = v138 - (y + 139); v140 = v139 - (y + 140);
+  v141 = v140 - (y + 141); v142 = v141 - (y + 142); v143 = v142 - (y + 143); v144 = v143 - (y + 144);
+  v145 = v144 - (y + 145); v146 = v145 - (y + 146); v147 = v146 - (y + 147); v148 = v147 - (y + 148);
+  v149 = v148 - (y + 149); v150 = v149 - (y + 150); v151 = v150 - (y + 151); v152 = v151 - (y + 152);
+  v153 = v152 - (y + 153); v154 = v153 - (y + 154); v155 = v154 - (y + 155); v156 = v155 - (y + 156);
+  v157 = v156 - (y + 157); v158 = v157 - (y + 158); v159 = v158 - (y + 159); v160 = v159 - (y + 160);
+  v161 = v160 - (y + 161); v162 = v161 - (y + 162); v163 = v162 - (y + 163); v164 = v163 - (y + 164);
+  v165 = v164 - (y + 165); v166 = v165 - (y + 166); v167 = v166 - (y + 167); v168 = v167 - (y + 168);
+  v169 = v168 - (y + 169); v170 = v169 - (y + 170); v171 = v170 - (y + 171); v172 = v171 - (y + 172);
+  v173 = v172 - (y + 173); v174 = v173 - (y + 174); v175 = v174 - (y + 175); v176 = v175 - (y + 176);
+  v177 = v176 - (y + 177); v178 = v177 - (y + 178); v179 = v178 - (y + 179); v180 = v179 - (y + 180);
+  v181 = v180 - (y + 181); v182 = v181 - (y + 182); v183 = v182 - (y + 183); v184 = v183 - (y + 184);
+  v185 = v184 - (y + 185); v186 = v185 - (y + 186); v187 = v186 - (y + 187); v188 = v187 - (y + 188);
+  v189 = v188 - (y + 189); v190 = v189 - (y + 190); v191 = v190 - (y + 191); v192 = v191 - (y + 192);
+  v193 = v192 - (y + 193); v194 = v193 - (y + 194); v195 = v194 - (y + 195); v196 = v195 - (y + 196);
+  v197 = v196 - (y + 197); v198 = v197 - (y + 198); v199 = v198 - (y + 199); v200 = v199 - (y + 200);
+  v201 = v200 - (y + 201); v202 = v201 - (y + 202); v203 = v202 - (y + 203); v204 = v203 - (y + 204);
+  v205 = v204 - (y + 205); v206 = v205 - (y + 206); v207 = v206 - (y + 207); v208 = v207 - (y + 208);
+  v209 = v208 - (y + 209); v210 = v209 - (y + 210); v211 = v210 - (y + 211); v212 = v211 - (y + 212);
+  v213 = v212 - (y + 213); v214 = v213 - (y + 214); v215 = v214 - (y + 215); v216 = v215 - (y + 216);
+  v217 = v216 - (y + 217); v218 = v217 - (y + 218); v219 = v218 - (y + 219); v220 = v219 - (y + 220);
+  v221 = v220 - (y + 221); v222 = v221 - (y + 222); v223 = v222 - (y + 223); v224 = v223 - (y + 224);
+  v225 = v224 - (y + 225); v226 = v225 - (y + 226); v227 = v226 - (y + 227); v228 = v227 - (y + 228);
+  v229 = v228 - (y + 229); v230 = v229 - (y + 230); v231 = v230 - (y + 231); v232 = v231 - (y + 232);
+  v233 = v232 - (y + 233); v234 = v233 - (y + 234); v235 = v234 - (y + 235); v236 = v235 - (y + 236);
+  v237 = v236 - (y + 237); v238 = v237 - (y + 238); v239 = v238 - (y + 239); v240 = v239 - (y + 240);
+  v241 = v240 - (y + 241); v242 = v241 - (y + 242); v243 = v242 - (y + 243); v244 = v243 - (y + 244);
+  v245 = v244 - (y + 245); v246 = v245 - (y + 246); v247 = v246 - (y + 247); v248 = v247 - (y + 248);
+  v249 = v248 - (y + 249); v250 = v249 - (y + 250); v251 = v250 - (y + 251); v252 = v251 - (y + 252);
+  v253 = v252 - (y + 253); v254 = v253 - (y + 254); v255 = v254 - (y + 255); v256 = v255 - (y + 256);
+  v257 = v256 - (y + 257); v258 = v257 - (y + 258); v259 = v258 - (y + 259); v260 = v259 - (y + 260);
+  v261 = v260 - (y + 261); v262 = v261 - (y + 262); v263 = v262 - (y + 263); v264 = v263 - (y + 264);
+  v265 = v264 - (y + 265); v266 = v265 - (y + 266); v267 = v266 - (y + 267); v268 = v267 - (y + 268);
+  v269 = v268 - (y + 269); v270 = v269 - (y + 270); v271 = v270 - (y + 271); v272 = v271 - (y + 272);
+  v273 = v272 - (y + 273); v274 = v273 - (y + 274); v275 = v274 - (y + 275); v276 = v275 - (y + 276);
+  v277 = v276 - (y + 277); v278 = v277 - (y + 278); v279 = v278 - (y + 279); v280 = v279 - (y + 280);
+  v281 = v280 - (y + 281); v282 = v281 - (y + 282); v283 = v282 - (y + 283); v284 = v283 - (y + 284);
+  v285 = v284 - (y + 285); v286 = v285 - (y + 286); v287 = v286 - (y + 287); v288 = v287 - (y + 288);
+  v289 = v288 - (y + 289); v290 = v289 - (y + 290); v291 = v290 - (y + 291); v292 = v291 - (y + 292);
+  v293 = v292 - (y + 293); v294 = v293 - (y + 294); v295 = v294 - (y + 295); v296 = v295 - (y + 296);
+  v297 = v296 - (y + 297); v298 = v297 - (y + 298); v299 = v298 - (y + 299); v300 = v299 - (y + 300);
+  v301 = v300 - (y + 301); v302 = v301 - (y + 302); v303 = v302 - (y + 303); v304 = v303 - (y + 304);
+  v305 = v304 - (y + 305); v306 = v305 - (y + 306); v307 = v306 - (y + 307); v308 = v307 - (y + 308);
+  v309 = v308 - (y + 309); v310 = v309 - (y + 310); v311 = v310 - (y + 311); v312 = v311 - (y + 312);
+  v313 = v312 - (y + 313); v314 = v313 - (y + 314); v315 = v314 - (y + 315); v316 = v315 - (y + 316);
+  v317 = v316 - (y + 317); v318 = v317 - (y + 318); v319 = v318 - (y + 319); v320 = v319 - (y + 320);
+  v321 = v320 - (y + 321); v322 = v321 - (y + 322); v323 = v322 - (y + 323); v324 = v323 - (y + 324);
+  v325 = v324 - (y + 325); v326 = v325 - (y + 326); v327 = v326 - (y + 327); v328 = v327 - (y + 328);
+  v329 = v328 - (y + 329); v330 = v329 - (y + 330); v331 = v330 - (y + 331); v332 = v331 - (y + 332);
+  v333 = v332 - (y + 333); v334 = v333 - (y + 334); v335 = v334 - (y + 335); v336 = v335 - (y + 336);
+  v337 = v336 - (y + 337); v338 = v337 - (y + 338); v339 = v338 - (y + 339); v340 = v339 - (y + 340);
+  v341 = v340 - (y + 341); v342 = v341 - (y + 342); v343 = v342 - (y + 343); v344 = v343 - (y + 344);
+  v345 = v344 - (y + 345); v346 = v345 - (y + 346); v347 = v346 - (y + 347); v348 = v347 - (y + 348);
+  v349 = v348 - (y + 349); v350 = v349 - (y + 350); v351 = v350 - (y + 351); v352 = v351 - (y + 352);
+  v353 = v352 - (y + 353); v354 = v353 - (y + 354); v355 = v354 - (y + 355); v356 = v355 - (y + 356);
+  v357 = v356 - (y + 357); v358 = v357 - (y + 358); v359 = v358 - (y + 359); v360 = v359 - (y + 360);
+  v361 = v360 - (y + 361); v362 = v361 - (y + 362); v363 = v362 - (y + 363); v364 = v363 - (y + 364);
+  v365 = v364 - (y + 365); v366 = v365 - (y + 366); v367 = v366 - (y + 367); v368 = v367 - (y + 368);
+  v369 = v368 - (y + 369); v370 = v369 - (y + 370); v371 = v370 - (y + 371); v372 = v371 - (y + 372);
+  v373 = v372 - (y + 373); v374 = v373 - (y + 374); v375 = v374 - (y + 375); v376 = v375 - (y + 376);
+  v377 = v376 - (y + 377); v378 = v377 - (y + 378); v379 = v378 - (y + 379); v380 = v379 - (y + 380);
+  v381 = v380 - (y + 381); v382 = v381 - (y + 382); v383 = v382 - (y + 383); v384 = v383 - (y + 384);
+  v385 = v384 - (y + 385); v386 = v385 - (y + 386); v387 = v386 - (y + 387); v388 = v387 - (y + 388);
+  v389 = v388 - (y + 389); v390 = v389 - (y + 390); v391 = v390 - (y + 391); v392 = v391 - (y + 392);
+  v393 = v392 - (y + 393); v394 = v393 - (y + 394); v395 = v394 - (y + 395); v396 = v395 - (y + 396);
+  v397 = v396 - (y + 397); v398 = v397 - (y + 398); v399 = v398 - (y + 399); v400 = v399 - (y + 400);
+  v401 = v400 - (y + 401); v402 = v401 - (y + 402); v403 = v402 - (y + 403); v404 = v403 - (y + 404);
+  v405 = v404 - (y + 405); v406 = v405 - (y + 406); v407 = v406 - (y + 407); v408 = v407 - (y + 408);
+  v409 = v408 - (y + 409); v410 = v409 - (y + 410); v411 = v410 - (y + 411); v412 = v411 - (y + 412);
+  v413 = v412 - (y + 413); v414 = v413 - (y + 414); v415 = v414 - (y + 415); v416 = v415 - (y + 416);
+  v417 = v416 - (y + 417); v418 = v417 - (y + 418); v419 = v418 - (y + 419); v420 = v419 - (y + 420);
+  v421 = v420 - (y + 421); v422 = v421 - (y + 422); v423 = v422 - (y + 423); v424 = v423 - (y + 424);
+  v425 = v424 - (y + 425); v426 = v425 - (y + 426); v427 = v426 - (y + 427); v428 = v427 - (y + 428);
+  v429 = v428 - (y + 429); v430 = v429 - (y + 430); v431 = v430 - (y + 431); v432 = v431 - (y + 432);
+  v433 = v432 - (y + 433); v434 = v433 - (y + 434); v435 = v434 - (y + 435); v436 = v435 - (y + 436);
+  v437 = v436 - (y + 437); v438 = v437 - (y + 438); v439 = v438 - (y + 439); v440 = v439 - (y + 440);
+  v441 = v440 - (y + 441); v442 = v441 - (y + 442); v443 = v442 - (y + 443); v444 = v443 - (y + 444);
+  v445 = v444 - (y + 445); v446 = v445 - (y + 446); v447 = v446 - (y + 447); v448 = v447 - (y + 448);
+  v449 = v448 - (y + 449); v450 = v449 - (y + 450); v451 = v450 - (y + 451); v452 = v451 - (y + 452);
+  v453 = v452 - (y + 453); v454 = v453 - (y + 454); v455 = v454 - (y + 455); v456 = v455 - (y + 456);
+  v457 = v456 - (y + 457); v458 = v457 - (y + 458); v459 = v458 - (y + 459); v460 = v459 - (y + 460);
+  v461 = v460 - (y + 461); v462 = v461 - (y + 462); v463 = v462 - (y + 463); v464 = v463 - (y + 464);
+  v465 = v464 - (y + 465); v466 = v465 - (y + 466); v467 = v466 - (y + 467); v468 = v467 - (y + 468);
+  v469 = v468 - (y + 469); v470 = v469 - (y + 470); v471 = v470 - (y + 471); v472 = v471 - (y + 472);
+  v473 = v472 - (y + 473); v474 = v473 - (y + 474); v475 = v474 - (y + 475); v476 = v475 - (y + 476);
+  v477 = v476 - (y + 477); v478 = v477 - (y + 478); v479 = v478 - (y + 479); v480 = v479 - (y + 480);
+  v481 = v480 - (y + 481); v482 = v481 - (y + 482); v483 = v482 - (y + 483); v484 = v483 - (y + 484);
+  v485 = v484 - (y + 485); v486 = v485 - (y + 486); v487 = v486 - (y + 487); v488 = v487 - (y + 488);
+  v489 = v488 - (y + 489); v490 = v489 - (y + 490); v491 = v490 - (y + 491); v492 = v491 - (y + 492);
+  v493 = v492 - (y + 493); v494 = v493 - (y + 494); v495 = v494 - (y + 495); v496 = v495 - (y + 496);
+  v497 = v496 - (y + 497); v498 = v497 - (y + 498); v499 = v498 - (y + 499); v500 = v499 - (y + 500);
+  v501 = v500 - (y + 501); v502 = v501 - (y + 502); v503 = v502 - (y + 503); v504 = v503 - (y + 504);
+  v505 = v504 - (y + 505); v506 = v505 - (y + 506); v507 = v506 - (y + 507); v508 = v507 - (y + 508);
+  v509 = v508 - (y + 509); v510 = v509 - (y + 510); v511 = v510 - (y + 511); v512 = v511 - (y + 512);
+  v513 = v512 - (y + 513); v514 = v513 - (y + 514); v515 = v514 - (y + 515); v516 = v515 - (y + 516);
+  v517 = v516 - (y + 517); v518 = v517 - (y + 518); v519 = v518 - (y + 519);
+  s = 9223372036854775807;
+  t = -9223372036854775807 - 1;
+  s = s + t / 128 - 4886718345 + -4886718345;
+  s = s - 71777214294589695 - 196608 + -65537 - 1311768467463790320;
+  if (x > 4886718345) s = s - 1;
+  if (y < -4886718345) s = s - 1;
+  if (t < 0)
+    if (s != 9223372036854775807) s = s - 2;
+  return s - v519 + v260 * 3 - v0;
+}
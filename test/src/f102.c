@@ -0,0 +1,9 @@
+long g(long x) {
+  return x * 3 + 1;
+}
+
+long f(long a, long b) {
+  if (g != 0)
+    return g(a % 1000) + b;
+  return 0;
+}
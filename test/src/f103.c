@@ -0,0 +1,11 @@
+long g(long a, long b, long c, long d, long e, long u, long v, long w) {
+  return a - b + c - d + e - u + v - w;
+}
+
+long f(long x, long y) {
+  long s;
+  x = x % 1000;
+  y = y % 1000;
+  s = x + (x + (x + (x + (x + (x + (x + (x + (x + (x + (x + (x + (x + (x + (x + (x + (x + (x + (x + (x + (x + (x + (x + (x + (x + 1))))))))))))))))))))))));
+  return s + (x - (y - (x + g(x, 1, 2, 3, 4, 5, 6, y - g(y, 6, 5, 4, 3, 2, 1, x + (y - (x - (y + 1))))))));
+}